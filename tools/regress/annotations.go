@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Bug: <url> and Note: <text> explain a test: the issue it's for and why
+the expected output is what it is. They don't change how the test runs,
+we show them with failures and save them in results.json, csv, JUnit
+(see junit.go) and show them in regress serve so that a failure in any
+report links to the original issue.
+*/
+
+func parseBug(pos string, val string) string {
+	panicIf(val == "" || strings.ContainsAny(val, " \t"), "%s: Bug: must be an url, got '%s'\n", pos, val)
+	return val
+}
+
+func dumpAnnotations(t *Test) {
+	if t.Bug != "" {
+		fmt.Printf("Bug: %s\n", t.Bug)
+	}
+	for _, s := range t.Notes {
+		fmt.Printf("Note: %s\n", s)
+	}
+}
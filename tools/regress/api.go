@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+JSON api served by regress serve, for dashboards and bots:
+/runs                     : list of runs, newest first
+/runs/${id}/tests         : results of tests in a run, ?failed=1 to only get failures
+/tests/${name}/history    : results of a test (by name or key) in all runs
+*/
+
+// APIRun is a run in /runs
+type APIRun struct {
+	ID      string
+	Started time.Time
+	Tests   int
+	Failed  int
+}
+
+// APITestHistory is a result of a test in a run in /tests/${name}/history
+type APITestHistory struct {
+	RunID   string
+	Started time.Time
+	Result  *TestResult
+}
+
+func serveJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func serveJSONError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	v := map[string]string{
+		"Error": msg,
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+func handleAPIRuns(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs"), "/")
+	if path == "" {
+		res := []*APIRun{}
+		for _, run := range loadHistory() {
+			ar := &APIRun{
+				ID:      run.ID,
+				Started: run.Started,
+				Tests:   len(run.Tests),
+				Failed:  countFailed(run),
+			}
+			res = append(res, ar)
+		}
+		serveJSON(w, res)
+		return
+	}
+	id := strings.TrimSuffix(path, "/tests")
+	if id == path {
+		serveJSONError(w, "not found", http.StatusNotFound)
+		return
+	}
+	run, err := loadHistoryRun(id)
+	if err != nil {
+		serveJSONError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	failedOnly := r.FormValue("failed") == "1"
+	res := []*TestResult{}
+	for _, tr := range run.Tests {
+		if !failedOnly || tr.Failed {
+			res = append(res, tr)
+		}
+	}
+	serveJSON(w, res)
+}
+
+func handleAPITests(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/tests/")
+	name := strings.TrimSuffix(path, "/history")
+	if name == path || name == "" {
+		serveJSONError(w, "not found", http.StatusNotFound)
+		return
+	}
+	res := []*APITestHistory{}
+	for _, run := range loadHistory() {
+		for _, tr := range run.Tests {
+			if tr.Name != name && tr.Key != name && resultID(tr) != name {
+				continue
+			}
+			th := &APITestHistory{
+				RunID:   run.ID,
+				Started: run.Started,
+				Result:  tr,
+			}
+			res = append(res, th)
+		}
+	}
+	serveJSON(w, res)
+}
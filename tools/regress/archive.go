@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*
+-archive-s3 bucket/prefix uploads results of the run so that they are kept
+longer than CI logs. The layout is:
+
+prefix/2024/01/31/20240131-020000-abc1234/
+  summary.json  - RunArchiveSummary, used by archive-prune
+  milestone.txt - with -milestone, archive-prune doesn't delete milestones
+  results.json
+  report.html   - the run page of regress serve
+  artifacts/<n>/<file> - artifacts of failed tests
+*/
+
+// RunArchiveSummary describes an archived run
+type RunArchiveSummary struct {
+	ID     string
+	GitSha string `json:",omitempty"`
+	Tests  int
+	Failed int
+}
+
+// gitShortSha returns sha of checked out commit, "" if not in git repo
+func gitShortSha() string {
+	out, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// archiveRunDir returns e.g. 2024/01/31/20240131-020000-abc1234
+func archiveRunDir(gitSha string) string {
+	dir := runStarted.Format("2006/01/02") + "/" + runID()
+	if gitSha != "" {
+		dir += "-" + gitSha
+	}
+	return dir
+}
+
+func renderRunReport(run *RunResults) ([]byte, error) {
+	var buf bytes.Buffer
+	err := serveTemplates.ExecuteTemplate(&buf, "run", runPageData(run, false, ""))
+	return buf.Bytes(), err
+}
+
+func archiveRun(c *s3Client, prefix string, tests []*Test) error {
+	gitSha := gitShortSha()
+	dir := path.Join(prefix, archiveRunDir(gitSha))
+	run := testsToRunResults(tests)
+	summary := &RunArchiveSummary{
+		ID:     run.ID,
+		GitSha: gitSha,
+		Tests:  len(run.Tests),
+		Failed: countFailed(run),
+	}
+	d, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = c.put(dir+"/summary.json", d); err != nil {
+		return err
+	}
+	if flgMilestone != "" {
+		if err = c.put(dir+"/"+archiveMilestoneName, []byte(flgMilestone+"\n")); err != nil {
+			return err
+		}
+	}
+	d, err = json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = c.put(dir+"/results.json", d); err != nil {
+		return err
+	}
+	d, err = renderRunReport(run)
+	if err != nil {
+		return err
+	}
+	if err = c.put(dir+"/report.html", d); err != nil {
+		return err
+	}
+	nArtifacts := 0
+	nNotPublished := 0
+	for i, t := range tests {
+		if !isFailedTest(t) {
+			continue
+		}
+		// artifacts are derived from the test file e.g. rendered pages
+		if !canPublishTestFile(t) {
+			nNotPublished++
+			continue
+		}
+		for _, a := range t.Artifacts {
+			key := fmt.Sprintf("%s/artifacts/%d/%s", dir, i, filepath.Base(a))
+			if err = c.putFile(key, a); err != nil {
+				return err
+			}
+			nArtifacts++
+		}
+	}
+	fmt.Printf("archived run to s3://%s/%s (%d artifacts)\n", c.bucket, dir, nArtifacts)
+	if nNotPublished > 0 {
+		fmt.Printf("didn't archive artifacts of %d tests because of -licenses\n", nNotPublished)
+	}
+	return nil
+}
+
+func archiveRunToS3(tests []*Test) {
+	if flgArchiveS3 == "" {
+		return
+	}
+	bucket, prefix := parseS3Dest(flgArchiveS3)
+	c := newS3ClientMust(bucket)
+	err := archiveRun(c, prefix, tests)
+	if err != nil {
+		// the run itself is fine, don't fail it
+		fmt.Printf("failed to archive the run to s3: %s\n", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+regress archive-prune -s3 bucket/prefix deletes runs archived with
+-archive-s3 that are older than -keep-days or beyond the newest -keep-runs.
+
+We keep everything for runs that had failures and for milestones (runs
+archived with -milestone). For other runs we only keep summary.json so
+that we still know they happened.
+*/
+
+const archiveMilestoneName = "milestone.txt"
+
+// matches 2024/01/31/20240131-020000 or 2024/01/31/20240131-020000-abc1234
+var rxArchiveRunDir = regexp.MustCompile(`(?:^|/)(\d{4}/\d{2}/\d{2}/(\d{8}-\d{6})[^/]*)/`)
+
+type archivedRun struct {
+	dir     string // key prefix of the run, without trailing /
+	started time.Time
+	keys    []string
+}
+
+func (r *archivedRun) hasFile(name string) bool {
+	for _, k := range r.keys {
+		if strings.HasSuffix(k, "/"+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupArchivedRuns returns runs sorted newest first
+func groupArchivedRuns(keys []string) []*archivedRun {
+	byDir := map[string]*archivedRun{}
+	var res []*archivedRun
+	for _, k := range keys {
+		loc := rxArchiveRunDir.FindStringSubmatchIndex(k)
+		if loc == nil {
+			continue
+		}
+		dir := k[:loc[3]]
+		r := byDir[dir]
+		if r == nil {
+			started, err := time.Parse("20060102-150405", k[loc[4]:loc[5]])
+			if err != nil {
+				continue
+			}
+			r = &archivedRun{dir: dir, started: started}
+			byDir[dir] = r
+			res = append(res, r)
+		}
+		r.keys = append(r.keys, k)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].started.After(res[j].started)
+	})
+	return res
+}
+
+func archivedRunFailed(c *s3Client, r *archivedRun) (bool, error) {
+	d, err := c.get(r.dir + "/summary.json")
+	if err != nil {
+		return false, err
+	}
+	var s RunArchiveSummary
+	err = json.Unmarshal(d, &s)
+	return s.Failed > 0, err
+}
+
+func archivePrune(args []string) {
+	fs := flag.NewFlagSet("archive-prune", flag.ExitOnError)
+	dest := fs.String("s3", flgArchiveS3, "bucket/prefix with archived runs")
+	keepDays := fs.Int("keep-days", 90, "keep all data of runs newer than this many days")
+	keepRuns := fs.Int("keep-runs", 0, "keep all data of this many newest runs, even if older than -keep-days")
+	dryRun := fs.Bool("dry-run", false, "only show what would be deleted")
+	fs.Parse(args)
+	panicIf(*dest == "", "archive-prune: need -s3 bucket/prefix\n")
+	bucket, prefix := parseS3Dest(*dest)
+	c := newS3ClientMust(bucket)
+	listPrefix := prefix
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+	keys, err := c.list(listPrefix)
+	fatalIfErr(err)
+	runs := groupArchivedRuns(keys)
+	cutoff := time.Now().Add(-time.Duration(*keepDays) * 24 * time.Hour)
+	nDeleted := 0
+	for i, r := range runs {
+		if i < *keepRuns || r.started.After(cutoff) {
+			continue
+		}
+		if r.hasFile(archiveMilestoneName) {
+			continue
+		}
+		failed, err := archivedRunFailed(c, r)
+		if err != nil {
+			fmt.Printf("%s: can't tell if it failed, keeping it: %s\n", r.dir, err)
+			continue
+		}
+		if failed {
+			continue
+		}
+		for _, k := range r.keys {
+			if strings.HasSuffix(k, "/summary.json") {
+				continue
+			}
+			fmt.Printf("deleting s3://%s/%s\n", bucket, k)
+			if *dryRun {
+				continue
+			}
+			err = c.delete(k)
+			fatalIfErr(err)
+			nDeleted++
+		}
+	}
+	fmt.Printf("%d archived runs, deleted %d files\n", len(runs), nDeleted)
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+Assert: is an expression that must be true for the test to pass.
+It can be used instead of Out: for output that is noisy or long:
+
+Assert: contains("rendering page 1") && lineCount() == 1
+Assert: matches(/zoom: [0-9.]+/)
+Assert: exitCode == 0 && durationMs < 500
+
+Functions:
+contains("s")  : output contains s
+matches(/re/)  : output matches regular expression re
+lineCount()    : number of lines in output
+zoom(page), renderMs(page), loadMs(page), pageWidth(page), pageHeight(page),
+pageCount(), renderedPages() : values from render output, see renderlog.go
+
+Variables:
+exitCode       : exit code of the process
+durationMs     : how long the process ran, in milliseconds
+
+Operators: == != < <= > >= && || ! and parentheses.
+Strings use Go syntax, / in regexp can be escaped as \/.
+Numbers can have a fraction (5.25) and a sign (-1), comparing with a value
+missing in the output (e.g. zoom of a page that wasn't rendered) is always
+false.
+If an assertion uses exitCode, non-zero exit code doesn't fail the test.
+
+For common cases there are shortcuts that become assertions:
+OutContains: foo   : contains("foo")
+OutLineCount: 3    : lineCount() == 3
+OutNotContains: foo: !contains("foo")
+OutNotRegex: re    : !matches(/re/)
+OutRegex: re       : matches(/\A(?:re)\z/) i.e. re must match all of output
+ExitCode: 3        : exitCode == 3
+*/
+
+// Assert is a parsed Assert: line
+type Assert struct {
+	Text   string
+	LineNo int
+	// line from test file if it's a shortcut like OutContains:
+	Field string
+	expr  *assertNode
+}
+
+const (
+	assertBool = iota
+	assertInt
+	assertString
+	assertRegexp
+	assertFloat
+)
+
+var assertTypeNames = []string{"bool", "int", "string", "regexp", "number"}
+
+type assertNode struct {
+	op   string // lit, var, call or operator
+	typ  int
+	val  interface{}
+	name string
+	args []*assertNode
+}
+
+type assertParser struct {
+	pos  int
+	toks []string
+}
+
+func isIdentChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+func tokenizeAssert(s string) ([]string, error) {
+	var toks []string
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case isIdentChar(c, true):
+			start := i
+			for i < len(s) && isIdentChar(s[i], false) {
+				i++
+			}
+			toks = append(toks, s[start:i])
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+				i++
+			}
+			if i+1 < len(s) && s[i] == '.' && s[i+1] >= '0' && s[i+1] <= '9' {
+				i++
+				for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+					i++
+				}
+			}
+			toks = append(toks, s[start:i])
+		case c == '"' || c == '/':
+			start := i
+			i++
+			for i < len(s) && s[i] != c {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated %c", c)
+			}
+			i++
+			toks = append(toks, s[start:i])
+		default:
+			op := ""
+			for _, o := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", ",", "-"} {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected '%c'", c)
+			}
+			toks = append(toks, op)
+			i += len(op)
+		}
+	}
+	return toks, nil
+}
+
+func (p *assertParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *assertParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *assertParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		return fmt.Errorf("expected '%s', got '%s'", tok, got)
+	}
+	return nil
+}
+
+func checkAssertType(n *assertNode, typ int, what string) error {
+	if n.typ != typ {
+		return fmt.Errorf("%s must be %s, is %s", what, assertTypeNames[typ], assertTypeNames[n.typ])
+	}
+	return nil
+}
+
+func (p *assertParser) parseOr() (*assertNode, error) {
+	return p.parseBinary([]string{"||"}, p.parseAnd)
+}
+
+func (p *assertParser) parseAnd() (*assertNode, error) {
+	return p.parseBinary([]string{"&&"}, p.parseUnary)
+}
+
+func (p *assertParser) parseBinary(ops []string, parseOperand func() (*assertNode, error)) (*assertNode, error) {
+	left, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for ops[0] == p.peek() {
+		op := p.next()
+		right, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err = checkAssertType(left, assertBool, "left side of "+op); err != nil {
+			return nil, err
+		}
+		if err = checkAssertType(right, assertBool, "right side of "+op); err != nil {
+			return nil, err
+		}
+		left = &assertNode{op: op, typ: assertBool, args: []*assertNode{left, right}}
+	}
+	return left, nil
+}
+
+func (p *assertParser) parseUnary() (*assertNode, error) {
+	if p.peek() != "!" {
+		return p.parseCompare()
+	}
+	p.next()
+	n, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if err = checkAssertType(n, assertBool, "argument of !"); err != nil {
+		return nil, err
+	}
+	return &assertNode{op: "!", typ: assertBool, args: []*assertNode{n}}, nil
+}
+
+func (p *assertParser) parseCompare() (*assertNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	left, right = promoteToFloat(left, right), promoteToFloat(right, left)
+	if left.typ != right.typ {
+		return nil, fmt.Errorf("can't compare %s and %s", assertTypeNames[left.typ], assertTypeNames[right.typ])
+	}
+	if left.typ == assertRegexp || (left.typ == assertBool && op != "==" && op != "!=") {
+		return nil, fmt.Errorf("can't use %s on %s", op, assertTypeNames[left.typ])
+	}
+	return &assertNode{op: op, typ: assertBool, args: []*assertNode{left, right}}, nil
+}
+
+// promoteToFloat converts int n to number if other is a number
+func promoteToFloat(n *assertNode, other *assertNode) *assertNode {
+	if n.typ == assertInt && other.typ == assertFloat {
+		return &assertNode{op: "float", typ: assertFloat, args: []*assertNode{n}}
+	}
+	return n
+}
+
+var assertFuncs = map[string][]int{
+	// name => [result type, arg types...]
+	"contains":      {assertBool, assertString},
+	"matches":       {assertBool, assertRegexp},
+	"lineCount":     {assertInt},
+	"zoom":          {assertFloat, assertInt},
+	"renderMs":      {assertFloat, assertInt},
+	"loadMs":        {assertFloat, assertInt},
+	"pageWidth":     {assertFloat, assertInt},
+	"pageHeight":    {assertFloat, assertInt},
+	"pageCount":     {assertInt},
+	"renderedPages": {assertInt},
+}
+
+var assertVars = []string{"exitCode", "durationMs"}
+
+func (p *assertParser) parsePrimary() (*assertNode, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case tok == "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case tok[0] == '"':
+		s, err := strconv.Unquote(tok)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", tok)
+		}
+		return &assertNode{op: "lit", typ: assertString, val: s}, nil
+	case tok[0] == '/':
+		s := strings.Replace(tok[1:len(tok)-1], `\/`, "/", -1)
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, err
+		}
+		return &assertNode{op: "lit", typ: assertRegexp, val: re}, nil
+	case tok[0] >= '0' && tok[0] <= '9' && strings.Contains(tok, "."):
+		n, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &assertNode{op: "lit", typ: assertFloat, val: n}, nil
+	case tok[0] >= '0' && tok[0] <= '9':
+		n, err := strconv.ParseInt(tok, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &assertNode{op: "lit", typ: assertInt, val: n}, nil
+	case tok == "-":
+		return p.parseNegative()
+	case tok == "true" || tok == "false":
+		return &assertNode{op: "lit", typ: assertBool, val: tok == "true"}, nil
+	case isIdentChar(tok[0], true):
+		if p.peek() == "(" {
+			return p.parseCall(tok)
+		}
+		for _, name := range assertVars {
+			if name == tok {
+				return &assertNode{op: "var", typ: assertInt, name: tok}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown variable '%s'", tok)
+	}
+	return nil, fmt.Errorf("unexpected '%s'", tok)
+}
+
+// parseNegative is for exit codes of crashes which are often negative
+func (p *assertParser) parseNegative() (*assertNode, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if n.typ != assertInt && n.typ != assertFloat {
+		return nil, fmt.Errorf("can't use - on %s", assertTypeNames[n.typ])
+	}
+	if n.op != "lit" {
+		return &assertNode{op: "neg", typ: n.typ, args: []*assertNode{n}}, nil
+	}
+	if v, ok := n.val.(int64); ok {
+		n.val = -v
+	} else {
+		n.val = -n.val.(float64)
+	}
+	return n, nil
+}
+
+func (p *assertParser) parseCall(name string) (*assertNode, error) {
+	sig, ok := assertFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%s'", name)
+	}
+	p.next() // (
+	n := &assertNode{op: "call", typ: sig[0], name: name}
+	for p.peek() != ")" {
+		if len(n.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		n.args = append(n.args, arg)
+	}
+	p.next() // )
+	argTypes := sig[1:]
+	if len(n.args) != len(argTypes) {
+		return nil, fmt.Errorf("%s() takes %d arguments, got %d", name, len(argTypes), len(n.args))
+	}
+	for i, arg := range n.args {
+		if err := checkAssertType(arg, argTypes[i], fmt.Sprintf("argument %d of %s()", i+1, name)); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func parseAssertExpr(s string) (*assertNode, error) {
+	toks, err := tokenizeAssert(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &assertParser{toks: toks}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected '%s'", p.peek())
+	}
+	if err = checkAssertType(n, assertBool, "expression"); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func parseAssert(pos string, lineNo int, val string) *Assert {
+	expr, err := parseAssertExpr(val)
+	panicIf(err != nil, "%s: invalid Assert: '%s': %s\n", pos, val, err)
+	return &Assert{
+		Text:   val,
+		LineNo: lineNo,
+		expr:   expr,
+	}
+}
+
+func parseOutContains(pos string, lineNo int, val string) *Assert {
+	panicIf(val == "", "%s: OutContains: can't be empty\n", pos)
+	a := parseAssert(pos, lineNo, "contains("+strconv.Quote(val)+")")
+	a.Field = "OutContains: " + val
+	return a
+}
+
+func parseOutLineCount(pos string, lineNo int, val string) *Assert {
+	n, err := strconv.Atoi(val)
+	panicIf(err != nil || n < 0, "%s: OutLineCount: must be a number >= 0, got '%s'\n", pos, val)
+	a := parseAssert(pos, lineNo, fmt.Sprintf("lineCount() == %d", n))
+	a.Field = "OutLineCount: " + val
+	return a
+}
+
+func parseOutNotContains(pos string, lineNo int, val string) *Assert {
+	panicIf(val == "", "%s: OutNotContains: can't be empty\n", pos)
+	a := parseAssert(pos, lineNo, "!contains("+strconv.Quote(val)+")")
+	a.Field = "OutNotContains: " + val
+	return a
+}
+
+func parseOutNotRegex(pos string, lineNo int, val string) *Assert {
+	_, err := regexp.Compile(val)
+	panicIf(val == "" || err != nil, "%s: OutNotRegex: invalid regexp '%s'\n", pos, val)
+	a := parseAssert(pos, lineNo, "!matches(/"+escapeAssertRegexp(val)+"/)")
+	a.Field = "OutNotRegex: " + val
+	return a
+}
+
+// escapeAssertRegexp escapes / so that re can be put in /re/
+func escapeAssertRegexp(re string) string {
+	re = strings.Replace(re, `\/`, "/", -1)
+	return strings.Replace(re, "/", `\/`, -1)
+}
+
+// parseOutRegex is for output with timings or paths that can't be matched
+// exactly, unlike matches() the whole output must match
+func parseOutRegex(pos string, lineNo int, val string) *Assert {
+	_, err := regexp.Compile(val)
+	panicIf(val == "" || err != nil, "%s: OutRegex: invalid regexp '%s'\n", pos, val)
+	a := parseAssert(pos, lineNo, `matches(/\A(?:`+escapeAssertRegexp(val)+`)\z/)`)
+	a.Field = "OutRegex: " + val
+	return a
+}
+
+// parseExitCode is for tests of how SumatraPDF fails e.g. on corrupt files
+func parseExitCode(pos string, lineNo int, val string) *Assert {
+	n, err := strconv.ParseInt(val, 0, 64)
+	panicIf(err != nil, "%s: ExitCode: must be a number, got '%s'\n", pos, val)
+	a := parseAssert(pos, lineNo, fmt.Sprintf("exitCode == %d", n))
+	a.Field = "ExitCode: " + val
+	return a
+}
+
+func outputLineCount(s string) int64 {
+	if s == "" {
+		return 0
+	}
+	return int64(len(strings.Split(s, "\n")))
+}
+
+func evalAssertNode(n *assertNode, t *Test) interface{} {
+	switch n.op {
+	case "lit":
+		return n.val
+	case "float":
+		return float64(evalAssertNode(n.args[0], t).(int64))
+	case "neg":
+		if v, ok := evalAssertNode(n.args[0], t).(int64); ok {
+			return -v
+		}
+		return -evalAssertNode(n.args[0], t).(float64)
+	case "var":
+		if n.name == "exitCode" {
+			return int64(t.ExitCode)
+		}
+		return t.Duration.Milliseconds()
+	case "call":
+		switch n.name {
+		case "contains":
+			return strings.Contains(t.Output, evalAssertNode(n.args[0], t).(string))
+		case "matches":
+			return evalAssertNode(n.args[0], t).(*regexp.Regexp).MatchString(t.Output)
+		case "lineCount":
+			return outputLineCount(t.Output)
+		case "pageCount":
+			return int64(testRenderLog(t).PageCount)
+		case "renderedPages":
+			return int64(len(testRenderLog(t).Pages))
+		}
+		p := testRenderLog(t).findPage(int(evalAssertNode(n.args[0], t).(int64)))
+		switch n.name {
+		case "zoom":
+			return p.Zoom
+		case "renderMs":
+			return p.RenderMs
+		case "loadMs":
+			return p.LoadMs
+		case "pageWidth":
+			return p.Width
+		case "pageHeight":
+			return p.Height
+		}
+	case "!":
+		return !evalAssertNode(n.args[0], t).(bool)
+	case "&&":
+		return evalAssertNode(n.args[0], t).(bool) && evalAssertNode(n.args[1], t).(bool)
+	case "||":
+		return evalAssertNode(n.args[0], t).(bool) || evalAssertNode(n.args[1], t).(bool)
+	}
+	v1, v2 := evalAssertNode(n.args[0], t), evalAssertNode(n.args[1], t)
+	if f1, ok := v1.(float64); ok && (math.IsNaN(f1) || math.IsNaN(v2.(float64))) {
+		return false
+	}
+	switch n.op {
+	case "==":
+		return v1 == v2
+	case "!=":
+		return v1 != v2
+	}
+	var cmp int
+	switch a := v1.(type) {
+	case int64:
+		b := v2.(int64)
+		if a < b {
+			cmp = -1
+		} else if a > b {
+			cmp = 1
+		}
+	case float64:
+		b := v2.(float64)
+		if a < b {
+			cmp = -1
+		} else if a > b {
+			cmp = 1
+		}
+	case string:
+		cmp = strings.Compare(a, v2.(string))
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func assertUsesVar(n *assertNode, name string) bool {
+	if n.op == "var" && n.name == name {
+		return true
+	}
+	for _, arg := range n.args {
+		if assertUsesVar(arg, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func assertTexts(t *Test) []string {
+	var res []string
+	for _, a := range t.Asserts {
+		res = append(res, a.Text)
+	}
+	return res
+}
+
+// checksExitCode returns true if the test decides itself what exit code is ok
+func checksExitCode(t *Test) bool {
+	for _, a := range t.Asserts {
+		if assertUsesVar(a.expr, "exitCode") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAsserts returns a reason for each assertion that is false
+func checkAsserts(t *Test) []string {
+	var res []string
+	for _, a := range t.Asserts {
+		if evalAssertNode(a.expr, t).(bool) {
+			continue
+		}
+		var reason string
+		if a.Field != "" {
+			reason = fmt.Sprintf("%s failed (%s:%d)", a.Field, t.Path, a.LineNo)
+		} else {
+			reason = fmt.Sprintf("assertion failed: %s (%s:%d)", a.Text, t.Path, a.LineNo)
+		}
+		if assertUsesVar(a.expr, "exitCode") {
+			reason += fmt.Sprintf(", exit code was %d", t.ExitCode)
+		}
+		res = append(res, reason)
+	}
+	return res
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenizeAssert(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp []string
+	}{
+		{`exitCode == 0`, []string{"exitCode", "==", "0"}},
+		{`exitCode == -1`, []string{"exitCode", "==", "-", "1"}},
+		{`zoom(1)>=5.25&&!contains("a \"b\"")`, []string{"zoom", "(", "1", ")", ">=", "5.25", "&&", "!", "contains", "(", `"a \"b\""`, ")"}},
+		{`matches(/a\/b/) || lineCount() != 2`, []string{"matches", "(", `/a\/b/`, ")", "||", "lineCount", "(", ")", "!=", "2"}},
+	}
+	for _, tc := range tests {
+		got, err := tokenizeAssert(tc.s)
+		if err != nil {
+			t.Errorf("'%s': %s", tc.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("'%s': got %q, expected %q", tc.s, got, tc.exp)
+		}
+	}
+	for _, s := range []string{`contains("a`, `matches(/a)`, `exitCode # 1`} {
+		if _, err := tokenizeAssert(s); err == nil {
+			t.Errorf("'%s': expected an error", s)
+		}
+	}
+}
+
+func TestAssertNegativeNumbers(t *testing.T) {
+	tests := []struct {
+		expr     string
+		exitCode int
+		exp      bool
+	}{
+		{`exitCode == -1`, -1, true},
+		{`exitCode == -1`, 1, false},
+		{`exitCode < -5`, -10, true},
+		{`-exitCode == 3`, -3, true},
+		{`exitCode == - 1073741819`, -1073741819, true},
+		{`exitCode > -0.5`, 0, true},
+	}
+	for _, tc := range tests {
+		n, err := parseAssertExpr(tc.expr)
+		if err != nil {
+			t.Errorf("'%s': %s", tc.expr, err)
+			continue
+		}
+		got := evalAssertNode(n, &Test{ExitCode: tc.exitCode}).(bool)
+		if got != tc.exp {
+			t.Errorf("'%s' with exit code %d: got %v, expected %v", tc.expr, tc.exitCode, got, tc.exp)
+		}
+	}
+	for _, s := range []string{`exitCode == -`, `-contains("a")`, `exitCode == -"a"`} {
+		if _, err := parseAssertExpr(s); err == nil {
+			t.Errorf("'%s': expected an error", s)
+		}
+	}
+	a := parseExitCode("tests.txt:1", 1, "-1")
+	if !evalAssertNode(a.expr, &Test{ExitCode: -1}).(bool) {
+		t.Errorf("ExitCode: -1 doesn't match exit code -1")
+	}
+}
+
+func TestEvalAssert(t *testing.T) {
+	test := &Test{
+		Output:   "rendering page 1\nzoom: 1.25",
+		ExitCode: 3,
+		Duration: 250 * time.Millisecond,
+	}
+	tests := []struct {
+		expr string
+		exp  bool
+	}{
+		{`contains("rendering page 1")`, true},
+		{`contains("page 2")`, false},
+		{`!contains("page 2")`, true},
+		{`matches(/zoom: [0-9.]+/)`, true},
+		{`matches(/\Azoom/)`, false},
+		{`lineCount() == 2`, true},
+		{`lineCount() >= 3`, false},
+		{`exitCode == 3 && durationMs < 500`, true},
+		{`exitCode != 3 || durationMs > 200`, true},
+		{`exitCode == 0 || contains("page 2") && durationMs < 500`, false},
+		{`(exitCode == 3 || contains("page 2")) && durationMs < 500`, true},
+		{`durationMs <= 250.5`, true},
+	}
+	for _, tc := range tests {
+		n, err := parseAssertExpr(tc.expr)
+		if err != nil {
+			t.Errorf("'%s': %s", tc.expr, err)
+			continue
+		}
+		if got := evalAssertNode(n, test).(bool); got != tc.exp {
+			t.Errorf("'%s': got %v, expected %v", tc.expr, got, tc.exp)
+		}
+	}
+	for _, s := range []string{`contains(1)`, `lineCount() == "a"`, `exitCode`, `unknown()`, `exitCode == 1 &&`} {
+		if _, err := parseAssertExpr(s); err == nil {
+			t.Errorf("'%s': expected an error", s)
+		}
+	}
+}
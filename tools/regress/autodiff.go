@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+regress autodiff checks if the tested binaries changed since the last
+autodiff (by sha1 of .exe and .dll files in -bin-dir). If they did, it
+runs all tests with the previous binaries (kept in -cache) and with the
+current ones and shows which tests newly fail, pass or changed output,
+like diff-results. The report is saved in out/regress/autodiff.
+
+Regress flags given before autodiff are used for both runs, e.g.:
+
+regress -tests tools/regress/tests.txt autodiff
+
+Results of both runs are in the result cache (see resultcache.go) so
+running autodiff again with the same binaries is fast.
+*/
+
+const autodiffLastFile = "last.txt"
+
+// defaultBinDir is the dir verifyCommandsMust would pick
+func defaultBinDir() string {
+	if isOS64Bit() && dirExists("rel64") {
+		return "rel64"
+	}
+	return "rel"
+}
+
+func isBinaryFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".exe" || ext == ".dll"
+}
+
+// binDirSha1Hex returns sha1 of names and contents of binaries in dir
+func binDirSha1Hex(dir string) (string, []string, error) {
+	files, err := ioutil.ReadDir(longPath(dir))
+	if err != nil {
+		return "", nil, err
+	}
+	var names []string
+	for _, fi := range files {
+		if fi.Mode().IsRegular() && isBinaryFile(fi.Name()) {
+			names = append(names, fi.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", nil, fmt.Errorf("no .exe or .dll files in '%s'", dir)
+	}
+	sort.Strings(names)
+	h := sha1.New()
+	for _, name := range names {
+		s, err := sha1HexOfFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", nil, err
+		}
+		fmt.Fprintf(h, "%s %s\n", name, s)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), names, nil
+}
+
+func cacheBinaries(cacheDir string, srcDir string, sha1Hex string, names []string) (string, error) {
+	dir := filepath.Join(cacheDir, sha1Hex)
+	if dirExists(dir) {
+		return dir, nil
+	}
+	tmpDir := dir + ".tmp"
+	os.RemoveAll(longPath(tmpDir))
+	err := os.MkdirAll(longPath(tmpDir), 0755)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		dst := filepath.Join(tmpDir, name)
+		src := filepath.Join(srcDir, name)
+		err = copyFile(dst, src)
+		if err == nil {
+			// keep executable bit
+			var fi os.FileInfo
+			if fi, err = os.Stat(longPath(src)); err == nil {
+				err = os.Chmod(longPath(dst), fi.Mode())
+			}
+		}
+		if err != nil {
+			os.RemoveAll(longPath(tmpDir))
+			return "", err
+		}
+	}
+	return dir, os.Rename(longPath(tmpDir), longPath(dir))
+}
+
+// pruneBinariesCache keeps keep most recently used binaries
+func pruneBinariesCache(cacheDir string, keep int, inUse ...string) {
+	files, err := ioutil.ReadDir(longPath(cacheDir))
+	if err != nil {
+		return
+	}
+	var dirs []os.FileInfo
+	for _, fi := range files {
+		if fi.IsDir() && len(fi.Name()) == 40 {
+			dirs = append(dirs, fi)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].ModTime().After(dirs[j].ModTime())
+	})
+	for i, fi := range dirs {
+		isInUse := false
+		for _, s := range inUse {
+			isInUse = isInUse || fi.Name() == s
+		}
+		if i < keep || isInUse {
+			continue
+		}
+		fmt.Printf("removing old binaries %s\n", fi.Name())
+		os.RemoveAll(longPath(filepath.Join(cacheDir, fi.Name())))
+	}
+}
+
+// regressArgsBeforeCommand returns flags given before the command
+func regressArgsBeforeCommand(cmd string) []string {
+	args := os.Args[1:]
+	for i, arg := range args {
+		if arg == cmd {
+			return args[:i]
+		}
+	}
+	return args
+}
+
+// runRegressWithBinaries runs all tests in a sub-process, failures are fine
+func runRegressWithBinaries(binDir string, resultsPath string) (*RunResults, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(longPath(resultsPath))
+	args := append(regressArgsBeforeCommand("autodiff"), "-bin-dir", binDir, "-results", resultsPath)
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	fmt.Printf("Running: %s\n", cmdToStrLong(cmd))
+	runErr := cmd.Run()
+	if !fileExists(resultsPath) {
+		return nil, fmt.Errorf("run with '%s' didn't save results: %s", binDir, errStr(runErr))
+	}
+	return loadRunResults(resultsPath)
+}
+
+func autodiff(args []string) {
+	fs := flag.NewFlagSet("autodiff", flag.ExitOnError)
+	binDir := fs.String("bin-dir", "", "directory with tested binaries (default: same as tests, rel64 or rel)")
+	cacheDir := fs.String("cache", filepath.Join("out", "regress", "binaries"), "directory where previous binaries are kept")
+	keep := fs.Int("keep", 3, "how many binaries to keep in -cache")
+	fs.Parse(args)
+	panicIf(fs.NArg() != 0, "usage: regress [flags] autodiff [-bin-dir dir] [-cache dir] [-keep n]\n")
+	if *binDir == "" {
+		*binDir = flgBinDir
+	}
+	if *binDir == "" {
+		*binDir = defaultBinDir()
+	}
+
+	curSha1, names, err := binDirSha1Hex(*binDir)
+	fatalIfErr(err)
+	lastPath := filepath.Join(*cacheDir, autodiffLastFile)
+	prevSha1 := ""
+	if d, err := ioutil.ReadFile(longPath(lastPath)); err == nil {
+		prevSha1 = strings.TrimSpace(string(d))
+	}
+	if prevSha1 == curSha1 {
+		fmt.Printf("binaries in '%s' didn't change since the last autodiff (%s)\n", *binDir, curSha1[:8])
+		return
+	}
+	curDir, err := cacheBinaries(*cacheDir, *binDir, curSha1, names)
+	fatalIfErr(err)
+	prevDir := filepath.Join(*cacheDir, prevSha1)
+	if prevSha1 == "" || !dirExists(prevDir) {
+		fmt.Printf("no previous binaries to compare with, saved binaries in '%s' (%s) for the next autodiff\n", *binDir, curSha1[:8])
+		err = ioutil.WriteFile(longPath(lastPath), []byte(curSha1+"\n"), 0644)
+		fatalIfErr(err)
+		return
+	}
+	fmt.Printf("binaries in '%s' changed: %s => %s\n", *binDir, prevSha1[:8], curSha1[:8])
+
+	outDir := filepath.Join("out", "regress", "autodiff")
+	err = os.MkdirAll(longPath(outDir), 0755)
+	fatalIfErr(err)
+	a, err := runRegressWithBinaries(prevDir, filepath.Join(outDir, prevSha1[:8]+".json"))
+	fatalIfErr(err)
+	// run with a copy so that the binaries can be rebuilt while we run
+	b, err := runRegressWithBinaries(curDir, filepath.Join(outDir, curSha1[:8]+".json"))
+	fatalIfErr(err)
+	err = ioutil.WriteFile(longPath(lastPath), []byte(curSha1+"\n"), 0644)
+	fatalIfErr(err)
+	pruneBinariesCache(*cacheDir, *keep, prevSha1, curSha1)
+
+	diff := diffRunResults(a, b)
+	reportPath := filepath.Join(outDir, fmt.Sprintf("%s-%s.json", prevSha1[:8], curSha1[:8]))
+	d, err := json.MarshalIndent(diff, "", "  ")
+	fatalIfErr(err)
+	err = ioutil.WriteFile(longPath(reportPath), d, 0644)
+	fatalIfErr(err)
+
+	fmt.Printf("\nChanges from binaries %s to %s:\n", prevSha1[:8], curSha1[:8])
+	dumpNames("New failures", diff.NewFailures)
+	dumpNames("Newly passing", diff.NewlyPassing)
+	dumpNames("Output changed", diff.OutputChanged)
+	fmt.Printf("saved report to '%s'\n", reportPath)
+	if len(diff.NewFailures) > 0 {
+		os.Exit(1)
+	}
+}
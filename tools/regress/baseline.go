@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+)
+
+// BaselineDiff is a comparison of a run with a baseline run
+type BaselineDiff struct {
+	NewFailures  []*Test
+	NewlyFixed   []*Test
+	StillFailing []*Test
+}
+
+func testDisplayName(t *Test) string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return testKey(t)
+}
+
+// tests not in the baseline count as new failures if they fail
+func compareWithBaseline(tests []*Test, baseline *RunResults) *BaselineDiff {
+	failedBefore := map[string]bool{}
+	for _, r := range baseline.Tests {
+		failedBefore[resultID(r)] = r.Failed
+	}
+	res := &BaselineDiff{}
+	for _, t := range tests {
+		if !t.Done {
+			continue
+		}
+		failed := isFailedTest(t)
+		wasFailed := failedBefore[testID(t)]
+		switch {
+		case failed && wasFailed:
+			res.StillFailing = append(res.StillFailing, t)
+		case failed && t.KnownFailure == nil:
+			res.NewFailures = append(res.NewFailures, t)
+		case wasFailed:
+			res.NewlyFixed = append(res.NewlyFixed, t)
+		}
+	}
+	return res
+}
+
+func dumpTestList(title string, tests []*Test) {
+	fmt.Printf("%s: %d\n", title, len(tests))
+	for _, t := range tests {
+		fmt.Printf("  %s (%s)\n", testDisplayName(t), testPos(t))
+	}
+}
+
+// dumpBaselineDiff returns number of new failures
+func dumpBaselineDiff(tests []*Test, baseline *RunResults) int {
+	diff := compareWithBaseline(tests, baseline)
+	fmt.Printf("\nCompared with baseline '%s':\n", flgBaseline)
+	dumpTestList("New failures", diff.NewFailures)
+	dumpTestList("Newly fixed", diff.NewlyFixed)
+	dumpTestList("Still failing", diff.StillFailing)
+	return len(diff.NewFailures)
+}
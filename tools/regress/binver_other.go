@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// binaryVersion returns version from version resource of the binary,
+// which we can only read on Windows
+func binaryVersion(path string) string {
+	return ""
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modversion                  = syscall.NewLazyDLL("version.dll")
+	procGetFileVersionInfoSizeW = modversion.NewProc("GetFileVersionInfoSizeW")
+	procGetFileVersionInfoW     = modversion.NewProc("GetFileVersionInfoW")
+	procVerQueryValueW          = modversion.NewProc("VerQueryValueW")
+)
+
+// VS_FIXEDFILEINFO
+type fixedFileInfo struct {
+	Signature        uint32
+	StrucVersion     uint32
+	FileVersionMS    uint32
+	FileVersionLS    uint32
+	ProductVersionMS uint32
+	ProductVersionLS uint32
+	FileFlagsMask    uint32
+	FileFlags        uint32
+	FileOS           uint32
+	FileType         uint32
+	FileSubtype      uint32
+	FileDateMS       uint32
+	FileDateLS       uint32
+}
+
+// binaryVersion returns version from version resource of the binary,
+// e.g. 3.4.6.0, or "" if it doesn't have one
+func binaryVersion(path string) string {
+	pathPtr, err := syscall.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return ""
+	}
+	size, _, _ := procGetFileVersionInfoSizeW.Call(uintptr(unsafe.Pointer(pathPtr)), 0)
+	if size == 0 {
+		return ""
+	}
+	buf := make([]byte, size)
+	r, _, _ := procGetFileVersionInfoW.Call(uintptr(unsafe.Pointer(pathPtr)), 0, size, uintptr(unsafe.Pointer(&buf[0])))
+	if r == 0 {
+		return ""
+	}
+	var fi *fixedFileInfo
+	var fiLen uint32
+	root, _ := syscall.UTF16PtrFromString(`\`)
+	r, _, _ = procVerQueryValueW.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(root)), uintptr(unsafe.Pointer(&fi)), uintptr(unsafe.Pointer(&fiLen)))
+	if r == 0 || fi == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.%d.%d", fi.FileVersionMS>>16, fi.FileVersionMS&0xffff, fi.FileVersionLS>>16, fi.FileVersionLS&0xffff)
+}
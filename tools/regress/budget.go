@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+/*
+Budget: 10s is how long a test is expected to run. Tests that ran longer
+are listed at the end of the run so that suite runtime creep can be
+attributed to specific tests.
+
+Going over budget doesn't fail a test unless -enforce-budgets is given.
+*/
+
+func parseBudget(pos string, val string) time.Duration {
+	d, err := time.ParseDuration(val)
+	panicIf(err != nil || d <= 0, "%s: Budget: must be a duration > 0 like 10s or 500ms, got '%s'\n", pos, val)
+	return d
+}
+
+func budgetString(t *Test) string {
+	if t.Budget == 0 {
+		return ""
+	}
+	return t.Budget.String()
+}
+
+func isOverBudget(t *Test) bool {
+	return t.Budget != 0 && t.Duration > t.Budget
+}
+
+func overBudgetReason(t *Test) string {
+	return fmt.Sprintf("took %s, more than Budget: %s", t.Duration.Round(time.Millisecond), t.Budget)
+}
+
+// checkBudget returns a reason if -enforce-budgets and the test ran too long
+func checkBudget(t *Test) []string {
+	if !flgEnforceBudgets || !isOverBudget(t) {
+		return nil
+	}
+	return []string{overBudgetReason(t)}
+}
+
+// dumpOverBudget lists tests that ran longer than their budget, worst first
+func dumpOverBudget(tests []*Test) {
+	var over []*Test
+	for _, t := range tests {
+		if t.Done && isOverBudget(t) {
+			over = append(over, t)
+		}
+	}
+	if len(over) == 0 {
+		return
+	}
+	sort.SliceStable(over, func(i, j int) bool {
+		return over[i].Duration-over[i].Budget > over[j].Duration-over[j].Budget
+	})
+	fmt.Printf("\n%d tests over budget:\n", len(over))
+	for _, t := range over {
+		fmt.Printf("  %s:%d: %s\n", t.Path, t.LineNo, overBudgetReason(t))
+	}
+}
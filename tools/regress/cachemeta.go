@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Files in cache are named ${sha1}${ext} but some bugs only reproduce with
+particular file names (%20, #, very long names) so we remember original
+name of the file in ${sha1}.meta.json next to the file.
+
+The meta file also records where the file came from: who submitted it,
+under what license, for which bug. It's shown for failed tests and in
+results so that we can always tell where a test file came from.
+*/
+
+const cacheMetaExt = ".meta.json"
+
+// CacheMeta describes a file in the cache
+type CacheMeta struct {
+	OrigName  string `json:",omitempty"`
+	URL       string `json:",omitempty"`
+	Submitter string `json:",omitempty"`
+	License   string `json:",omitempty"`
+	Bug       string `json:",omitempty"` // e.g. https://github.com/sumatrapdfreader/sumatrapdf/issues/123
+	Format    string `json:",omitempty"` // e.g. pdf
+	// set by corpus-index
+	Doc *DocProps `json:",omitempty"`
+}
+
+// provenance returns e.g. "from https://..., submitted by foo, license: CC0"
+func (m *CacheMeta) provenance() string {
+	if m == nil {
+		return ""
+	}
+	var parts []string
+	if m.URL != "" {
+		parts = append(parts, "from "+m.URL)
+	}
+	if m.Submitter != "" {
+		parts = append(parts, "submitted by "+m.Submitter)
+	}
+	if m.License != "" {
+		parts = append(parts, "license: "+m.License)
+	}
+	if m.Bug != "" {
+		parts = append(parts, "bug: "+m.Bug)
+	}
+	if m.Format != "" {
+		parts = append(parts, "format: "+m.Format)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func isCacheMetaFile(name string) bool {
+	return strings.HasSuffix(name, cacheMetaExt)
+}
+
+func cacheMetaPath(sha1Hex string) string {
+	return filepath.Join(getCacheDirMust(), sha1Hex+cacheMetaExt)
+}
+
+// loadCacheMeta returns nil if there's no meta file
+func loadCacheMeta(sha1Hex string) *CacheMeta {
+	d, err := ioutil.ReadFile(longPath(cacheMetaPath(sha1Hex)))
+	if err != nil {
+		return nil
+	}
+	var res CacheMeta
+	err = json.Unmarshal(d, &res)
+	if err != nil {
+		return nil
+	}
+	return &res
+}
+
+func saveCacheMeta(sha1Hex string, meta *CacheMeta) error {
+	d, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(longPath(cacheMetaPath(sha1Hex)), d, 0644)
+}
+
+// origNameFromURL returns last, unescaped, part of the url
+func origNameFromURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return ""
+	}
+	if s, err := url.PathUnescape(name); err == nil {
+		name = s
+	}
+	return name
+}
+
+// resolveOrigName picks original name from OrigName: field, meta file
+// or url, in that order. It updates meta file if OrigName: is new info.
+func resolveOrigName(t *Test, tf *TestFile) {
+	meta := tf.Meta
+	if meta == nil {
+		meta = &CacheMeta{}
+	}
+	if t.OrigName == "" {
+		t.OrigName = meta.OrigName
+	}
+	if t.OrigName == "" {
+		t.OrigName = origNameFromURL(t.FileURL)
+	}
+	if meta.OrigName != "" || t.OrigName == "" {
+		return
+	}
+	meta.OrigName = t.OrigName
+	if meta.URL == "" {
+		meta.URL = t.FileURL
+	}
+	if saveCacheMeta(tf.Sha1Hex, meta) == nil {
+		tf.Meta = meta
+	}
+}
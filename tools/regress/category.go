@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+)
+
+/*
+So that a run with many failures can be understood quickly, we put failed
+tests into categories (timeout, access violation, output mismatch etc.)
+and summarize failures by category.
+
+Rules are checked in order, the first that matches wins. A rule matches
+if its regexp matches error or output (stdout or stderr) or its match
+function says so.
+*/
+
+type categoryRule struct {
+	Category string
+	rxError  *regexp.Regexp
+	rxOutput *regexp.Regexp
+	match    func(t *Test) bool
+}
+
+const otherFailureCategory = "other"
+
+var categoryRules = []*categoryRule{
+	{
+		Category: "infrastructure",
+		match:    func(t *Test) bool { return t.InfraError != nil },
+	},
+	{
+		Category: "timeout",
+		rxError:  regexp.MustCompile(`^timed out after`),
+	},
+	{
+		// 0xC0000005 on Windows
+		Category: "access violation",
+		rxError:  regexp.MustCompile(`exit status 3221225477|exit status -1073741819|segmentation fault|bus error`),
+	},
+	{
+		// 0xC00000FD on Windows
+		Category: "stack overflow",
+		rxError:  regexp.MustCompile(`exit status 3221225725|exit status -1073741571`),
+	},
+	{
+		Category: "assertion",
+		rxError:  regexp.MustCompile(`signal: aborted`),
+		rxOutput: regexp.MustCompile(`(?i)assert(ion)? failed|CrashIf`),
+	},
+	{
+		Category: "crash",
+		match:    func(t *Test) bool { return isCrashError(t.Error) },
+	},
+	{
+		Category: "cannot open file",
+		rxOutput: regexp.MustCompile(`(?i)failed to create engine|error loading|failed to load|couldn't open`),
+	},
+	{
+		Category: "exit code",
+		match: func(t *Test) bool {
+			_, ok := t.Error.(*exec.ExitError)
+			return ok
+		},
+	},
+	{
+		Category: "temp files left",
+		match:    hasTempLeftoversMismatch,
+	},
+	{
+		Category: "produced files",
+		match:    hasProducedFileMismatch,
+	},
+	{
+		Category: "fonts",
+		match:    hasFontsMismatch,
+	},
+	{
+		Category: "stderr mismatch",
+		match:    hasStderrMismatch,
+	},
+	{
+		Category: "output mismatch",
+		match:    func(t *Test) bool { return len(t.OutputMismatches) > 0 },
+	},
+	{
+		Category: "oracle mismatch",
+		match:    func(t *Test) bool { return t.OracleMismatch != "" },
+	},
+}
+
+func (r *categoryRule) matches(t *Test) bool {
+	if r.match != nil && r.match(t) {
+		return true
+	}
+	if r.rxError != nil && t.Error != nil && r.rxError.MatchString(t.Error.Error()) {
+		return true
+	}
+	return r.rxOutput != nil && (r.rxOutput.MatchString(t.Output) || r.rxOutput.MatchString(t.Stderr))
+}
+
+// failureCategory returns "" for tests that didn't fail
+func failureCategory(t *Test) string {
+	if !isFailedTest(t) {
+		return ""
+	}
+	for _, r := range categoryRules {
+		if r.matches(t) {
+			return r.Category
+		}
+	}
+	return otherFailureCategory
+}
+
+func dumpFailuresByCategory(tests []*Test) {
+	byCategory := map[string][]*Test{}
+	for _, t := range tests {
+		if isUnexpectedFailure(t) {
+			c := failureCategory(t)
+			byCategory[c] = append(byCategory[c], t)
+		}
+	}
+	if len(byCategory) == 0 {
+		return
+	}
+	var categories []string
+	for c := range byCategory {
+		categories = append(categories, c)
+	}
+	// most common first
+	sort.Slice(categories, func(i, j int) bool {
+		ni, nj := len(byCategory[categories[i]]), len(byCategory[categories[j]])
+		if ni != nj {
+			return ni > nj
+		}
+		return categories[i] < categories[j]
+	})
+	fmt.Printf("\nfailures by category:\n")
+	for _, c := range categories {
+		fmt.Printf("  %s: %d\n", c, len(byCategory[c]))
+		for _, t := range byCategory[c] {
+			fmt.Printf("    %s (%s)\n", testDisplayName(t), testPos(t))
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+regress check-urls checks that every url in tests file can still be
+downloaded, so that we find dead links before a CI machine with empty
+cache fails to download them. For each url we report:
+- dead links (errors and 4xx / 5xx status)
+- redirects
+- size different than size of the file in the cache
+
+We use HEAD and fall back to GET of the first byte for servers that
+don't support HEAD. Exits with 1 if there are dead links.
+*/
+
+// URLCheck is result of checking a url
+type URLCheck struct {
+	URL         string
+	Sha1Hex     string
+	Status      int
+	Err         error
+	RedirectsTo string
+	Size        int64 // -1 if unknown
+}
+
+func (c *URLCheck) isDead() bool {
+	return c.Err != nil || c.Status >= 400
+}
+
+// size of the file from Content-Range of a ranged GET or Content-Length
+func responseSize(rsp *http.Response) int64 {
+	if cr := rsp.Header.Get("Content-Range"); cr != "" {
+		if idx := strings.LastIndex(cr, "/"); idx >= 0 {
+			if n, err := strconv.ParseInt(cr[idx+1:], 10, 64); err == nil {
+				return n
+			}
+		}
+		return -1
+	}
+	if rsp.StatusCode == http.StatusPartialContent {
+		return -1
+	}
+	return rsp.ContentLength
+}
+
+func checkURL(client *http.Client, c *URLCheck) {
+	var lastURL string
+	client2 := *client
+	client2.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		lastURL = req.URL.String()
+		if len(via) >= 10 {
+			return fmt.Errorf("too many redirects")
+		}
+		return nil
+	}
+	rsp, err := client2.Head(c.URL)
+	if err == nil && (rsp.StatusCode == http.StatusMethodNotAllowed || rsp.StatusCode == http.StatusForbidden || rsp.StatusCode == http.StatusNotImplemented) {
+		rsp.Body.Close()
+		req, _ := http.NewRequest(http.MethodGet, c.URL, nil)
+		req.Header.Set("Range", "bytes=0-0")
+		rsp, err = client2.Do(req)
+	}
+	if err != nil {
+		c.Err = err
+		return
+	}
+	rsp.Body.Close()
+	c.Status = rsp.StatusCode
+	c.Size = responseSize(rsp)
+	if lastURL != "" && lastURL != c.URL {
+		c.RedirectsTo = lastURL
+	}
+}
+
+func checkURLs(args []string) {
+	fs := flag.NewFlagSet("check-urls", flag.ExitOnError)
+	parallel := fs.Int("j", 8, "how many urls to check at the same time")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout for each request")
+	fs.Parse(args)
+
+	verifyTestFiles()
+	seen := map[string]bool{}
+	var checks []*URLCheck
+	for _, t := range parseTestsMust(flgTests) {
+		// file:// urls from gen-tests are local, not published yet
+		if t.FileURL == "" || seen[t.FileURL] || strings.HasPrefix(t.FileURL, "file://") {
+			continue
+		}
+		seen[t.FileURL] = true
+		checks = append(checks, &URLCheck{URL: t.FileURL, Sha1Hex: t.FileSha1Hex, Size: -1})
+	}
+	fmt.Printf("checking %d urls\n", len(checks))
+	client := &http.Client{Timeout: *timeout}
+	sem := make(chan bool, *parallel)
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		sem <- true
+		go func(c *URLCheck) {
+			checkURL(client, c)
+			<-sem
+			wg.Done()
+		}(c)
+	}
+	wg.Wait()
+
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].URL < checks[j].URL
+	})
+	nDead, nRedirects, nSizeMismatch := 0, 0, 0
+	for _, c := range checks {
+		switch {
+		case c.Err != nil:
+			fmt.Printf("dead: %s: %s\n", c.URL, c.Err)
+			nDead++
+			continue
+		case c.isDead():
+			fmt.Printf("dead: %s: status %d\n", c.URL, c.Status)
+			nDead++
+			continue
+		}
+		if c.RedirectsTo != "" {
+			fmt.Printf("redirect: %s => %s\n", c.URL, c.RedirectsTo)
+			nRedirects++
+		}
+		tf := testFilesBySha1[c.Sha1Hex]
+		if tf == nil || c.Size < 0 {
+			continue
+		}
+		if fi, err := os.Stat(longPath(tf.Path)); err == nil && fi.Size() != c.Size {
+			fmt.Printf("size mismatch: %s: %d bytes, cached file has %d\n", c.URL, c.Size, fi.Size())
+			nSizeMismatch++
+		}
+	}
+	fmt.Printf("%d urls: %d dead, %d redirects, %d size mismatches\n", len(checks), nDead, nRedirects, nSizeMismatch)
+	if nDead > 0 {
+		os.Exit(1)
+	}
+}
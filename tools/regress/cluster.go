@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+One bug often changes output of many files in the same way. We cluster
+output mismatch failures whose diffs are similar (normalized edit distance
+of changed lines, with numbers and file paths normalized) so that the
+report shows one entry per root cause instead of dozens of diffs.
+*/
+
+// diffs whose normalized edit distance is below this are similar
+const clusterMaxDistance = 0.2
+
+// bounds cost of edit distance
+const clusterMaxSigLen = 2000
+
+var (
+	rxClusterSha1   = regexp.MustCompile(`[0-9a-fA-F]{40}`)
+	rxClusterNumber = regexp.MustCompile(`\d+`)
+)
+
+type failureCluster struct {
+	sig   string
+	tests []*Test
+}
+
+// diff of the first test in the cluster, for showing
+func (c *failureCluster) diff() string {
+	t := c.tests[0]
+	return diffStrings(expectedOutput(t), t.Output)
+}
+
+// diffSignature returns changed lines of output diff, normalized so that
+// the same change in different files looks the same
+func diffSignature(t *Test) string {
+	diff := diffLines(toTrimmedLines([]byte(expectedOutput(t))), toTrimmedLines([]byte(t.Output)))
+	var changed []string
+	for _, l := range diff {
+		if strings.HasPrefix(l, " ") {
+			continue
+		}
+		if t.FilePath != "" {
+			l = strings.ReplaceAll(l, t.FilePath, "$file")
+		}
+		l = rxClusterSha1.ReplaceAllString(l, "$$sha1")
+		l = rxClusterNumber.ReplaceAllString(l, "N")
+		changed = append(changed, l)
+	}
+	sig := strings.Join(changed, "\n")
+	if len(sig) > clusterMaxSigLen {
+		sig = sig[:clusterMaxSigLen]
+	}
+	return sig
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func isSimilarDiff(a, b string) bool {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	if n == 0 {
+		return true
+	}
+	return float64(editDistance(a, b))/float64(n) < clusterMaxDistance
+}
+
+func hasOutputDiff(t *Test) bool {
+	for _, s := range t.OutputMismatches {
+		if s == outputDiffersReason {
+			return true
+		}
+	}
+	return false
+}
+
+// clusterFailures groups output mismatch failures with similar diffs,
+// biggest cluster first
+func clusterFailures(tests []*Test) []*failureCluster {
+	var res []*failureCluster
+	for _, t := range tests {
+		if !isUnexpectedFailure(t) || !hasOutputDiff(t) {
+			continue
+		}
+		sig := diffSignature(t)
+		var c *failureCluster
+		for _, c2 := range res {
+			if isSimilarDiff(sig, c2.sig) {
+				c = c2
+				break
+			}
+		}
+		if c == nil {
+			c = &failureCluster{sig: sig}
+			res = append(res, c)
+		}
+		c.tests = append(c.tests, t)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return len(res[i].tests) > len(res[j].tests)
+	})
+	return res
+}
+
+func dumpFailureClusters(tests []*Test) {
+	var clusters []*failureCluster
+	for _, c := range clusterFailures(tests) {
+		if len(c.tests) > 1 {
+			clusters = append(clusters, c)
+		}
+	}
+	if len(clusters) == 0 {
+		return
+	}
+	fmt.Printf("\n%d clusters of output mismatches with similar diffs:\n", len(clusters))
+	for i, c := range clusters {
+		fmt.Printf("cluster %d, %d tests, diff of the first:\n%s\n", i+1, len(c.tests), c.diff())
+		for _, t := range c.tests {
+			fmt.Printf("    %s (%s)\n", testDisplayName(t), testPos(t))
+		}
+	}
+}
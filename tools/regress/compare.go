@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/*
+By default output of a test must be equal to Out:. A test can use
+an external program to compare them instead:
+
+Compare: exec tools/pdfdiff.exe -strict
+
+The program is called with paths of files with expected and actual
+output as the last 2 arguments. Exit code 0 means the test passed.
+*/
+
+const (
+	compareExecPrefix   = "exec "
+	outputDiffersReason = "output differs from expected"
+)
+
+func parseCompare(pos string, val string) string {
+	panicIf(!strings.HasPrefix(val, compareExecPrefix), "%s: Compare: must be 'exec <command>', got '%s'\n", pos, val)
+	cmd := strings.TrimSpace(val[len(compareExecPrefix):])
+	panicIf(cmd == "", "%s: Compare: missing command\n", pos)
+	return cmd
+}
+
+// runCompareCmd returns a reason if comparator says the outputs differ.
+// Failure to run the comparator is an infrastructure error.
+func runCompareCmd(t *Test, expected string, actual string) string {
+	expectedPath := filepath.Join(t.TempDir, "expected.txt")
+	actualPath := filepath.Join(t.TempDir, "actual.txt")
+	err := ioutil.WriteFile(longPath(expectedPath), []byte(expected), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(longPath(actualPath), []byte(actual), 0644)
+	}
+	if err != nil {
+		t.InfraError = err
+		return ""
+	}
+	parts := strings.Fields(t.Compare)
+	args := append(parts[1:], expectedPath, actualPath)
+	cmd := exec.Command(parts[0], args...)
+	fmt.Printf("Running comparator: %s\n", cmdToStrLong(cmd))
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return ""
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.InfraError = fmt.Errorf("failed to run comparator '%s': %w", t.Compare, err)
+		return ""
+	}
+	reason := fmt.Sprintf("comparator '%s' failed with '%s'", parts[0], err)
+	s := strings.TrimSpace(string(out))
+	if s != "" {
+		reason += ":\n" + s
+	}
+	return reason
+}
+
+// checkOutput returns reasons for all checks of output that failed.
+// Checks are independent so that one run tells everything that's wrong.
+func checkOutput(t *Test) []string {
+	var res []string
+	expected := normalizePaths(expectedOutput(t), t)
+	actual := normalizePaths(t.Output, t)
+	if t.Compare != "" {
+		if reason := runCompareCmd(t, expected, actual); reason != "" {
+			res = append(res, reason)
+		}
+	} else if t.ExpectedOutput != "" && !isOutputEqual(actual, expected) {
+		res = append(res, outputDiffersReason)
+	}
+	res = append(res, checkStderr(t)...)
+	res = append(res, checkPageOutputs(t)...)
+	res = append(res, checkAsserts(t)...)
+	res = append(res, checkRenderTimings(t)...)
+	res = append(res, checkTempCleanup(t)...)
+	res = append(res, checkFonts(t)...)
+	res = append(res, checkProducedFiles(t)...)
+	res = append(res, t.testFileMismatches...)
+	return append(res, checkBudget(t)...)
+}
+
+// dumpOutputMismatches shows outputs as they were compared i.e. with
+// paths normalized, which is also what should be used in Out:
+func dumpOutputMismatches(t *Test) {
+	showOutput := false
+	for _, reason := range t.OutputMismatches {
+		if reason != outputDiffersReason {
+			fmt.Printf("Reason: %s\n", reason)
+			showOutput = true
+			continue
+		}
+		fmt.Printf(`
+Reason: got output:
+-----
+%s
+-----
+expected:
+-----
+%s
+-----
+`, normalizePaths(t.Output, t), normalizePaths(expectedOutput(t), t))
+	}
+	if showOutput {
+		fmt.Printf("got output:\n-----\n%s\n-----\n", t.Output)
+	}
+}
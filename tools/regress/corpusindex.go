@@ -0,0 +1,219 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+regress corpus-index runs pdfinfo (from Poppler) on every cached pdf file
+and records page count, producer, encryption and pdf version in the file's
+.meta.json. Files already indexed are skipped unless -force.
+
+That allows running a subset of tests based on their files, e.g.:
+	regress -select encrypted
+	regress -select "pages>500"
+	regress -select "version=1.7,producer~Word"
+
+Conditions in -select are separated by "," and all must match. A condition
+is a property name with optional operator: = (equal), != (not equal),
+~ (contains, case-insensitive), <, <=, >, >= (numbers). A property without
+operator must be true (or not empty, not 0). Properties are: pages,
+producer, encrypted, version, format. Files that are not indexed don't
+match any condition.
+*/
+
+// DocProps is what corpus-index records about a file
+type DocProps struct {
+	Pages      int    `json:",omitempty"`
+	Producer   string `json:",omitempty"`
+	Encrypted  bool   `json:",omitempty"`
+	PDFVersion string `json:",omitempty"`
+}
+
+func parsePdfinfoOutput(s string) *DocProps {
+	res := &DocProps{}
+	for _, line := range strings.Split(s, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		val := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "Pages":
+			res.Pages, _ = strconv.Atoi(val)
+		case "Producer":
+			res.Producer = val
+		case "Encrypted":
+			res.Encrypted = strings.HasPrefix(val, "yes")
+		case "PDF version":
+			res.PDFVersion = val
+		}
+	}
+	return res
+}
+
+func getDocProps(path string) (*DocProps, error) {
+	out, err := exec.Command(flgPdfinfoPath, longPath(path)).CombinedOutput()
+	if err != nil {
+		// pdfinfo can't open files encrypted with user password
+		if strings.Contains(string(out), "Incorrect password") {
+			return &DocProps{Encrypted: true}, nil
+		}
+		return nil, fmt.Errorf("%s failed with '%s', output:\n%s", flgPdfinfoPath, err, out)
+	}
+	return parsePdfinfoOutput(string(out)), nil
+}
+
+func corpusIndex(args []string) {
+	flags := flag.NewFlagSet("corpus-index", flag.ExitOnError)
+	force := flags.Bool("force", false, "re-index files that were already indexed")
+	fatalIfErr(flags.Parse(args))
+	panicIf(flags.NArg() > 0, "usage: regress corpus-index [-force]\n")
+	verifyTestFiles()
+
+	var sha1s []string
+	for sha1Hex, tf := range testFilesBySha1 {
+		if strings.EqualFold(filepath.Ext(tf.Path), ".pdf") {
+			sha1s = append(sha1s, sha1Hex)
+		}
+	}
+	sort.Strings(sha1s)
+	nIndexed, nSkipped, nFailed := 0, 0, 0
+	for _, sha1Hex := range sha1s {
+		tf := testFilesBySha1[sha1Hex]
+		if !*force && tf.Meta != nil && tf.Meta.Doc != nil {
+			nSkipped++
+			continue
+		}
+		props, err := getDocProps(tf.Path)
+		if err != nil {
+			fmt.Printf("%s: %s\n", tf.Path, err)
+			nFailed++
+			continue
+		}
+		meta := tf.Meta
+		if meta == nil {
+			meta = &CacheMeta{}
+		}
+		meta.Doc = props
+		err = saveCacheMeta(sha1Hex, meta)
+		fatalIfErr(err)
+		tf.Meta = meta
+		nIndexed++
+	}
+	fmt.Printf("%d pdf files: indexed %d, already indexed %d, failed %d\n", len(sha1s), nIndexed, nSkipped, nFailed)
+	if nFailed > 0 {
+		fatalf("failed to index %d files\n", nFailed)
+	}
+}
+
+var rxSelectCond = regexp.MustCompile(`^\s*([a-z]+)\s*(?:(!=|<=|>=|=|~|<|>)\s*(.*?))?\s*$`)
+
+type selectCond struct {
+	prop string
+	op   string
+	val  string
+}
+
+func parseSelectMust(s string) []*selectCond {
+	var res []*selectCond
+	for _, part := range strings.Split(s, ",") {
+		m := rxSelectCond.FindStringSubmatch(part)
+		panicIf(m == nil, "-select: invalid condition '%s'\n", part)
+		c := &selectCond{prop: m[1], op: m[2], val: m[3]}
+		switch c.prop {
+		case "pages", "producer", "encrypted", "version", "format":
+		default:
+			fatalf("-select: unknown property '%s' in '%s'\n", c.prop, part)
+		}
+		if c.op != "" && c.op != "=" && c.op != "!=" && c.op != "~" {
+			_, err := strconv.ParseFloat(c.val, 64)
+			panicIf(err != nil, "-select: '%s' is not a number in '%s'\n", c.val, part)
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+func docPropValue(meta *CacheMeta, prop string) string {
+	switch prop {
+	case "pages":
+		return strconv.Itoa(meta.Doc.Pages)
+	case "producer":
+		return meta.Doc.Producer
+	case "encrypted":
+		return strconv.FormatBool(meta.Doc.Encrypted)
+	case "version":
+		return meta.Doc.PDFVersion
+	case "format":
+		return meta.Format
+	}
+	return ""
+}
+
+func (c *selectCond) matches(meta *CacheMeta) bool {
+	if meta == nil || meta.Doc == nil {
+		return false
+	}
+	v := docPropValue(meta, c.prop)
+	switch c.op {
+	case "":
+		return v != "" && v != "0" && v != "false"
+	case "=":
+		return strings.EqualFold(v, c.val)
+	case "!=":
+		return !strings.EqualFold(v, c.val)
+	case "~":
+		return strings.Contains(strings.ToLower(v), strings.ToLower(c.val))
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return false
+	}
+	want, _ := strconv.ParseFloat(c.val, 64)
+	switch c.op {
+	case "<":
+		return n < want
+	case "<=":
+		return n <= want
+	case ">":
+		return n > want
+	case ">=":
+		return n >= want
+	}
+	return false
+}
+
+// selectTests returns tests whose files match -select
+func selectTests(tests []*Test) []*Test {
+	if flgSelect == "" {
+		return tests
+	}
+	conds := parseSelectMust(flgSelect)
+	var res []*Test
+	for _, t := range tests {
+		var meta *CacheMeta
+		if tf := testFilesBySha1[t.FileSha1Hex]; tf != nil {
+			meta = tf.Meta
+		}
+		matches := true
+		for _, c := range conds {
+			if !c.matches(meta) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			res = append(res, t)
+		}
+	}
+	fmt.Printf("-select '%s': %d of %d tests\n", flgSelect, len(res), len(tests))
+	return res
+}
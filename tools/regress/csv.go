@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// -csv out.csv saves one row per test, for analysis in a spreadsheet
+
+var csvHeader = []string{"name", "format", "outcome", "duration_ms", "peak_memory_kb", "binary_version", "variant", "category", "cached", "bug", "owner", "labels"}
+
+func testOutcome(t *Test) string {
+	switch {
+	case !t.Done:
+		return "not run"
+	case !isFailedTest(t):
+		return "pass"
+	case t.KnownFailure != nil:
+		return "known failure"
+	}
+	return "fail"
+}
+
+func testToCSVRow(t *Test, versions map[string]string) []string {
+	var dur, mem string
+	if t.Done && !t.FromCache {
+		dur = fmt.Sprintf("%d", t.Duration.Milliseconds())
+		if t.PeakMemoryKB > 0 {
+			mem = fmt.Sprintf("%d", t.PeakMemoryKB)
+		}
+	}
+	return []string{
+		testDisplayName(t),
+		testFileFormat(t),
+		testOutcome(t),
+		dur,
+		mem,
+		versions[t.CmdPath],
+		t.Variant,
+		failureCategory(t),
+		fmt.Sprintf("%v", t.FromCache),
+		t.Bug,
+		triageLabelOwner(t),
+		strings.Join(triageLabelLabels(t), " "),
+	}
+}
+
+func writeResultsCSV(path string, tests []*Test) error {
+	f, err := os.Create(longPath(path))
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(csvHeader)
+	versions := map[string]string{}
+	for _, t := range tests {
+		if _, ok := versions[t.CmdPath]; !ok {
+			versions[t.CmdPath] = binaryVersion(t.CmdPath)
+		}
+		w.Write(testToCSVRow(t, versions))
+	}
+	w.Flush()
+	err = w.Error()
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+func saveResultsCSV(tests []*Test) {
+	if flgCSV == "" {
+		return
+	}
+	err := writeResultsCSV(flgCSV, tests)
+	if err != nil {
+		fmt.Printf("failed to save csv results to '%s': %s\n", flgCSV, err)
+		return
+	}
+	fmt.Printf("saved csv results to '%s'\n", flgCSV)
+}
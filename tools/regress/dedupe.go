@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image"
+	"io/ioutil"
+	"math/bits"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+regress dedupe finds pdf files in the cache that are nearly the same so
+that redundant tests can be removed to keep the suite fast.
+
+For each file we compute:
+- object count profile: number of objects, pages, fonts, images, streams
+- simhash of text extracted by pdftotext (if available)
+- average hash of page 1 rendered by gs at low resolution (if available)
+
+Files are near-duplicates if their profiles are similar and text and
+page 1 image hashes (those we have) are close.
+*/
+
+const (
+	dedupeMaxTextDistance  = 3
+	dedupeMaxImageDistance = 4
+)
+
+var rxDedupeProfile = []*regexp.Regexp{
+	regexp.MustCompile(`\d+\s+\d+\s+obj\b`),
+	regexp.MustCompile(`/Type\s*/Page\b`),
+	regexp.MustCompile(`/Type\s*/Font\b`),
+	regexp.MustCompile(`/Subtype\s*/Image\b`),
+	regexp.MustCompile(`\bstream\r?\n`),
+}
+
+type fileFingerprint struct {
+	sha1Hex  string
+	path     string
+	profile  []int
+	textHash uint64
+	hasText  bool
+	imgHash  uint64
+	hasImg   bool
+}
+
+func objectProfile(d []byte) []int {
+	var res []int
+	for _, rx := range rxDedupeProfile {
+		res = append(res, len(rx.FindAllIndex(d, -1)))
+	}
+	return res
+}
+
+func simhash(s string) uint64 {
+	var v [64]int
+	for _, tok := range strings.Fields(strings.ToLower(s)) {
+		h := fnv.New64a()
+		h.Write([]byte(tok))
+		x := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if x&(1<<uint(i)) != 0 {
+				v[i]++
+			} else {
+				v[i]--
+			}
+		}
+	}
+	var res uint64
+	for i := 0; i < 64; i++ {
+		if v[i] > 0 {
+			res |= 1 << uint(i)
+		}
+	}
+	return res
+}
+
+// averageHash is 64-bit hash of an image scaled down to 8x8 gray pixels,
+// each bit tells if the pixel is brighter than average
+func averageHash(img image.Image) uint64 {
+	b := img.Bounds()
+	var cells [64]float64
+	var counts [64]int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			cx := (x - b.Min.X) * 8 / b.Dx()
+			cy := (y - b.Min.Y) * 8 / b.Dy()
+			i := cy*8 + cx
+			cells[i] += float64(r+g+bl) / 3
+			counts[i]++
+		}
+	}
+	var sum float64
+	for i := range cells {
+		if counts[i] > 0 {
+			cells[i] /= float64(counts[i])
+		}
+		sum += cells[i]
+	}
+	avg := sum / 64
+	var res uint64
+	for i, c := range cells {
+		if c > avg {
+			res |= 1 << uint(i)
+		}
+	}
+	return res
+}
+
+func extractTextForDedupe(path string) (string, bool) {
+	if _, err := exec.LookPath(flgPdftotextPath); err != nil {
+		return "", false
+	}
+	out, err := exec.Command(flgPdftotextPath, "-l", "3", "-enc", "UTF-8", path, "-").Output()
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}
+
+func renderPage1ForDedupe(path string, dir string) (image.Image, bool) {
+	if _, err := exec.LookPath(flgGsPath); err != nil {
+		return nil, false
+	}
+	imgPath := filepath.Join(dir, "page1.png")
+	args := []string{"-q", "-dSAFER", "-dBATCH", "-dNOPAUSE", "-sDEVICE=png16m", "-r18", "-dFirstPage=1", "-dLastPage=1", "-sOutputFile=" + imgPath, path}
+	if err := exec.Command(flgGsPath, args...).Run(); err != nil {
+		return nil, false
+	}
+	img, err := loadPngImage(imgPath)
+	return img, err == nil
+}
+
+func fingerprintFile(tf *TestFile, dir string) (*fileFingerprint, error) {
+	d, err := ioutil.ReadFile(longPath(tf.Path))
+	if err != nil {
+		return nil, err
+	}
+	fp := &fileFingerprint{
+		sha1Hex: tf.Sha1Hex,
+		path:    tf.Path,
+		profile: objectProfile(d),
+	}
+	if text, ok := extractTextForDedupe(tf.Path); ok && strings.TrimSpace(text) != "" {
+		fp.textHash = simhash(text)
+		fp.hasText = true
+	}
+	if img, ok := renderPage1ForDedupe(tf.Path, dir); ok {
+		fp.imgHash = averageHash(img)
+		fp.hasImg = true
+	}
+	return fp, nil
+}
+
+// counts are similar if they differ by at most 10% or 2
+func isSimilarProfile(a, b []int) bool {
+	for i := range a {
+		diff := a[i] - b[i]
+		if diff < 0 {
+			diff = -diff
+		}
+		max := a[i]
+		if b[i] > max {
+			max = b[i]
+		}
+		if diff > 2 && diff*10 > max {
+			return false
+		}
+	}
+	return true
+}
+
+func isNearDuplicate(a, b *fileFingerprint) bool {
+	if !isSimilarProfile(a.profile, b.profile) {
+		return false
+	}
+	compared := false
+	if a.hasText && b.hasText {
+		if bits.OnesCount64(a.textHash^b.textHash) > dedupeMaxTextDistance {
+			return false
+		}
+		compared = true
+	}
+	if a.hasImg && b.hasImg {
+		if bits.OnesCount64(a.imgHash^b.imgHash) > dedupeMaxImageDistance {
+			return false
+		}
+		compared = true
+	}
+	// profile alone is too weak
+	return compared
+}
+
+// clusterNearDuplicates returns groups of 2 or more near-duplicate files
+func clusterNearDuplicates(fps []*fileFingerprint) [][]*fileFingerprint {
+	parent := make([]int, len(fps))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+	for i := range fps {
+		for j := i + 1; j < len(fps); j++ {
+			if isNearDuplicate(fps[i], fps[j]) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+	groups := map[int][]*fileFingerprint{}
+	for i, fp := range fps {
+		root := find(i)
+		groups[root] = append(groups[root], fp)
+	}
+	var res [][]*fileFingerprint
+	for _, g := range groups {
+		if len(g) > 1 {
+			res = append(res, g)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return len(res[i]) > len(res[j])
+	})
+	return res
+}
+
+func dedupe(args []string) {
+	panicIf(len(args) > 0, "usage: regress dedupe\n")
+	verifyTestFiles()
+	// so that we can tell which tests use the duplicates
+	testsBySha1 := map[string][]string{}
+	if fileExists(flgTests) {
+		for _, t := range parseTestsMust(flgTests) {
+			testsBySha1[t.FileSha1Hex] = append(testsBySha1[t.FileSha1Hex], testPos(t))
+		}
+	}
+	var sha1s []string
+	for sha1Hex, tf := range testFilesBySha1 {
+		if strings.EqualFold(filepath.Ext(tf.Path), ".pdf") {
+			sha1s = append(sha1s, sha1Hex)
+		}
+	}
+	sort.Strings(sha1s)
+	dir := getScratchDirMust()
+	var fps []*fileFingerprint
+	for _, sha1Hex := range sha1s {
+		fp, err := fingerprintFile(testFilesBySha1[sha1Hex], dir)
+		if err != nil {
+			fmt.Printf("failed to fingerprint '%s': %s\n", sha1Hex, err)
+			continue
+		}
+		fps = append(fps, fp)
+	}
+	removeScratchDir()
+	clusters := clusterNearDuplicates(fps)
+	fmt.Printf("%d pdf files, %d groups of near-duplicates\n", len(fps), len(clusters))
+	for i, c := range clusters {
+		fmt.Printf("\ngroup %d:\n", i+1)
+		for _, fp := range c {
+			s := "  " + fp.path
+			if tests := testsBySha1[fp.sha1Hex]; len(tests) > 0 {
+				s += " used by " + strings.Join(tests, ", ")
+			}
+			fmt.Printf("%s\n", s)
+		}
+	}
+}
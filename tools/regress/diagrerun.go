@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+When a render test fails we run it once more with -diag-args (by default
+-log, which makes SumatraPDF log to sumatra-log.txt in LOCALAPPDATA) and
+save output and log of that run as an artifact so that most failures
+come with more information. The result of the test doesn't change.
+
+We don't re-run tests that timed out, they would most likely time out
+again.
+*/
+
+func getDiagDir() (string, error) {
+	d := filepath.Join("out", "regress", "diag")
+	err := os.MkdirAll(longPath(d), 0755)
+	return d, err
+}
+
+func shouldRerunWithDiagnostics(t *Test) bool {
+	if flgDiagArgs == "" || !isRenderTest(t) || t.InfraError != nil {
+		return false
+	}
+	return isFailedTest(t) && failureCategory(t) != "timeout"
+}
+
+// rerunWithDiagnostics must be called before the test is unstaged
+func rerunWithDiagnostics(t *Test, cmdPath string, args []string) {
+	logDir := filepath.Join(t.TempDir, "diag")
+	err := os.MkdirAll(longPath(logDir), 0755)
+	if err != nil {
+		fmt.Printf("failed to create '%s': %s\n", logDir, err)
+		return
+	}
+	cmd := exec.Command(cmdPath, append(strings.Fields(flgDiagArgs), args...)...)
+	cmd.Env = append(testEnv(t), "LOCALAPPDATA="+logDir)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	fmt.Printf("Re-running with diagnostics: %s\n", cmdToStrLong(cmd))
+	peakMemoryKB := t.PeakMemoryKB
+	out, runErr := runCmdWithTimeout(t, cmd)
+	t.PeakMemoryKB = peakMemoryKB
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Cmd: %s\nError: %s\n\n----- stdout -----\n%s\n----- stderr -----\n%s\n", cmdToStrLong(cmd), errStr(runErr), out, stderr.String())
+	if d, err := ioutil.ReadFile(longPath(filepath.Join(logDir, "sumatra-log.txt"))); err == nil {
+		fmt.Fprintf(&sb, "----- sumatra-log.txt -----\n%s\n", d)
+	}
+	dir, err := getDiagDir()
+	if err != nil {
+		fmt.Printf("failed to save diagnostics: %s\n", err)
+		return
+	}
+	name := fmt.Sprintf("%s-%s.txt", t.FileSha1Hex, time.Now().Format("20060102-150405"))
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(longPath(path), []byte(sb.String()), 0644)
+	if err != nil {
+		fmt.Printf("failed to save diagnostics: %s\n", err)
+		return
+	}
+	fmt.Printf("saved diagnostics to '%s'\n", path)
+	t.Artifacts = append(t.Artifacts, path)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+)
+
+// diffLines returns a simple line diff of a and b, with lines prefixed
+// with "-" (only in a), "+" (only in b) and " " (in both)
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is length of longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var res []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			res = append(res, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			res = append(res, "-"+a[i])
+			i++
+		default:
+			res = append(res, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		res = append(res, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		res = append(res, "+"+b[j])
+	}
+	return res
+}
+
+func diffStrings(expected, got string) string {
+	a := strings.Split(strings.TrimSpace(expected), "\n")
+	b := strings.Split(strings.TrimSpace(got), "\n")
+	return strings.Join(diffLines(a, b), "\n")
+}
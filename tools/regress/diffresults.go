@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+/*
+regress diff-results a.json b.json compares results of two runs and lists
+tests that newly fail, newly pass or whose output changed in b. It's for
+answering "did my change change anything". -json prints the same as JSON.
+
+Exits with 1 if there are new failures.
+*/
+
+// ResultsDiff is what changed between two runs
+type ResultsDiff struct {
+	NewFailures   []string
+	NewlyPassing  []string
+	OutputChanged []string
+	Added         []string
+	Removed       []string
+}
+
+func diffRunResults(a, b *RunResults) *ResultsDiff {
+	byKey := map[string]*TestResult{}
+	for _, r := range a.Tests {
+		byKey[resultID(r)] = r
+	}
+	res := &ResultsDiff{}
+	seen := map[string]bool{}
+	for _, r := range b.Tests {
+		seen[resultID(r)] = true
+		name := resultDisplayName(r)
+		prev := byKey[resultID(r)]
+		if prev == nil {
+			res.Added = append(res.Added, name)
+			if r.Failed {
+				res.NewFailures = append(res.NewFailures, name)
+			}
+			continue
+		}
+		switch {
+		case r.Failed && !prev.Failed:
+			res.NewFailures = append(res.NewFailures, name)
+		case !r.Failed && prev.Failed:
+			res.NewlyPassing = append(res.NewlyPassing, name)
+		}
+		if r.Output != prev.Output {
+			res.OutputChanged = append(res.OutputChanged, name)
+		}
+	}
+	for _, r := range a.Tests {
+		if !seen[resultID(r)] {
+			res.Removed = append(res.Removed, resultDisplayName(r))
+		}
+	}
+	return res
+}
+
+func dumpNames(title string, names []string) {
+	fmt.Printf("%s: %d\n", title, len(names))
+	for _, s := range names {
+		fmt.Printf("  %s\n", s)
+	}
+}
+
+func diffResults(args []string) {
+	fs := flag.NewFlagSet("diff-results", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the diff as JSON")
+	fs.Parse(args)
+	panicIf(fs.NArg() != 2, "usage: regress diff-results [-json] a.json b.json\n")
+	a, err := loadRunResults(fs.Arg(0))
+	fatalIfErr(err)
+	b, err := loadRunResults(fs.Arg(1))
+	fatalIfErr(err)
+	diff := diffRunResults(a, b)
+	if *asJSON {
+		d, err := json.MarshalIndent(diff, "", "  ")
+		fatalIfErr(err)
+		fmt.Printf("%s\n", d)
+	} else {
+		fmt.Printf("'%s' (%s) => '%s' (%s)\n", fs.Arg(0), a.ID, fs.Arg(1), b.ID)
+		dumpNames("New failures", diff.NewFailures)
+		dumpNames("Newly passing", diff.NewlyPassing)
+		dumpNames("Output changed", diff.OutputChanged)
+		dumpNames("Added", diff.Added)
+		dumpNames("Removed", diff.Removed)
+	}
+	if len(diff.NewFailures) > 0 {
+		os.Exit(1)
+	}
+}
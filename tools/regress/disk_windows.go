@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procGetDiskFreeSpaceExW = modkernel32.NewProc("GetDiskFreeSpaceExW")
+)
+
+const (
+	errorHandleDiskFull = syscall.Errno(39)
+	errorDiskFull       = syscall.Errno(112)
+)
+
+func diskFreeSpace(dir string) (uint64, error) {
+	dirPtr, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var freeBytes uint64
+	r, _, err := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(dirPtr)), uintptr(unsafe.Pointer(&freeBytes)), 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	return freeBytes, nil
+}
+
+func isDiskFullError(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
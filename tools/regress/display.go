@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+/*
+Most tests render to a file and don't need a display but tests that open
+a window (e.g. UI automation) can't run on headless CI agents like
+Windows services, which run in session 0 without an interactive desktop,
+or Linux agents without X server for wine.
+
+Such tests have Display: true. If any of them is about to run and we're
+headless, we run -display-setup command (e.g. one that starts a virtual
+display driver or Xvfb) once and check again. Lines NAME=value it prints
+(e.g. DISPLAY=:99) are added to our environment and therefore to tests.
+If there's still no display, those tests are skipped as errored with
+the reason, the rest of the tests run.
+*/
+
+// noDisplayError is InfraError of a test that needs display when there's none
+type noDisplayError struct {
+	reason string
+}
+
+func (e *noDisplayError) Error() string {
+	return "no display: " + e.reason
+}
+
+func parseDisplay(pos string, val string) bool {
+	v, err := strconv.ParseBool(val)
+	panicIf(err != nil, "%s: Display: must be true or false, got '%s'\n", pos, val)
+	return v
+}
+
+func isNoDisplayError(t *Test) bool {
+	_, ok := t.InfraError.(*noDisplayError)
+	return ok
+}
+
+// runDisplaySetup runs -display-setup and applies NAME=value lines it prints
+func runDisplaySetup() error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd.exe", "/c", flgDisplaySetup)
+	} else {
+		cmd = exec.Command("sh", "-c", flgDisplaySetup)
+	}
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	os.Stdout.Write(out)
+	if err != nil {
+		return fmt.Errorf("-display-setup '%s' failed: %w", flgDisplaySetup, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || strings.ContainsAny(parts[0], " \t") {
+			continue
+		}
+		fmt.Printf("display setup: %s=%s\n", parts[0], parts[1])
+		os.Setenv(parts[0], parts[1])
+	}
+	return nil
+}
+
+// checkDisplay marks tests that need display as errored if we're
+// headless and -display-setup didn't help
+func checkDisplay(tests []*Test) {
+	var needDisplay []*Test
+	for _, t := range tests {
+		if t.Display && !t.Done && t.InfraError == nil {
+			needDisplay = append(needDisplay, t)
+		}
+	}
+	if len(needDisplay) == 0 {
+		return
+	}
+	reason := headlessReason()
+	if reason != "" && flgDisplaySetup != "" {
+		fmt.Printf("no display (%s), running -display-setup\n", reason)
+		if err := runDisplaySetup(); err != nil {
+			reason = err.Error()
+		} else {
+			reason = headlessReason()
+		}
+	}
+	if reason == "" {
+		return
+	}
+	fmt.Printf("no display: %s, %d tests that need it will be skipped\n", reason, len(needDisplay))
+	for _, t := range needDisplay {
+		t.InfraError = &noDisplayError{reason: reason}
+	}
+}
+
+// dumpNoDisplay shows e.g. "3 tests skipped: no display: running in session 0"
+func dumpNoDisplay(tests []*Test) {
+	n := 0
+	reason := ""
+	for _, t := range tests {
+		if isNoDisplayError(t) {
+			n++
+			reason = t.InfraError.Error()
+		}
+	}
+	if n > 0 {
+		fmt.Printf("%d tests skipped: %s\n", n, reason)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// headlessReason returns why there's no display or "" if there is one
+func headlessReason() string {
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return "neither DISPLAY nor WAYLAND_DISPLAY is set"
+	}
+	return ""
+}
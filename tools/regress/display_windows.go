@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+var (
+	procProcessIDToSessionID = modkernel32.NewProc("ProcessIdToSessionId")
+	procGetSystemMetrics     = moduser32.NewProc("GetSystemMetrics")
+)
+
+const smCMonitors = 80
+
+// headlessReason returns why there's no display or "" if there is one
+func headlessReason() string {
+	var sessionID uint32
+	r, _, _ := procProcessIDToSessionID.Call(uintptr(os.Getpid()), uintptr(unsafe.Pointer(&sessionID)))
+	if r != 0 && sessionID == 0 {
+		return "running in session 0 (e.g. as a service), which has no interactive desktop"
+	}
+	n, _, _ := procGetSystemMetrics.Call(smCMonitors)
+	if n == 0 {
+		return fmt.Sprintf("no monitors in session %d", sessionID)
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+/*
+With -clean-env test commands run with a minimal environment instead of
+inheriting ours so that results don't depend on PATH, TMP or locale
+variables of the machine that runs the tests.
+
+Env: NAME=value adds a variable for a single test (it can use $file etc.),
+with or without -clean-env.
+
+TMP, TEMP and TMPDIR always point to the test's temp dir, see tempcheck.go.
+*/
+
+// variables Windows programs need to work at all
+var cleanEnvWindowsVars = []string{
+	"SystemRoot", "SystemDrive", "windir", "ComSpec", "PATHEXT",
+	"USERPROFILE", "APPDATA", "LOCALAPPDATA", "ProgramData",
+	"ProgramFiles", "ProgramFiles(x86)", "CommonProgramFiles",
+	"NUMBER_OF_PROCESSORS", "PROCESSOR_ARCHITECTURE",
+}
+
+func minimalEnv() []string {
+	tmpDir := getScratchDirMust()
+	if runtime.GOOS != "windows" {
+		return []string{
+			"PATH=/usr/bin:/bin",
+			"HOME=" + os.Getenv("HOME"),
+			"LANG=C",
+			"TMPDIR=" + tmpDir,
+		}
+	}
+	var res []string
+	for _, name := range cleanEnvWindowsVars {
+		if v, ok := os.LookupEnv(name); ok {
+			res = append(res, name+"="+v)
+		}
+	}
+	sysRoot := os.Getenv("SystemRoot")
+	res = append(res, "PATH="+filepath.Join(sysRoot, "System32")+";"+sysRoot)
+	res = append(res, "TEMP="+tmpDir, "TMP="+tmpDir)
+	return res
+}
+
+func parseEnvVar(pos string, val string) string {
+	parts := strings.SplitN(val, "=", 2)
+	panicIf(len(parts) != 2 || strings.TrimSpace(parts[0]) == "", "%s: Env: must be NAME=value, got '%s'\n", pos, val)
+	return strings.TrimSpace(parts[0]) + "=" + parts[1]
+}
+
+// testEnv returns environment for test command
+func testEnv(t *Test) []string {
+	var res []string
+	if flgCleanEnv {
+		res = minimalEnv()
+	} else {
+		res = os.Environ()
+	}
+	// exec.Cmd uses the last value of duplicate variables
+	if t.TempDir != "" {
+		res = append(res, testTmpEnv(t)...)
+	}
+	res = append(res, pinnedFontsEnv(t)...)
+	res = append(res, fontLogEnv(t)...)
+	for _, v := range t.Env {
+		res = append(res, substVars(v, t))
+	}
+	return res
+}
+
+// testEnvForKey is what of the environment affects result of the test
+func testEnvForKey(t *Test) []string {
+	if flgCleanEnv {
+		return append([]string{"-clean-env"}, t.Env...)
+	}
+	return t.Env
+}
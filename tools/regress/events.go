@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+With -events path (or -events - for stdout) we write one JSON object
+per line as things happen, for orchestrators and IDE plugins.
+
+Every event has:
+  "Event" : "run_start", "test_start", "pass", "fail", "skip" or "run_end"
+  "Time"  : RFC 3339 timestamp
+
+run_start has "Tests" (number of tests).
+test_start, pass, fail and skip have "Name", "Key" and "Pos" (file:line)
+of the test. fail has "Reason", skip has "Reason" (why it wasn't run).
+pass and fail have "DurationMs".
+run_end has "Tests", "Passed" and "Failed".
+*/
+
+// Event is a line in -events file
+type Event struct {
+	Event      string
+	Time       time.Time
+	Name       string `json:",omitempty"`
+	Key        string `json:",omitempty"`
+	Pos        string `json:",omitempty"`
+	Reason     string `json:",omitempty"`
+	DurationMs int64  `json:",omitempty"`
+	Tests      int    `json:",omitempty"`
+	Passed     int    `json:",omitempty"`
+	Failed     int    `json:",omitempty"`
+}
+
+var (
+	eventsMu  sync.Mutex
+	eventsOut io.WriteCloser
+)
+
+func openEvents() {
+	if flgEvents == "" {
+		return
+	}
+	if flgEvents == "-" {
+		eventsOut = os.Stdout
+		return
+	}
+	f, err := os.Create(longPath(flgEvents))
+	fatalIfErr(err)
+	eventsOut = f
+}
+
+func closeEvents() {
+	if eventsOut != nil && eventsOut != os.Stdout {
+		eventsOut.Close()
+	}
+}
+
+func emitEvent(e *Event) {
+	if eventsOut == nil {
+		return
+	}
+	e.Time = time.Now()
+	d, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	fmt.Fprintf(eventsOut, "%s\n", d)
+}
+
+func newTestEvent(name string, t *Test) *Event {
+	return &Event{
+		Event: name,
+		Name:  testDisplayName(t),
+		Key:   testKey(t),
+		Pos:   testPos(t),
+	}
+}
+
+func emitTestStart(t *Test) {
+	emitEvent(newTestEvent("test_start", t))
+}
+
+func emitTestEnd(t *Test, dur time.Duration) {
+	e := newTestEvent("pass", t)
+	if isFailedTest(t) {
+		e.Event = "fail"
+		e.Reason = failureReason(t)
+	}
+	e.DurationMs = dur.Milliseconds()
+	emitEvent(e)
+}
+
+func emitTestSkip(t *Test, reason string) {
+	e := newTestEvent("skip", t)
+	e.Reason = reason
+	emitEvent(e)
+}
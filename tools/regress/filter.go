@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// filterTestsByName applies -run, which matches Name: of tests (or their
+// key if they don't have a name) like go test -run
+func filterTestsByName(tests []*Test) []*Test {
+	if flgRun == "" {
+		return tests
+	}
+	re, err := regexp.Compile(flgRun)
+	panicIf(err != nil, "invalid -run '%s': %s\n", flgRun, err)
+	var res []*Test
+	for _, t := range tests {
+		if re.MatchString(testDisplayName(t)) {
+			res = append(res, t)
+		}
+	}
+	fmt.Printf("-run '%s': %d of %d tests\n", flgRun, len(res), len(tests))
+	return res
+}
+
+// parseShardMust parses -shard 2/4 into 1-based shard number and count
+func parseShardMust(s string) (int, int) {
+	var i, n int
+	_, err := fmt.Sscanf(s, "%d/%d", &i, &n)
+	panicIf(err != nil || n < 1 || i < 1 || i > n, "-shard must be like 2/4, got '%s'\n", s)
+	return i, n
+}
+
+// testShard is stable between runs and tests files edits because it only
+// depends on test's id
+func testShard(t *Test, n int) int {
+	v, _ := strconv.ParseUint(testID(t)[:8], 16, 64)
+	return int(v%uint64(n)) + 1
+}
+
+// filterTestsByShard applies -shard i/n so that n machines or processes
+// can split the tests
+func filterTestsByShard(tests []*Test) []*Test {
+	if flgShard == "" {
+		return tests
+	}
+	i, n := parseShardMust(flgShard)
+	var res []*Test
+	for _, t := range tests {
+		if testShard(t, n) == i {
+			res = append(res, t)
+		}
+	}
+	fmt.Printf("-shard %s: %d of %d tests\n", flgShard, len(res), len(tests))
+	return res
+}
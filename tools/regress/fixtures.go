@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Fixtures are setup and teardown commands shared by tests, run once per run
+instead of for every test. They're defined in tests.txt in their own block:
+
+Fixture: fonts
+Setup: unzip -o tools/regress/fonts.zip -d out/regress/fonts
+Teardown: rm -rf out/regress/fonts
+
+(for fonts use Fonts:, see fonts.go) and tests that need them list them in Needs: fonts, printer
+
+Setup and Teardown: are shell commands (like hooks, see hooks.go) run with
+REGRESS_FIXTURE set to fixture name. Setup of a fixture runs before the
+first test if any test that will run needs it and teardown after all tests.
+If setup fails, tests that need the fixture fail with infrastructure error.
+*/
+
+// Fixture is a suite-level setup and teardown
+type Fixture struct {
+	Name     string
+	Setup    string
+	Teardown string
+	Fonts    string // directory or .zip with fonts to pin
+	Pos      string
+
+	ran      bool
+	fontsDir string // absolute path of pinned fonts
+	Err      error  // setup error
+}
+
+var (
+	// fixtures from the last parsed tests file, by name
+	suiteFixtures = map[string]*Fixture{}
+)
+
+func parseNeeds(pos string, val string) []string {
+	var res []string
+	for _, s := range strings.Split(val, ",") {
+		s = strings.TrimSpace(s)
+		panicIf(s == "", "%s: Needs: must be a list of fixture names, got '%s'\n", pos, val)
+		res = append(res, s)
+	}
+	return res
+}
+
+// verifyFixturesMust is called after parsing so that fixtures can be
+// defined after tests that use them
+func verifyFixturesMust(tests []*Test, fixtures map[string]*Fixture) {
+	for _, t := range tests {
+		for _, name := range t.Needs {
+			panicIf(fixtures[name] == nil, "%s: unknown fixture '%s' in Needs:\n", testPos(t), name)
+		}
+	}
+}
+
+func fixtureEnv(fx *Fixture) []string {
+	return []string{"REGRESS_FIXTURE=" + fx.Name}
+}
+
+// setupFixtures runs setup of fixtures needed by tests that will run
+func setupFixtures(tests []*Test) {
+	for _, t := range tests {
+		if t.Done || t.InfraError != nil {
+			continue
+		}
+		for _, name := range t.Needs {
+			fx := suiteFixtures[name]
+			if !fx.ran {
+				fx.ran = true
+				fmt.Printf("setting up fixture '%s' (%s)\n", fx.Name, fx.Pos)
+				fx.Err = runHook("fixture-setup", fx.Setup, fixtureEnv(fx))
+				if fx.Err == nil && fx.Fonts != "" {
+					fx.Err = installPinnedFonts(fx)
+				}
+			}
+			if fx.Err != nil {
+				t.InfraError = fmt.Errorf("setup of fixture '%s' failed: %w", fx.Name, fx.Err)
+				break
+			}
+		}
+	}
+}
+
+// teardownFixtures runs teardown of fixtures we set up, even if setup
+// failed because it might have been partially done
+func teardownFixtures() {
+	for _, fx := range suiteFixtures {
+		if !fx.ran {
+			continue
+		}
+		fmt.Printf("tearing down fixture '%s'\n", fx.Name)
+		err := runHook("fixture-teardown", fx.Teardown, fixtureEnv(fx))
+		if err != nil {
+			addSuiteError("%s: teardown of fixture '%s' failed: %s", fx.Pos, fx.Name, err)
+		}
+		removePinnedFonts(fx)
+	}
+}
+
+// fixturesForKey is what of fixtures affects result of the test
+func fixturesForKey(t *Test) []string {
+	var res []string
+	for _, name := range t.Needs {
+		if fx := suiteFixtures[name]; fx != nil {
+			res = append(res, fx.Name, fx.Setup, fx.Fonts)
+		}
+	}
+	return res
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+Text rendering depends on fonts installed on the machine so that the same
+test can pass locally and fail on CI image with different fonts. To pin
+fonts, a fixture (see fixtures.go) can have Fonts: with a directory or .zip
+with font files:
+
+Fixture: fonts
+Fonts: tools/regress/fonts.zip
+
+Setup of the fixture extracts font files to out/regress/fonts/<fixture>
+and tests that need the fixture run with MUPDF_FONTS_PATTERN pointing
+there, which makes mupdf load non-embedded fonts from that directory
+instead of %WINDIR%\Fonts. Only debug builds of SumatraPDF look at
+MUPDF_FONTS_PATTERN (see ext/mupdf_load_system_font.c) so we also check
+which fonts the binary resolved.
+
+To know which fonts it resolved we run tests that use pinned fonts or have
+Fonts: with -log and parse "loading non-embedded font" lines of the log.
+A test fails if any font was loaded from outside of pinned fonts directory
+or if the font doesn't match the test's Fonts: e.g.
+
+Fonts: Helvetica=arial.ttf, Times-Roman=times.ttf
+*/
+
+// ExpectedFont is from Fonts: of a test
+type ExpectedFont struct {
+	Name string // font name from the document e.g. Helvetica
+	File string // file name of the font it must resolve to e.g. arial.ttf
+}
+
+const fontsMismatchPrefix = "fonts: "
+
+var (
+	// same pattern as in ext/mupdf_load_system_font.c
+	fontFileExts = []string{".ttf", ".otf", ".ttc"}
+
+	rxResolvedFont = regexp.MustCompile(`(?:loading non-embedded font|found cached non-embedded buffer for font) '(.*)' from '(.*)'`)
+)
+
+func parseExpectedFonts(pos string, val string) []*ExpectedFont {
+	var res []*ExpectedFont
+	for _, s := range strings.Split(val, ",") {
+		parts := strings.SplitN(s, "=", 2)
+		ok := len(parts) == 2
+		if ok {
+			parts[0] = strings.TrimSpace(parts[0])
+			parts[1] = strings.TrimSpace(parts[1])
+			ok = parts[0] != "" && parts[1] != ""
+		}
+		panicIf(!ok, "%s: Fonts: must be a list of font=file, got '%s'\n", pos, val)
+		res = append(res, &ExpectedFont{Name: parts[0], File: parts[1]})
+	}
+	return res
+}
+
+func isFontFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, s := range fontFileExts {
+		if ext == s {
+			return true
+		}
+	}
+	return false
+}
+
+func pinnedFontsDir(fx *Fixture) string {
+	return filepath.Join("out", "regress", "fonts", fx.Name)
+}
+
+func extractFontsFromZip(dstDir string, zipPath string) (int, error) {
+	zr, err := zip.OpenReader(longPath(zipPath))
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	n := 0
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isFontFile(f.Name) {
+			continue
+		}
+		// flatten so that the pattern finds them
+		dst := filepath.Join(dstDir, filepath.Base(f.Name))
+		r, err := f.Open()
+		if err != nil {
+			return n, err
+		}
+		d, err := ioutil.ReadAll(io.LimitReader(r, 256*1024*1024))
+		r.Close()
+		if err != nil {
+			return n, err
+		}
+		err = ioutil.WriteFile(longPath(dst), d, 0644)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func copyFontsFromDir(dstDir string, srcDir string) (int, error) {
+	files, err := ioutil.ReadDir(longPath(srcDir))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, fi := range files {
+		if fi.IsDir() || !isFontFile(fi.Name()) {
+			continue
+		}
+		err = copyFile(filepath.Join(dstDir, fi.Name()), filepath.Join(srcDir, fi.Name()))
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// installPinnedFonts is part of fixture setup, it runs after Setup: so
+// that Setup: can e.g. download the fonts
+func installPinnedFonts(fx *Fixture) error {
+	dir := pinnedFontsDir(fx)
+	os.RemoveAll(longPath(dir))
+	err := os.MkdirAll(longPath(dir), 0755)
+	if err != nil {
+		return err
+	}
+	var n int
+	if strings.EqualFold(filepath.Ext(fx.Fonts), ".zip") {
+		n, err = extractFontsFromZip(dir, fx.Fonts)
+	} else {
+		n, err = copyFontsFromDir(dir, fx.Fonts)
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no font files (%s) in '%s'", strings.Join(fontFileExts, ", "), fx.Fonts)
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	fx.fontsDir = dir
+	fmt.Printf("pinned %d fonts from '%s' in '%s'\n", n, fx.Fonts, dir)
+	return nil
+}
+
+func removePinnedFonts(fx *Fixture) {
+	if fx.fontsDir != "" {
+		os.RemoveAll(longPath(fx.fontsDir))
+	}
+}
+
+// testFontsDir returns directory with pinned fonts of the test, "" if the
+// test doesn't need a fixture with Fonts:
+func testFontsDir(t *Test) string {
+	for _, name := range t.Needs {
+		if fx := suiteFixtures[name]; fx != nil && fx.Fonts != "" {
+			return fx.fontsDir
+		}
+	}
+	return ""
+}
+
+func pinnedFontsEnv(t *Test) []string {
+	dir := testFontsDir(t)
+	if dir == "" {
+		return nil
+	}
+	return []string{"MUPDF_FONTS_PATTERN=" + filepath.Join(dir, "*.?t?")}
+}
+
+func needsFontCheck(t *Test) bool {
+	return len(t.Fonts) > 0 || testFontsDir(t) != ""
+}
+
+func fontLogDir(t *Test) string {
+	return filepath.Join(t.TempDir, "fontlog")
+}
+
+// fontLogArgs makes the test log which fonts it loads
+func fontLogArgs(t *Test, args []string) ([]string, error) {
+	if !needsFontCheck(t) {
+		return args, nil
+	}
+	err := os.MkdirAll(longPath(fontLogDir(t)), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{"-log"}, args...), nil
+}
+
+func fontLogEnv(t *Test) []string {
+	if t.TempDir == "" || !needsFontCheck(t) {
+		return nil
+	}
+	return []string{"LOCALAPPDATA=" + fontLogDir(t)}
+}
+
+func parseResolvedFonts(log string) map[string]string {
+	res := map[string]string{}
+	for _, m := range rxResolvedFont.FindAllStringSubmatch(log, -1) {
+		res[m[1]] = m[2]
+	}
+	return res
+}
+
+// collectResolvedFonts must be called before the test is unstaged
+func collectResolvedFonts(t *Test) {
+	if !needsFontCheck(t) {
+		return
+	}
+	d, err := ioutil.ReadFile(longPath(filepath.Join(fontLogDir(t), "sumatra-log.txt")))
+	if err != nil {
+		// it's fine if the document doesn't use non-embedded fonts
+		return
+	}
+	t.ResolvedFonts = parseResolvedFonts(string(d))
+}
+
+func isInDir(path string, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// fontBaseName handles paths of Windows binary also when we run on other os
+func fontBaseName(path string) string {
+	if i := strings.LastIndexAny(path, `\/`); i >= 0 {
+		path = path[i+1:]
+	}
+	return path
+}
+
+func checkFonts(t *Test) []string {
+	var res []string
+	if dir := testFontsDir(t); dir != "" {
+		var names []string
+		for name := range t.ResolvedFonts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			path := t.ResolvedFonts[name]
+			if !isInDir(path, dir) {
+				res = append(res, fmt.Sprintf("%sfont '%s' resolved to '%s' which is not a pinned font", fontsMismatchPrefix, name, path))
+			}
+		}
+	}
+	for _, f := range t.Fonts {
+		path, ok := t.ResolvedFonts[f.Name]
+		if !ok {
+			res = append(res, fmt.Sprintf("%sfont '%s' was not loaded, expected '%s'", fontsMismatchPrefix, f.Name, f.File))
+			continue
+		}
+		if !strings.EqualFold(fontBaseName(path), f.File) {
+			res = append(res, fmt.Sprintf("%sfont '%s' resolved to '%s', expected '%s'", fontsMismatchPrefix, f.Name, path, f.File))
+		}
+	}
+	return res
+}
+
+func hasFontsMismatch(t *Test) bool {
+	for _, s := range t.OutputMismatches {
+		if strings.HasPrefix(s, fontsMismatchPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func fontsForKey(t *Test) string {
+	var a []string
+	for _, f := range t.Fonts {
+		a = append(a, f.Name+"="+f.File)
+	}
+	return strings.Join(a, ", ")
+}
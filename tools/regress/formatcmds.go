@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Default commands per file format are in -format-cmds file, one per line:
+
+# format cmd
+epub SumatraPDF.exe -render 1 $file
+* SumatraPDF.exe -render 1 $file
+
+format is file extension (without '.') and * matches all other formats.
+They're used for tests without Cmd:, by smoke-all and import-crashes so
+that we don't have to repeat the same command for every test.
+*/
+
+var (
+	// format => cmd, nil until loaded
+	formatCmds map[string]string
+)
+
+func parseFormatCmdsMust(path string) map[string]string {
+	res := map[string]string{}
+	d, err := ioutil.ReadFile(longPath(path))
+	fatalIfErr(err)
+	for i, l := range toTrimmedLines(d) {
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		parts := strings.SplitN(l, " ", 2)
+		panicIf(len(parts) != 2, "%s:%d: invalid line '%s', expected: format cmd\n", path, i+1, l)
+		format := strings.ToLower(strings.TrimPrefix(parts[0], "."))
+		cmd := strings.TrimSpace(parts[1])
+		_, dup := res[format]
+		panicIf(dup, "%s:%d: duplicate format '%s'\n", path, i+1, format)
+		panicIf(!strings.Contains(cmd, "$file"), "%s:%d: cmd '%s' must use $file\n", path, i+1, cmd)
+		res[format] = cmd
+	}
+	return res
+}
+
+func loadFormatCmds() map[string]string {
+	if formatCmds != nil {
+		return formatCmds
+	}
+	formatCmds = map[string]string{}
+	path := flgFormatCmds
+	if path == "" {
+		return formatCmds
+	}
+	if _, err := os.Stat(longPath(path)); os.IsNotExist(err) {
+		return formatCmds
+	}
+	formatCmds = parseFormatCmdsMust(path)
+	return formatCmds
+}
+
+// formatOfName returns e.g. "pdf" for "foo.PDF"
+func formatOfName(name string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+}
+
+// testFileFormat returns e.g. "pdf", from meta file if we have it or
+// from original name, url or path of the file
+func testFileFormat(t *Test) string {
+	if tf := testFilesBySha1[t.FileSha1Hex]; tf != nil && tf.Meta != nil && tf.Meta.Format != "" {
+		return tf.Meta.Format
+	}
+	if format := formatOfName(t.OrigName); format != "" {
+		return format
+	}
+	if format := formatOfName(origNameFromURL(t.FileURL)); format != "" {
+		return format
+	}
+	return formatOfName(t.FilePath)
+}
+
+// formatCmd returns default command for a format, "" if there's none
+func formatCmd(format string) string {
+	cmds := loadFormatCmds()
+	if cmd, ok := cmds[format]; ok {
+		return cmd
+	}
+	return cmds["*"]
+}
+
+// setCmd sets CmdUnparsed and CmdName, CmdArgs derived from it
+func setCmd(t *Test, cmd string) {
+	t.CmdUnparsed = cmd
+	parts := strings.Split(cmd, " ")
+	t.CmdName = parts[0]
+	t.CmdArgs = parts[1:]
+}
+
+func formatCmdMissingMsg(format string) string {
+	return fmt.Sprintf("no default command for format '%s' in '%s'", format, flgFormatCmds)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+regress gen-tests dir/ turns a directory of files (e.g. attachments of bug
+reports) into tests. For each file it:
+- saves it in the cache
+- with -s3 bucket/prefix uploads it to prefix/${sha1[:2]}/${sha1[2:4]}/${sha1[4:]}${ext}
+  (the layout of kjkpub/testfiles), otherwise Url: is a file:// url of
+  the local file
+- runs the default command for its format (see formatcmds.go) and uses
+  its output as expected output
+
+Test entries are appended to -out so that they can be reviewed and moved
+to tests.txt.
+*/
+
+// testFileS3Key returns e.g. testfiles/6f/d3/89a36816f1ab490d46c0c7a6b34b678f72bf.pdf
+func testFileS3Key(prefix string, sha1Hex string, ext string) string {
+	return path.Join(prefix, sha1Hex[:2], sha1Hex[2:4], sha1Hex[4:]+strings.ToLower(ext))
+}
+
+func fileURLFromPath(path string) string {
+	abs, err := filepath.Abs(path)
+	fatalIfErr(err)
+	u := &url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+	if !strings.HasPrefix(u.Path, "/") {
+		// c:/foo => /c:/foo
+		u.Path = "/" + u.Path
+	}
+	return u.String()
+}
+
+// dlTestFileMust also supports file:// urls, used by tests from gen-tests
+func dlTestFileMust(uri string) []byte {
+	if !strings.HasPrefix(uri, "file://") {
+		return httpDlMust(uri)
+	}
+	u, err := url.Parse(uri)
+	fatalIfErr(err)
+	p := u.Path
+	if len(p) > 2 && p[2] == ':' {
+		// /c:/foo => c:/foo
+		p = p[1:]
+	}
+	d, err := ioutil.ReadFile(longPath(filepath.FromSlash(p)))
+	fatalIfErr(err)
+	return d
+}
+
+func listFilesForGenTests(dir string) []string {
+	var res []string
+	err := filepath.Walk(longPath(dir), func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(fi.Name(), ".") && path != longPath(dir) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsRegular() {
+			res = append(res, path)
+		}
+		return nil
+	})
+	fatalIfErr(err)
+	sort.Strings(res)
+	return res
+}
+
+func genTestEntry(t *Test, srcPath string) string {
+	s := "# generated from " + srcPath + "\n"
+	if t.Error != nil {
+		s += fmt.Sprintf("# initial run failed with '%s', check expected output\n", t.Error)
+	}
+	s += "Url: " + t.FileURL + "\n"
+	s += "Sha1: " + t.FileSha1Hex + "\n"
+	if name := filepath.Base(srcPath); name != origNameFromURL(t.FileURL) {
+		s += "OrigName: " + name + "\n"
+	}
+	s += "Cmd: " + t.CmdUnparsed + "\n"
+	out := strings.Replace(t.Output, t.FilePath, "$file", -1)
+	switch {
+	case t.Error != nil:
+		s += "Assert: exitCode == 0\n"
+	case out == "":
+		s += "OutLineCount: 0\n"
+	case strings.Contains(out, "\n"):
+		s += fmt.Sprintf("OutContains: %s\n", strings.SplitN(out, "\n", 2)[0])
+		s += fmt.Sprintf("OutLineCount: %d\n", len(strings.Split(out, "\n")))
+	default:
+		s += "Out: " + out + "\n"
+	}
+	return s + "\n"
+}
+
+func genTests(args []string) {
+	fs := flag.NewFlagSet("gen-tests", flag.ExitOnError)
+	s3Dest := fs.String("s3", "", "upload files to s3 bucket/prefix e.g. kjkpub/testfiles")
+	urlPrefix := fs.String("url-prefix", "", "public url of -s3 bucket/prefix (default: https://${bucket}.s3.amazonaws.com/${prefix})")
+	out := fs.String("out", filepath.Join("out", "regress", "gen-tests.txt"), "file to append generated test entries to")
+	fs.Parse(args)
+	panicIf(fs.NArg() != 1, "usage: regress gen-tests [-s3 bucket/prefix] [-out file] dir\n")
+	dir := fs.Arg(0)
+	panicIf(!dirExists(dir), "'%s' is not a directory\n", dir)
+
+	var s3 *s3Client
+	var s3Prefix string
+	if *s3Dest != "" {
+		var bucket string
+		bucket, s3Prefix = parseS3Dest(*s3Dest)
+		s3 = newS3ClientMust(bucket)
+		if *urlPrefix == "" {
+			*urlPrefix = "https://" + bucket + ".s3.amazonaws.com/" + s3Prefix
+		}
+		*urlPrefix = strings.TrimSuffix(*urlPrefix, "/")
+	}
+
+	verifyTestFiles()
+	existing := map[string]bool{}
+	if fileExists(flgTests) {
+		for _, t := range parseTestsMust(flgTests) {
+			existing[testKey(t)] = true
+		}
+	}
+	var tests []*Test
+	srcPaths := map[*Test]string{}
+	for _, srcPath := range listFilesForGenTests(dir) {
+		format := formatOfName(srcPath)
+		cmd := formatCmd(format)
+		if cmd == "" {
+			fmt.Printf("skipping '%s': %s\n", srcPath, formatCmdMissingMsg(format))
+			continue
+		}
+		d, err := ioutil.ReadFile(srcPath)
+		fatalIfErr(err)
+		sha1Hex := sha1HexOfBytes(d)
+		ext := filepath.Ext(srcPath)
+		uri := fileURLFromPath(srcPath)
+		if s3 != nil {
+			key := testFileS3Key(s3Prefix, sha1Hex, ext)
+			err = s3.put(key, d)
+			fatalIfErr(err)
+			uri = *urlPrefix + "/" + strings.TrimPrefix(strings.TrimPrefix(key, s3Prefix), "/")
+			fmt.Printf("uploaded '%s' to '%s'\n", srcPath, uri)
+		}
+		t := &Test{
+			Name:        "gen-tests " + filepath.Base(srcPath),
+			FileSha1Hex: sha1Hex,
+			FileURL:     uri,
+			OrigName:    filepath.Base(srcPath),
+			Path:        "gen-tests",
+		}
+		setCmd(t, cmd)
+		if existing[testKey(t)] {
+			fmt.Printf("skipping '%s': already tested by %s\n", srcPath, flgTests)
+			continue
+		}
+		existing[testKey(t)] = true
+		if testFilesBySha1[sha1Hex] == nil {
+			fmt.Printf("copying '%s'...", srcPath)
+			tf, err := saveTestFile(d, uri, t.OrigName)
+			fatalIfErr(err)
+			testFilesBySha1[sha1Hex] = tf
+		}
+		tests = append(tests, t)
+		srcPaths[t] = srcPath
+	}
+	if len(tests) == 0 {
+		fmt.Printf("no new tests to generate from '%s'\n", dir)
+		return
+	}
+	verifyCommandsMust(tests)
+	setTestFilePathsMust(tests)
+	err := os.MkdirAll(longPath(filepath.Dir(*out)), 0755)
+	fatalIfErr(err)
+	nGenerated := 0
+	for _, t := range tests {
+		if t.InfraError != nil {
+			fmt.Printf("skipping '%s': %s\n", srcPaths[t], t.InfraError)
+			continue
+		}
+		runTest(t)
+		err = appendToFile(*out, genTestEntry(t, srcPaths[t]))
+		fatalIfErr(err)
+		nGenerated++
+	}
+	removeScratchDir()
+	fmt.Printf("generated %d tests from '%s', test entries are in '%s'\n", nGenerated, dir, *out)
+}
@@ -0,0 +1,3 @@
+module github.com/sumatrapdfreader/sumatrapdf/tools/regress
+
+go 1.18
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+regress check-growth is run by CI for changes to tests files. It compares
+tests with tests in the base revision (-base, a git ref) and fails if the
+change grows the suite by more than:
+- -max-download-mb of test files that a machine with empty cache must
+  download (new files minus files no longer used)
+- -max-runtime of expected run time (added minus removed tests)
+
+Run time of a test is its duration in the last run (-results), its
+Budget: or -default-runtime. Size of a file is the size of the cached file
+or Content-Length from its url.
+
+A change that really needs to grow the suite more is checked with
+-allow-growth, which reports the growth but doesn't fail.
+
+Must be run from the root of the repository because git paths are
+relative to it.
+*/
+
+type suiteSize struct {
+	tests   map[string]*Test // by testID
+	files   map[string]*Test // by sha1, a test that uses the file
+	runtime map[string]time.Duration
+}
+
+func newSuiteSize(tests []*Test, durations map[string]time.Duration, defRuntime time.Duration) *suiteSize {
+	res := &suiteSize{
+		tests:   map[string]*Test{},
+		files:   map[string]*Test{},
+		runtime: map[string]time.Duration{},
+	}
+	for _, t := range tests {
+		id := testID(t)
+		res.tests[id] = t
+		if res.files[t.FileSha1Hex] == nil {
+			res.files[t.FileSha1Hex] = t
+		}
+		d, ok := durations[id]
+		if !ok {
+			d = t.Budget
+		}
+		if d == 0 {
+			d = defRuntime
+		}
+		res.runtime[id] = d
+	}
+	return res
+}
+
+// parseTestsFileOnly parses tests from files without tests registered in
+// Go code, those are the same in both revisions
+func parseTestsFileOnly(path string) []*Test {
+	p := &testsParser{fixtures: map[string]*Fixture{}}
+	p.parseFileMust(path)
+	return p.tests
+}
+
+// extractGitDir extracts dir from git revision ref to dstDir
+func extractGitDir(ref string, dir string, dstDir string) error {
+	zipPath := filepath.Join(dstDir, "base.zip")
+	cmd := exec.Command("git", "archive", "--format=zip", "-o", zipPath, ref, "--", filepath.ToSlash(dir))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed with '%s': %s", cmdToStrLong(cmd), err, strings.TrimSpace(string(out)))
+	}
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		dst := filepath.Join(dstDir, filepath.FromSlash(f.Name))
+		if !isInDir(dst, dstDir) {
+			return fmt.Errorf("invalid path '%s' in git archive", f.Name)
+		}
+		err = os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return err
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		d, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(dst, d, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseBaseTestsMust(ref string) []*Test {
+	dir, err := ioutil.TempDir("", "regress-growth-")
+	fatalIfErr(err)
+	defer os.RemoveAll(dir)
+	err = extractGitDir(ref, filepath.Dir(flgTests), dir)
+	fatalIfErr(err)
+	path := filepath.Join(dir, flgTests)
+	if !fileExists(path) {
+		fmt.Printf("'%s' doesn't exist in %s, all tests are new\n", flgTests, ref)
+		return nil
+	}
+	return parseTestsFileOnly(path)
+}
+
+func loadDurationsMust(path string) map[string]time.Duration {
+	res := map[string]time.Duration{}
+	run, err := loadRunResults(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fatalIfErr(err)
+		}
+		return res
+	}
+	for _, r := range run.Tests {
+		if r.DurationMs > 0 {
+			res[resultID(r)] = time.Duration(r.DurationMs) * time.Millisecond
+		}
+	}
+	return res
+}
+
+// testFileSize returns -1 if size is not known
+func testFileSize(client *http.Client, t *Test) int64 {
+	if tf := testFilesBySha1[t.FileSha1Hex]; tf != nil {
+		if fi, err := os.Stat(longPath(tf.Path)); err == nil {
+			return fi.Size()
+		}
+	}
+	if t.FileURL == "" || strings.HasPrefix(t.FileURL, "file://") {
+		return -1
+	}
+	c := &URLCheck{URL: t.FileURL, Sha1Hex: t.FileSha1Hex, Size: -1}
+	checkURL(client, c)
+	if c.isDead() {
+		return -1
+	}
+	return c.Size
+}
+
+// sizeOfFilesNotIn returns total size of files in a and not in b
+func sizeOfFilesNotIn(client *http.Client, a, b *suiteSize, what string) (int64, int) {
+	var total int64
+	nUnknown := 0
+	var sha1s []string
+	for sha1Hex := range a.files {
+		if b.files[sha1Hex] == nil {
+			sha1s = append(sha1s, sha1Hex)
+		}
+	}
+	sort.Strings(sha1s)
+	for _, sha1Hex := range sha1s {
+		t := a.files[sha1Hex]
+		size := testFileSize(client, t)
+		if size < 0 {
+			fmt.Printf("  %s file %s (%s): size unknown\n", what, sha1Hex, t.FileURL)
+			nUnknown++
+			continue
+		}
+		fmt.Printf("  %s file %s: %.1f MB\n", what, sha1Hex, float64(size)/(1024*1024))
+		total += size
+	}
+	return total, nUnknown
+}
+
+// runtimeOfTestsNotIn returns total run time of tests in a and not in b
+func runtimeOfTestsNotIn(a, b *suiteSize, what string) time.Duration {
+	var total time.Duration
+	var ids []string
+	for id := range a.tests {
+		if b.tests[id] == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		t := a.tests[id]
+		fmt.Printf("  %s test %s (%s): %s\n", what, testDisplayName(t), testPos(t), a.runtime[id])
+		total += a.runtime[id]
+	}
+	return total
+}
+
+func checkGrowth(args []string) {
+	fs := flag.NewFlagSet("check-growth", flag.ExitOnError)
+	base := fs.String("base", "origin/master", "git revision to compare tests with")
+	maxDownloadMB := fs.Float64("max-download-mb", 50, "max growth of size of test files to download, in MB")
+	maxRuntime := fs.Duration("max-runtime", time.Minute, "max growth of expected run time")
+	defRuntime := fs.Duration("default-runtime", 2*time.Second, "expected run time of a test that didn't run yet and has no Budget:")
+	allow := fs.Bool("allow-growth", false, "report growth over the limits but don't fail")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout for getting size of a file from its url")
+	fs.Parse(args)
+
+	verifyTestFiles()
+	durations := loadDurationsMust(flgResults)
+	cur := newSuiteSize(parseTestsFileOnly(flgTests), durations, *defRuntime)
+	prev := newSuiteSize(parseBaseTestsMust(*base), durations, *defRuntime)
+	fmt.Printf("%d tests in %s, %d tests now\n", len(prev.tests), *base, len(cur.tests))
+
+	client := &http.Client{Timeout: *timeout}
+	added, nUnknown := sizeOfFilesNotIn(client, cur, prev, "new")
+	removed, _ := sizeOfFilesNotIn(client, prev, cur, "removed")
+	downloadMB := float64(added-removed) / (1024 * 1024)
+	runtimeGrowth := runtimeOfTestsNotIn(cur, prev, "new") - runtimeOfTestsNotIn(prev, cur, "removed")
+
+	var over []string
+	fmt.Printf("download size: %+.1f MB (max %.1f MB)\n", downloadMB, *maxDownloadMB)
+	if downloadMB > *maxDownloadMB {
+		over = append(over, fmt.Sprintf("download size grows by %.1f MB, more than -max-download-mb %.1f", downloadMB, *maxDownloadMB))
+	}
+	if nUnknown > 0 {
+		fmt.Printf("size of %d new files is unknown and not counted\n", nUnknown)
+	}
+	fmt.Printf("expected run time: %s (max %s)\n", runtimeGrowth, *maxRuntime)
+	if runtimeGrowth > *maxRuntime {
+		over = append(over, fmt.Sprintf("expected run time grows by %s, more than -max-runtime %s", runtimeGrowth, *maxRuntime))
+	}
+	if len(over) == 0 {
+		fmt.Printf("growth is within limits\n")
+		return
+	}
+	for _, s := range over {
+		fmt.Printf("%s\n", s)
+	}
+	if *allow {
+		fmt.Printf("allowed with -allow-growth\n")
+		return
+	}
+	fmt.Printf("use -allow-growth if the growth is intended\n")
+	os.Exit(1)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+A test that runs longer than -timeout is considered hung. Before killing
+it we save its thread stacks so that we can tell where it hangs:
+- with procdump -ma if it's in PATH (or -procdump)
+- otherwise with MiniDumpWriteDump() on Windows and gdb elsewhere
+The dump is added to test's artifacts.
+
+Then we ask the process to exit (WM_CLOSE and CTRL_BREAK on Windows,
+SIGTERM elsewhere) so that it can flush logs and run crash handlers and
+kill it only if it's still running after -kill-grace.
+
+Timeout: 30s of a test overrides -timeout, e.g. for tests of big files
+that are expected to run long or for tests that must not hang the run for
+minutes.
+*/
+
+func parseTimeout(pos string, val string) time.Duration {
+	d, err := time.ParseDuration(val)
+	panicIf(err != nil || d <= 0, "%s: Timeout: must be a duration > 0 like 30s or 2m, got '%s'\n", pos, val)
+	return d
+}
+
+// testTimeout returns 0 if the test can run forever
+func testTimeout(t *Test) time.Duration {
+	if t.Timeout > 0 {
+		return t.Timeout
+	}
+	return flgTimeout
+}
+
+func getDumpsDir() (string, error) {
+	d := filepath.Join("out", "regress", "dumps")
+	err := os.MkdirAll(longPath(d), 0755)
+	return d, err
+}
+
+func dumpPathForTest(t *Test, ext string) (string, error) {
+	dir, err := getDumpsDir()
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s%s", t.FileSha1Hex, time.Now().Format("20060102-150405"), ext)
+	return filepath.Join(dir, name), nil
+}
+
+func runProcdump(pid int, path string) error {
+	cmd := exec.Command(flgProcdumpPath, "-accepteula", "-ma", fmt.Sprintf("%d", pid), path)
+	fmt.Printf("Running: %s\n", cmdToStrLong(cmd))
+	// procdump exits with non-zero code even on success
+	out, _ := cmd.CombinedOutput()
+	if !fileExists(path) {
+		return fmt.Errorf("procdump didn't create '%s'\n%s", path, out)
+	}
+	return nil
+}
+
+// captureHang saves stacks of a hung process and returns path of the file
+func captureHang(t *Test, pid int) (string, error) {
+	if _, err := exec.LookPath(flgProcdumpPath); err == nil {
+		path, err := dumpPathForTest(t, ".dmp")
+		if err != nil {
+			return "", err
+		}
+		os.Remove(path)
+		err = runProcdump(pid, path)
+		if err == nil {
+			return path, nil
+		}
+		fmt.Printf("procdump failed: %s\n", err)
+	}
+	return writeProcessDump(t, pid)
+}
+
+// runCmdWithTimeout is like cmd.Output() but captures stacks and kills
+// the process if it runs longer than -timeout. Stderr goes to t.Stderr.
+func runCmdWithTimeout(t *Test, cmd *exec.Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	// re-run with diagnostics captures stderr itself
+	if cmd.Stderr == nil {
+		cmd.Stderr = &stderr
+		defer func() {
+			t.Stderr = strings.TrimSpace(stderr.String())
+		}()
+	}
+	timeout := testTimeout(t)
+	if timeout > 0 && flgKillGrace > 0 {
+		setupCmdForExitRequest(cmd)
+	}
+	sb, err := setupSandbox(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if sb != nil {
+		defer func() {
+			if kb := sb.peakMemoryKB(); kb > 0 {
+				t.PeakMemoryKB = kb
+			}
+			sb.close()
+		}()
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	if sb != nil {
+		err = sb.start(cmd.Process.Pid)
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, err
+		}
+	}
+	if timeout <= 0 {
+		err = cmd.Wait()
+		return stdout.Bytes(), err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+		return stdout.Bytes(), err
+	case <-time.After(timeout):
+	}
+	fmt.Printf("test timed out after %s, capturing stacks of pid %d\n", timeout, cmd.Process.Pid)
+	path, dumpErr := captureHang(t, cmd.Process.Pid)
+	if dumpErr != nil {
+		fmt.Printf("failed to capture stacks: %s\n", dumpErr)
+	} else {
+		fmt.Printf("saved stacks to '%s'\n", path)
+		t.Artifacts = append(t.Artifacts, path)
+		symbolizeDump(t, path)
+	}
+	err = fmt.Errorf("timed out after %s", timeout)
+	if stopHungProcess(cmd, done) {
+		return stdout.Bytes(), err
+	}
+	// child processes might keep stdout open so don't wait forever
+	select {
+	case <-done:
+		return stdout.Bytes(), err
+	case <-time.After(5 * time.Second):
+		// stdout is still being written to
+		return nil, err
+	}
+}
+
+// stopHungProcess asks the process to exit and kills it if it doesn't
+// within -kill-grace. Returns true if it exited on its own.
+func stopHungProcess(cmd *exec.Cmd, done chan error) bool {
+	pid := cmd.Process.Pid
+	if flgKillGrace > 0 {
+		err := requestExit(pid)
+		if err == nil {
+			fmt.Printf("asked pid %d to exit, waiting %s before killing it\n", pid, flgKillGrace)
+			select {
+			case <-done:
+				fmt.Printf("pid %d exited after being asked to\n", pid)
+				return true
+			case <-time.After(flgKillGrace):
+			}
+		} else {
+			fmt.Printf("failed to ask pid %d to exit: %s\n", pid, err)
+		}
+	}
+	cmd.Process.Kill()
+	return false
+}
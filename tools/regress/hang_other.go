@@ -0,0 +1,33 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"syscall"
+)
+
+// writeProcessDump saves backtraces of all threads with gdb
+func writeProcessDump(t *Test, pid int) (string, error) {
+	path, err := dumpPathForTest(t, ".txt")
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("gdb", "-p", fmt.Sprintf("%d", pid), "-batch", "-ex", "thread apply all bt")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("gdb failed with %w\n%s", err, out)
+	}
+	err = ioutil.WriteFile(longPath(path), out, 0644)
+	return path, err
+}
+
+func setupCmdForExitRequest(cmd *exec.Cmd) {
+}
+
+// requestExit sends SIGTERM
+func requestExit(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
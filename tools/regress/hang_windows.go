@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	moddbghelp            = syscall.NewLazyDLL("dbghelp.dll")
+	procMiniDumpWriteDump = moddbghelp.NewProc("MiniDumpWriteDump")
+
+	moduser32                    = syscall.NewLazyDLL("user32.dll")
+	procEnumWindows              = moduser32.NewProc("EnumWindows")
+	procGetWindowThreadProcessID = moduser32.NewProc("GetWindowThreadProcessId")
+	procPostMessageW             = moduser32.NewProc("PostMessageW")
+
+	procGenerateConsoleCtrlEvent = modkernel32.NewProc("GenerateConsoleCtrlEvent")
+)
+
+const (
+	processVMRead           = 0x0010
+	processQueryInformation = 0x0400
+	miniDumpWithFullMemory  = 0x2
+
+	createNewProcessGroup = 0x00000200
+	ctrlBreakEvent        = 1
+	wmClose               = 0x0010
+)
+
+// writeProcessDump writes a full memory dump, same as procdump -ma
+func writeProcessDump(t *Test, pid int) (string, error) {
+	path, err := dumpPathForTest(t, ".dmp")
+	if err != nil {
+		return "", err
+	}
+	h, err := syscall.OpenProcess(processQueryInformation|processVMRead, false, uint32(pid))
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(h)
+	f, err := os.Create(longPath(path))
+	if err != nil {
+		return "", err
+	}
+	r, _, err := procMiniDumpWriteDump.Call(uintptr(h), uintptr(pid), f.Fd(), miniDumpWithFullMemory, 0, 0, 0)
+	f.Close()
+	if r == 0 {
+		os.Remove(longPath(path))
+		return "", err
+	}
+	return path, nil
+}
+
+// setupCmdForExitRequest starts the process in its own process group so
+// that requestExit can send it CTRL_BREAK without also getting it
+func setupCmdForExitRequest(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= createNewProcessGroup
+}
+
+// requestExit sends WM_CLOSE to top-level windows of the process and
+// CTRL_BREAK to its console
+func requestExit(pid int) error {
+	nClosed := 0
+	cb := syscall.NewCallback(func(hwnd uintptr, lparam uintptr) uintptr {
+		var windowPid uint32
+		procGetWindowThreadProcessID.Call(hwnd, uintptr(unsafe.Pointer(&windowPid)))
+		if int(windowPid) == pid {
+			procPostMessageW.Call(hwnd, wmClose, 0, 0)
+			nClosed++
+		}
+		return 1
+	})
+	procEnumWindows.Call(cb, 0)
+	r, _, err := procGenerateConsoleCtrlEvent.Call(ctrlBreakEvent, uintptr(pid))
+	if nClosed == 0 && r == 0 {
+		return errors.New("no windows to close and CTRL_BREAK failed: " + err.Error())
+	}
+	return nil
+}
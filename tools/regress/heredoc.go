@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+Out: is a single line. Multi-line output (e.g. render log of several pages)
+is given as a block that ends with a line with just the terminator:
+
+Out: <<END
+rendering page 1
+rendering page 2
+END
+
+The same works for Out[2]:, Out@gpu=sw: and Err:. Lines of the block are
+not trimmed (only \r is removed) and can be empty, they don't end the test.
+*/
+
+// Out: <<END, Out[2]: <<END, Out@gpu=sw: <<END, Err: <<END
+var rxHeredocStart = regexp.MustCompile(`(?i)^(err|out(?:\[\d+\]|@[^:]+)?)\s*:\s*<<([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// parseHeredocStart returns field name and terminator if l starts a block
+func parseHeredocStart(l string) (string, string, bool) {
+	m := rxHeredocStart.FindStringSubmatch(l)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// toTestLinesWithHeredocs is like toTestLines but a block becomes a single
+// line e.g. "Out: line1\nline2" at the line number of Out: <<END
+func toTestLinesWithHeredocs(path string, d []byte) []TestLine {
+	rawLines := strings.Split(string(d), "\n")
+	var res []TestLine
+	for i := 0; i < len(rawLines); i++ {
+		l := strings.TrimSpace(rawLines[i])
+		tl := TestLine{
+			Text:   l,
+			LineNo: i + 1,
+		}
+		name, end, ok := parseHeredocStart(l)
+		if !ok {
+			res = append(res, tl)
+			continue
+		}
+		var body []string
+		closed := false
+		for i+1 < len(rawLines) {
+			i++
+			s := strings.TrimRight(rawLines[i], "\r")
+			if strings.TrimSpace(s) == end {
+				closed = true
+				break
+			}
+			body = append(body, s)
+		}
+		panicIf(!closed, "%s:%d: %s: <<%s is missing terminating '%s' line\n", path, tl.LineNo, name, end, end)
+		tl.Text = fmt.Sprintf("%s: %s", name, strings.Join(body, "\n"))
+		res = append(res, tl)
+	}
+	return res
+}
+
+// normalizeNewlines makes output of Windows programs comparable with
+// multi-line Out:
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+History of runs is a directory with one ${runID}.json per run,
+in the same format as -results. It also has labels.json with owners and
+labels of tests (see labels.go).
+*/
+
+func saveRunToHistory(res *RunResults) {
+	if flgHistory == "" {
+		return
+	}
+	path := filepath.Join(flgHistory, res.ID+".json")
+	err := saveRunResults(path, res)
+	if err != nil {
+		fmt.Printf("failed to save run to history '%s': %s\n", path, err)
+	}
+}
+
+// loadHistoryRunIDs returns ids of runs, newest first
+func loadHistoryRunIDs() []string {
+	files, err := ioutil.ReadDir(longPath(flgHistory))
+	if err != nil {
+		return nil
+	}
+	var res []string
+	for _, fi := range files {
+		name := fi.Name()
+		// labels.json is not a run, see labels.go
+		if fi.IsDir() || !strings.HasSuffix(name, ".json") || name == triageLabelsFileName {
+			continue
+		}
+		res = append(res, strings.TrimSuffix(name, ".json"))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(res)))
+	return res
+}
+
+func loadHistoryRun(id string) (*RunResults, error) {
+	// id comes from url so don't allow escaping history dir
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, fmt.Errorf("invalid run id '%s'", id)
+	}
+	return loadRunResults(filepath.Join(flgHistory, id+".json"))
+}
+
+// loadHistory returns runs, newest first
+func loadHistory() []*RunResults {
+	var res []*RunResults
+	for _, id := range loadHistoryRunIDs() {
+		run, err := loadHistoryRun(id)
+		if err != nil {
+			fmt.Printf("skipping invalid run '%s': %s\n", id, err)
+			continue
+		}
+		res = append(res, run)
+	}
+	return res
+}
+
+func countFailed(run *RunResults) int {
+	n := 0
+	for _, r := range run.Tests {
+		if r.Failed {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+/*
+Hooks are shell commands run at the start and end of the run and of
+each test (-hook-run-start, -hook-test-start, -hook-test-end,
+-hook-run-end). They get context in env variables:
+
+REGRESS_HOOK         : run-start, test-start, test-end or run-end
+REGRESS_RUN_ID       : id of the run
+REGRESS_TEST_NAME    : name of the test (test hooks only)
+REGRESS_TEST_KEY     : key of the test (test hooks only)
+REGRESS_TEST_FILE    : path of the test file (test hooks only)
+REGRESS_TEST_CMD     : command of the test (test hooks only)
+REGRESS_TEST_RESULT  : pass or fail (test-end only)
+REGRESS_TEST_REASON  : why the test failed (test-end only)
+REGRESS_TESTS        : number of tests (run-end only)
+REGRESS_FAILED       : number of failed tests (run-end only)
+REGRESS_RESULTS      : path of results.json (run-end only)
+
+Failure of run-start hook stops the run, failure of test-start hook
+is an infrastructure error of the test.
+*/
+
+func runHook(name string, cmdStr string, env []string) error {
+	if cmdStr == "" {
+		return nil
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd.exe", "/c", cmdStr)
+	} else {
+		cmd = exec.Command("sh", "-c", cmdStr)
+	}
+	cmd.Env = append(os.Environ(), "REGRESS_HOOK="+name, "REGRESS_RUN_ID="+runID())
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		fmt.Printf("hook %s '%s' failed: %s\n", name, cmdStr, err)
+		return fmt.Errorf("hook %s failed: %w", name, err)
+	}
+	return nil
+}
+
+func testHookEnv(t *Test) []string {
+	return []string{
+		"REGRESS_TEST_NAME=" + testDisplayName(t),
+		"REGRESS_TEST_KEY=" + testKey(t),
+		"REGRESS_TEST_ID=" + testID(t),
+		"REGRESS_TEST_FILE=" + t.FilePath,
+		"REGRESS_TEST_CMD=" + t.CmdUnparsed,
+	}
+}
+
+func runHookRunStartMust() {
+	err := runHook("run-start", flgHookRunStart, nil)
+	fatalIfErr(err)
+}
+
+func runHookTestStart(t *Test) {
+	err := runHook("test-start", flgHookTestStart, testHookEnv(t))
+	if err != nil {
+		t.InfraError = err
+	}
+}
+
+func runHookTestEnd(t *Test) {
+	result := "pass"
+	if isFailedTest(t) {
+		result = "fail"
+	}
+	env := testHookEnv(t)
+	env = append(env, "REGRESS_TEST_RESULT="+result, "REGRESS_TEST_REASON="+failureReason(t))
+	runHook("test-end", flgHookTestEnd, env)
+}
+
+func runHookRunEnd(nTests int, nFailed int) {
+	env := []string{
+		"REGRESS_TESTS=" + strconv.Itoa(nTests),
+		"REGRESS_FAILED=" + strconv.Itoa(nFailed),
+		"REGRESS_RESULTS=" + flgResults,
+	}
+	runHook("run-end", flgHookRunEnd, env)
+}
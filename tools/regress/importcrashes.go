@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+regress import-crashes fetches recent crash reports from crash collection
+server (-url, defaults to $REGRESS_CRASHES_URL) which returns JSON:
+
+[{"ID": "...", "Time": "2022-06-01T10:00:00Z", "Documents": ["https://..."], "Text": "..."}]
+
+It downloads documents attached to reports (Documents) or linked from them
+(urls in Text) into the cache, tries to reproduce the crash with default
+command for document's format (see formatcmds.go) and each of
+crashReproCmds and appends entries for confirmed crashes to -out so that
+they can be reviewed and moved to tests.txt.
+*/
+
+// CrashReport is a crash report from crash collection server
+type CrashReport struct {
+	ID        string
+	Time      time.Time
+	Documents []string `json:",omitempty"`
+	Text      string
+}
+
+var (
+	crashReproCmds = []string{
+		"-render 1 $file",
+		"-extract-text 1 $file",
+	}
+	rxDocumentURL = regexp.MustCompile(`https?://[^\s"'<>]+\.(?i:pdf|xps|oxps|djvu|epub|mobi|fb2|cbz|cbr|cb7|chm|tif|tiff)\b`)
+)
+
+func fetchCrashReports(uri string, since time.Time) ([]*CrashReport, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("since", since.UTC().Format(time.RFC3339))
+	u.RawQuery = q.Encode()
+	rsp, err := http.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("'%s' returned %s", u, rsp.Status)
+	}
+	var res []*CrashReport
+	err = json.NewDecoder(rsp.Body).Decode(&res)
+	return res, err
+}
+
+func crashReportDocuments(r *CrashReport) []string {
+	var res []string
+	seen := map[string]bool{}
+	for _, uri := range append(r.Documents, rxDocumentURL.FindAllString(r.Text, -1)...) {
+		if !seen[uri] {
+			seen[uri] = true
+			res = append(res, uri)
+		}
+	}
+	return res
+}
+
+// dlCrashDocument downloads a document to the cache, unlike dlIfNotExists
+// we don't know its sha1 up front and failures are not fatal
+func dlCrashDocument(uri string) (*TestFile, error) {
+	rsp, err := http.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	d, err := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("'%s' returned %s", uri, rsp.Status)
+	}
+	atomic.AddInt64(&metricBytesDownloaded, int64(len(d)))
+	sha1Hex := sha1HexOfBytes(d)
+	if tf := testFilesBySha1[sha1Hex]; tf != nil {
+		return tf, nil
+	}
+	fmt.Printf("downloaded '%s'...", uri)
+	tf, err := saveTestFile(d, uri, "")
+	if err == nil {
+		testFilesBySha1[sha1Hex] = tf
+	}
+	return tf, err
+}
+
+func crashTestEntry(r *CrashReport, t *Test) string {
+	s := fmt.Sprintf("# crash report %s from %s\n", r.ID, r.Time.Format(dateFormat))
+	s += "Url: " + t.FileURL + "\n"
+	s += "Sha1: " + t.FileSha1Hex + "\n"
+	s += "Cmd: " + t.CmdUnparsed + "\n"
+	// output printed before the crash is usually what we expect once it's fixed
+	out := strings.Replace(t.Output, t.FilePath, "$file", -1)
+	if out == "" || strings.Contains(out, "\n") {
+		return s + "Assert: exitCode == 0\n\n"
+	}
+	return s + "Out: " + out + "\n\n"
+}
+
+// reproCrash returns a test that crashed or nil if none of crashReproCmds crashed
+func reproCrash(tf *TestFile, uri string, cmdPath string) *Test {
+	var cmds []string
+	if cmd := formatCmd(formatOfName(origNameFromURL(uri))); cmd != "" {
+		cmds = append(cmds, cmd)
+	}
+	for _, args := range crashReproCmds {
+		if cmd := "SumatraPDF.exe " + args; !hasArg(cmds, cmd) {
+			cmds = append(cmds, cmd)
+		}
+	}
+	for _, cmd := range cmds {
+		t := &Test{
+			Name:        "import-crashes " + cmd,
+			FileSha1Hex: tf.Sha1Hex,
+			FileURL:     uri,
+			Path:        "import-crashes",
+			FilePath:    longPath(tf.Path),
+		}
+		setCmd(t, cmd)
+		// default command can use another executable from the same dir
+		t.CmdPath = filepath.Join(filepath.Dir(cmdPath), t.CmdName)
+		if !fileExists(t.CmdPath) {
+			continue
+		}
+		resolveOrigName(t, tf)
+		runTest(t)
+		if isCrashError(t.Error) {
+			return t
+		}
+	}
+	return nil
+}
+
+func importCrashes(args []string) {
+	fs := flag.NewFlagSet("import-crashes", flag.ExitOnError)
+	uri := fs.String("url", os.Getenv("REGRESS_CRASHES_URL"), "url of crash collection server")
+	since := fs.Duration("since", 7*24*time.Hour, "import crash reports newer than this")
+	out := fs.String("out", filepath.Join("out", "regress", "crash-candidates.txt"), "file to append test entries for reproduced crashes to")
+	fs.Parse(args)
+	panicIf(*uri == "", "import-crashes: need -url or REGRESS_CRASHES_URL\n")
+
+	reports, err := fetchCrashReports(*uri, time.Now().Add(-*since))
+	fatalIfErr(err)
+	fmt.Printf("%d crash reports from '%s'\n", len(reports), *uri)
+	verifyTestFiles()
+	probe := &Test{CmdName: "SumatraPDF.exe"}
+	verifyCommandsMust([]*Test{probe})
+	fatalIfErr(probe.InfraError)
+	existing := map[string]bool{}
+	for _, t := range parseTestsMust(flgTests) {
+		existing[testKey(t)] = true
+	}
+	nRepro := 0
+	for _, r := range reports {
+		for _, docURL := range crashReportDocuments(r) {
+			tf, err := dlCrashDocument(docURL)
+			if err != nil {
+				fmt.Printf("crash report %s: failed to download '%s': %s\n", r.ID, docURL, err)
+				continue
+			}
+			t := reproCrash(tf, docURL, probe.CmdPath)
+			if t == nil {
+				fmt.Printf("crash report %s: didn't reproduce crash with '%s'\n", r.ID, docURL)
+				continue
+			}
+			if existing[testKey(t)] {
+				fmt.Printf("crash report %s: '%s' is already tested by %s\n", r.ID, docURL, flgTests)
+				continue
+			}
+			existing[testKey(t)] = true
+			err = os.MkdirAll(longPath(filepath.Dir(*out)), 0755)
+			if err == nil {
+				err = appendToFile(*out, crashTestEntry(r, t))
+			}
+			fatalIfErr(err)
+			nRepro++
+			fmt.Printf("crash report %s: reproduced with '%s'\n", r.ID, t.CmdUnparsed)
+		}
+	}
+	removeScratchDir()
+	fmt.Printf("reproduced %d crashes, test entries are in '%s'\n", nRepro, *out)
+}
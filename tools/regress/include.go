@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Tests can be split into multiple files with Include: in its own block:
+
+Include: pdf-tests.txt
+
+Tests from the included file are added at the place of Include:. The path
+is relative to the directory of the file with Include: and can be any
+format of tests file (.txt, .json or .yaml). Fixtures (see fixtures.go)
+defined in any file can be used in all files.
+*/
+
+// testsParser collects tests and fixtures from a tests file and files it includes
+type testsParser struct {
+	tests    []*Test
+	fixtures map[string]*Fixture
+	// files being parsed, to detect include cycles
+	stack []string
+}
+
+// parseIncludeLine returns path from Include: path
+func parseIncludeLine(text string) (string, bool) {
+	parts := strings.SplitN(text, ":", 2)
+	if len(parts) != 2 || !strings.EqualFold(strings.TrimSpace(parts[0]), "include") {
+		return "", false
+	}
+	return strings.TrimSpace(parts[1]), true
+}
+
+func isTestFieldLine(s string) bool {
+	return s != "" && !strings.HasPrefix(s, "#")
+}
+
+func (p *testsParser) parseLinesMust(path string, lines []TestLine) {
+	var test *Test
+	for {
+		test, lines = parseTest(path, lines)
+		if test == nil {
+			break
+		}
+		if fx := test.fixture; fx != nil {
+			if prev := p.fixtures[fx.Name]; prev != nil {
+				panicIf(true, "%s: duplicate fixture '%s', already defined at %s\n", fx.Pos, fx.Name, prev.Pos)
+			}
+			p.fixtures[fx.Name] = fx
+			continue
+		}
+		p.tests = append(p.tests, test)
+	}
+}
+
+func (p *testsParser) parseFileMust(path string) {
+	for _, s := range p.stack {
+		panicIf(filepath.Clean(s) == filepath.Clean(path), "%s: include cycle: %s\n", path, strings.Join(append(p.stack, path), " => "))
+	}
+	p.stack = append(p.stack, path)
+	lines := readTestLinesMust(path)
+	var block []TestLine
+	sawField := false
+	for i, tl := range lines {
+		if ver, ok := parseVersionLine(tl.Text); ok {
+			pos := fmt.Sprintf("%s:%d", path, tl.LineNo)
+			nextInBlock := i+1 < len(lines) && isTestFieldLine(lines[i+1].Text)
+			panicIf(sawField || nextInBlock, "%s: Version: must be in its own block before tests\n", pos)
+			verifyTestsFormatVersionMust(pos, ver)
+			sawField = true
+			continue
+		}
+		incPath, ok := parseIncludeLine(tl.Text)
+		if !ok {
+			sawField = sawField || isTestFieldLine(tl.Text)
+			block = append(block, tl)
+			continue
+		}
+		sawField = true
+		pos := fmt.Sprintf("%s:%d", path, tl.LineNo)
+		panicIf(incPath == "", "%s: Include: needs a path\n", pos)
+		prevInBlock := len(block) > 0 && isTestFieldLine(block[len(block)-1].Text)
+		nextInBlock := i+1 < len(lines) && isTestFieldLine(lines[i+1].Text)
+		panicIf(prevInBlock || nextInBlock, "%s: Include: must be separated from tests by empty lines\n", pos)
+		p.parseLinesMust(path, block)
+		block = nil
+		if !filepath.IsAbs(incPath) {
+			incPath = filepath.Join(filepath.Dir(path), incPath)
+		}
+		p.parseFileMust(incPath)
+	}
+	p.parseLinesMust(path, block)
+	p.stack = p.stack[:len(p.stack)-1]
+}
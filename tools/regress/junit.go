@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// -junit out.xml saves results in JUnit format understood by most CI systems
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitProperties struct {
+	Properties []junitProperty `xml:"property"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type junitTestCase struct {
+	Name       string           `xml:"name,attr"`
+	ClassName  string           `xml:"classname,attr"`
+	Time       string           `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Failure    *junitFailure    `xml:"failure,omitempty"`
+	Skipped    *junitSkipped    `xml:"skipped,omitempty"`
+	SystemOut  string           `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name         `xml:"testsuite"`
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+}
+
+func testToJUnit(t *Test) *junitTestCase {
+	tc := &junitTestCase{
+		Name:      testDisplayName(t),
+		ClassName: filepath.Base(t.Path),
+		Time:      fmt.Sprintf("%.3f", t.Duration.Seconds()),
+	}
+	var props []junitProperty
+	if t.Bug != "" {
+		props = append(props, junitProperty{Name: "bug", Value: t.Bug})
+	}
+	for _, s := range t.Notes {
+		props = append(props, junitProperty{Name: "note", Value: s})
+	}
+	if len(props) > 0 {
+		tc.Properties = &junitProperties{Properties: props}
+	}
+	if !t.Done {
+		tc.Skipped = &junitSkipped{Message: t.SkipReason}
+		return tc
+	}
+	if !isUnexpectedFailure(t) {
+		return tc
+	}
+	msg := failureReason(t)
+	text := fmt.Sprintf("test: %s\ncmd: %s\n", testPos(t), t.CmdUnparsed)
+	if t.Bug != "" {
+		text += fmt.Sprintf("bug: %s\n", t.Bug)
+	}
+	for _, s := range t.Notes {
+		text += fmt.Sprintf("note: %s\n", s)
+	}
+	tc.Failure = &junitFailure{Message: msg, Type: failureCategory(t), Text: text}
+	tc.SystemOut = t.Output
+	return tc
+}
+
+func writeResultsJUnit(path string, tests []*Test) error {
+	suite := &junitTestSuite{Name: "regress"}
+	for _, t := range tests {
+		tc := testToJUnit(t)
+		suite.Tests++
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Skipped != nil {
+			suite.Skipped++
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	d, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(longPath(filepath.Dir(path)), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(longPath(path), append([]byte(xml.Header), d...), 0644)
+}
+
+func saveResultsJUnit(tests []*Test) {
+	if flgJUnit == "" {
+		return
+	}
+	err := writeResultsJUnit(flgJUnit, tests)
+	if err != nil {
+		fmt.Printf("failed to save JUnit results to '%s': %s\n", flgJUnit, err)
+		return
+	}
+	fmt.Printf("saved JUnit results to '%s'\n", flgJUnit)
+}
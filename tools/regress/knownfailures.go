@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+Known failures are tests we expect to fail until a bug is fixed.
+Each entry has an expiry date after which it becomes a suite error,
+so that the list gets re-triaged instead of growing forever.
+
+# expires bug name
+2024-06-01 https://github.com/sumatrapdfreader/sumatrapdf/issues/123 epub with broken toc
+
+Instead of name we can use test id (shown for failed tests and in results)
+which, unlike name, doesn't change when the test is renamed.
+
+With -matrix an entry for a test applies to all its variants and an entry
+for a variant (e.g. name [gpu=sw]) only to that variant.
+*/
+
+// KnownFailure is an entry in known failures file
+type KnownFailure struct {
+	Name    string
+	Bug     string
+	Expires time.Time
+	Pos     string
+}
+
+const dateFormat = "2006-01-02"
+
+func parseKnownFailuresMust(path string) []*KnownFailure {
+	var res []*KnownFailure
+	d, err := ioutil.ReadFile(longPath(path))
+	fatalIfErr(err)
+	for i, l := range toTrimmedLines(d) {
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		pos := fmt.Sprintf("%s:%d", path, i+1)
+		parts := strings.SplitN(l, " ", 3)
+		panicIf(len(parts) != 3, "%s: invalid line '%s', expected: expires bug name\n", pos, l)
+		expires, err := time.Parse(dateFormat, parts[0])
+		panicIf(err != nil, "%s: invalid date '%s', expected YYYY-MM-DD\n", pos, parts[0])
+		kf := &KnownFailure{
+			Name:    strings.TrimSpace(parts[2]),
+			Bug:     parts[1],
+			Expires: expires,
+			Pos:     pos,
+		}
+		res = append(res, kf)
+	}
+	return res
+}
+
+// loadKnownFailures is given tests before filters and expandMatrix so
+// that entries for tests that are filtered out still match a test
+func loadKnownFailures(tests []*Test) []*KnownFailure {
+	path := flgKnownFailures
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(longPath(path)); os.IsNotExist(err) {
+		return nil
+	}
+	known := parseKnownFailuresMust(path)
+	var dims []*matrixDim
+	if flgMatrix != "" {
+		dims = parseMatrixDimsMust(flgMatrix)
+	}
+	names := map[string]bool{}
+	for _, t := range tests {
+		names[testDisplayName(t)] = true
+		names[testID(t)] = true
+		for _, v := range expandTest(t, dims) {
+			names[testDisplayName(v)] = true
+			names[testID(v)] = true
+		}
+	}
+	now := time.Now()
+	for _, kf := range known {
+		if now.After(kf.Expires) {
+			addSuiteError("%s: known failure '%s' (%s) expired on %s, re-triage it", kf.Pos, kf.Name, kf.Bug, kf.Expires.Format(dateFormat))
+		}
+		if !names[kf.Name] {
+			addSuiteError("%s: known failure '%s' doesn't match any test", kf.Pos, kf.Name)
+		}
+	}
+	fmt.Printf("%d known failures\n", len(known))
+	return known
+}
+
+// applyKnownFailures is given tests after expandMatrix. An entry for
+// a variant wins over an entry for the test it was expanded from.
+func applyKnownFailures(tests []*Test, known []*KnownFailure) {
+	byName := map[string]*KnownFailure{}
+	for _, kf := range known {
+		byName[kf.Name] = kf
+	}
+	for _, t := range tests {
+		t.KnownFailure = findKnownFailure(byName, t)
+		if t.KnownFailure == nil && t.base != nil {
+			t.KnownFailure = findKnownFailure(byName, t.base)
+		}
+	}
+}
+
+func findKnownFailure(byName map[string]*KnownFailure, t *Test) *KnownFailure {
+	if kf := byName[testDisplayName(t)]; kf != nil {
+		return kf
+	}
+	return byName[testID(t)]
+}
+
+// isUnexpectedFailure is true for failed tests not in known failures
+func isUnexpectedFailure(t *Test) bool {
+	return isFailedTest(t) && t.KnownFailure == nil
+}
+
+func dumpKnownFailures(tests []*Test) {
+	for _, t := range tests {
+		kf := t.KnownFailure
+		if kf == nil || !t.Done {
+			continue
+		}
+		if isFailedTest(t) {
+			fmt.Printf("Known failure: %s (%s)\n", kf.Name, kf.Bug)
+		} else {
+			fmt.Printf("Known failure now passes, remove it from known failures: %s (%s)\n", kf.Name, kf.Bug)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKnownFailures(t *testing.T) {
+	bug := "https://github.com/sumatrapdfreader/sumatrapdf/issues/123"
+	tests := []struct {
+		line    string
+		name    string
+		expires string
+	}{
+		{"2024-06-01 " + bug + " epub with broken toc", "epub with broken toc", "2024-06-01"},
+		{"2024-06-01 " + bug + " 3f2a9c1e ", "3f2a9c1e", "2024-06-01"},
+		{"2030-12-31 " + bug + " name [gpu=sw]", "name [gpu=sw]", "2030-12-31"},
+	}
+	for _, tc := range tests {
+		path := filepath.Join(t.TempDir(), "known-failures.txt")
+		d := "# expires bug name\n\n" + tc.line + "\n"
+		err := ioutil.WriteFile(path, []byte(d), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := parseKnownFailuresMust(path)
+		if len(res) != 1 {
+			t.Errorf("'%s': got %d known failures, expected 1", tc.line, len(res))
+			continue
+		}
+		kf := res[0]
+		expires, _ := time.Parse(dateFormat, tc.expires)
+		if kf.Name != tc.name || kf.Bug != bug || !kf.Expires.Equal(expires) {
+			t.Errorf("'%s': got '%s' %s %s, expected '%s' %s %s", tc.line, kf.Name, kf.Bug, kf.Expires.Format(dateFormat), tc.name, bug, tc.expires)
+		}
+		if kf.Pos != path+":3" {
+			t.Errorf("'%s': got pos '%s', expected '%s:3'", tc.line, kf.Pos, path)
+		}
+	}
+}
+
+func TestApplyKnownFailures(t *testing.T) {
+	defer func(matrix, swArgs, knownFailures string, errs []string) {
+		flgMatrix, flgSwRenderArgs, flgKnownFailures, suiteErrors = matrix, swArgs, knownFailures, errs
+	}(flgMatrix, flgSwRenderArgs, flgKnownFailures, suiteErrors)
+	flgMatrix = "gpu"
+	flgSwRenderArgs = "-disable-gpu"
+	flgKnownFailures = filepath.Join(t.TempDir(), "known-failures.txt")
+	suiteErrors = nil
+	d := `2099-01-01 bug1 render [gpu=sw]
+2099-01-01 bug2 both
+2099-01-01 bug3 both [gpu=hw]
+2099-01-01 bug4 filtered out [gpu=sw]
+2099-01-01 bug5 no such test
+`
+	err := ioutil.WriteFile(flgKnownFailures, []byte(d), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests []*Test
+	for _, name := range []string{"render", "both", "filtered out"} {
+		test := &Test{Name: name}
+		setCmd(test, "SumatraPDF.exe -render 1 $file")
+		tests = append(tests, test)
+	}
+	known := loadKnownFailures(tests)
+	tests = expandMatrix(tests[:2])
+	applyKnownFailures(tests, known)
+	exp := map[string]string{
+		"render [gpu=hw]": "",
+		"render [gpu=sw]": "bug1",
+		"both [gpu=hw]":   "bug3",
+		"both [gpu=sw]":   "bug2",
+	}
+	for _, test := range tests {
+		bug := ""
+		if test.KnownFailure != nil {
+			bug = test.KnownFailure.Bug
+		}
+		if bug != exp[test.Name] {
+			t.Errorf("%s: got known failure '%s', expected '%s'", test.Name, bug, exp[test.Name])
+		}
+	}
+	if len(suiteErrors) != 1 || !strings.Contains(suiteErrors[0], "'no such test' doesn't match any test") {
+		t.Errorf("got suite errors %q, expected only one for 'no such test'", suiteErrors)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+regress label <test> -owner kjk -label fonts organizes a long list of
+failures: who looks at a failing test and what kind of problem it is.
+<test> is test's id, Name: or position (tools/regress/tests.txt:42).
+
+	-owner X           set owner, -owner "" removes it
+	-label a,b         add labels
+	-remove-label a,b  remove labels
+	-clear             remove owner and all labels
+
+Without options it shows current owner and labels.
+
+Labels are kept in labels.json in -history dir, next to the runs, so they
+are not lost between runs like results. They are set for a test and not
+for its variants (-matrix). They are shown with failures, saved in
+results.json and csv and shown by regress serve.
+*/
+
+const triageLabelsFileName = "labels.json"
+
+// TriageLabel is owner and labels of a test, set with regress label
+type TriageLabel struct {
+	Owner   string   `json:",omitempty"`
+	Labels  []string `json:",omitempty"`
+	Updated time.Time
+}
+
+var (
+	// by testID of the test without variant
+	triageLabels map[string]*TriageLabel
+)
+
+func triageLabelsPath() string {
+	return filepath.Join(flgHistory, triageLabelsFileName)
+}
+
+func loadTriageLabels() map[string]*TriageLabel {
+	if triageLabels != nil {
+		return triageLabels
+	}
+	triageLabels = map[string]*TriageLabel{}
+	if flgHistory == "" {
+		return triageLabels
+	}
+	d, err := ioutil.ReadFile(longPath(triageLabelsPath()))
+	if err != nil {
+		return triageLabels
+	}
+	err = json.Unmarshal(d, &triageLabels)
+	if err != nil {
+		fmt.Printf("ignoring invalid '%s': %s\n", triageLabelsPath(), err)
+		triageLabels = map[string]*TriageLabel{}
+	}
+	return triageLabels
+}
+
+func saveTriageLabels() error {
+	err := os.MkdirAll(longPath(flgHistory), 0755)
+	if err != nil {
+		return err
+	}
+	d, err := json.MarshalIndent(triageLabels, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write and rename so that a concurrent run doesn't read partial file
+	tmpPath := triageLabelsPath() + ".tmp"
+	err = ioutil.WriteFile(longPath(tmpPath), d, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(longPath(tmpPath), longPath(triageLabelsPath()))
+}
+
+// labelID is testID of the test without variant so that all variants
+// share labels
+func labelID(t *Test) string {
+	if t.Variant == "" {
+		return testID(t)
+	}
+	c := *t
+	c.Variant = ""
+	return testID(&c)
+}
+
+func testTriageLabel(t *Test) *TriageLabel {
+	return loadTriageLabels()[labelID(t)]
+}
+
+// resultTriageLabel returns current labels of a test from a past run,
+// falling back to labels it had at the time of the run
+func resultTriageLabel(r *TestResult) *TriageLabel {
+	key := r.Key
+	if r.Variant != "" {
+		key = strings.TrimSuffix(key, " ["+r.Variant+"]")
+	}
+	if tl := loadTriageLabels()[keyToID(key)]; tl != nil {
+		return tl
+	}
+	if r.Owner == "" && len(r.Labels) == 0 {
+		return nil
+	}
+	return &TriageLabel{Owner: r.Owner, Labels: r.Labels}
+}
+
+func (tl *TriageLabel) String() string {
+	if tl == nil {
+		return ""
+	}
+	var parts []string
+	if tl.Owner != "" {
+		parts = append(parts, "owner: "+tl.Owner)
+	}
+	if len(tl.Labels) > 0 {
+		parts = append(parts, "labels: "+strings.Join(tl.Labels, ", "))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func dumpTriageLabel(t *Test) {
+	if s := testTriageLabel(t).String(); s != "" {
+		fmt.Printf("Triage: %s\n", s)
+	}
+}
+
+func triageLabelOwner(t *Test) string {
+	if tl := testTriageLabel(t); tl != nil {
+		return tl.Owner
+	}
+	return ""
+}
+
+func triageLabelLabels(t *Test) []string {
+	if tl := testTriageLabel(t); tl != nil {
+		return tl.Labels
+	}
+	return nil
+}
+
+// findTestMust finds test by id, Name: or position
+func findTestMust(tests []*Test, s string) *Test {
+	for _, t := range tests {
+		if testID(t) == s || t.Name == s || testPos(t) == s {
+			return t
+		}
+	}
+	fatalf("no test with id, name or position '%s' in '%s'\n", s, flgTests)
+	return nil
+}
+
+func splitLabels(s string) []string {
+	var res []string
+	for _, l := range strings.Split(s, ",") {
+		l = strings.ToLower(strings.TrimSpace(l))
+		if l != "" {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+func applyLabelChanges(labels []string, add []string, remove []string) []string {
+	m := map[string]bool{}
+	for _, l := range labels {
+		m[l] = true
+	}
+	for _, l := range add {
+		m[l] = true
+	}
+	for _, l := range remove {
+		delete(m, l)
+	}
+	var res []string
+	for l := range m {
+		res = append(res, l)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func labelTest(args []string) {
+	fs := flag.NewFlagSet("label", flag.ExitOnError)
+	owner := fs.String("owner", "", "who looks at the failure, \"\" to remove")
+	add := fs.String("label", "", "comma-separated labels to add")
+	remove := fs.String("remove-label", "", "comma-separated labels to remove")
+	clearAll := fs.Bool("clear", false, "remove owner and all labels")
+	// test is the first argument so that flags can follow it
+	panicIf(len(args) == 0 || strings.HasPrefix(args[0], "-"), "usage: regress label <test id, name or position> [-owner X] [-label a,b] [-remove-label a,b] [-clear]\n")
+	fs.Parse(args[1:])
+	panicIf(flgHistory == "", "-history is needed to store labels\n")
+
+	t := findTestMust(parseTestsMust(flgTests), args[0])
+	id := labelID(t)
+	labels := loadTriageLabels()
+	setOwner := false
+	fs.Visit(func(f *flag.Flag) {
+		setOwner = setOwner || f.Name == "owner"
+	})
+	changed := setOwner || *add != "" || *remove != "" || *clearAll
+	if changed {
+		tl := labels[id]
+		if tl == nil || *clearAll {
+			tl = &TriageLabel{}
+		}
+		if setOwner {
+			tl.Owner = strings.TrimSpace(*owner)
+		}
+		tl.Labels = applyLabelChanges(tl.Labels, splitLabels(*add), splitLabels(*remove))
+		tl.Updated = time.Now()
+		if tl.Owner == "" && len(tl.Labels) == 0 {
+			delete(labels, id)
+		} else {
+			labels[id] = tl
+		}
+		err := saveTriageLabels()
+		fatalIfErr(err)
+	}
+	s := labels[id].String()
+	if s == "" {
+		s = "no owner and labels"
+	}
+	fmt.Printf("%s (%s, id %s): %s\n", testDisplayName(t), testPos(t), id, s)
+}
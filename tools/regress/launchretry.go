@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+/*
+Launching a test can fail for reasons that go away on their own e.g. the
+exe is still locked by the linker right after the build
+(ERROR_SHARING_VIOLATION) or by antivirus scanning it. We retry launching
+up to -launch-retries times with increasing delay. If it still fails, the
+test fails with infrastructure error because we never ran SumatraPDF.
+*/
+
+const launchRetryDelay = time.Second
+
+// launchError is an error starting a process, as opposed to the process failing
+type launchError struct {
+	err      error
+	attempts int
+}
+
+func (e *launchError) Error() string {
+	return fmt.Sprintf("failed to launch after %d attempts: %s", e.attempts, e.err)
+}
+
+func (e *launchError) Unwrap() error {
+	return e.err
+}
+
+func isLaunchError(err error) bool {
+	var le *launchError
+	return errors.As(err, &le)
+}
+
+// runCmdWithLaunchRetry runs a command created by newCmd, creating a new
+// one for each retry because exec.Cmd can't be started twice
+func runCmdWithLaunchRetry(t *Test, newCmd func() *exec.Cmd) (*exec.Cmd, []byte, error) {
+	delay := launchRetryDelay
+	for attempt := 1; ; attempt++ {
+		cmd := newCmd()
+		res, err := runCmdWithTimeout(t, cmd)
+		if err == nil || cmd.Process != nil {
+			return cmd, res, err
+		}
+		if attempt > flgLaunchRetries || !isTransientLaunchError(err) {
+			return cmd, res, &launchError{err: err, attempts: attempt}
+		}
+		fmt.Printf("failed to launch '%s': %s, retrying in %s\n", cmd.Path, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
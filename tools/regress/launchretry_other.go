@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isTransientLaunchError returns true if the exe is still being written
+func isTransientLaunchError(err error) bool {
+	return errors.Is(err, syscall.ETXTBSY)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	errorAccessDenied     = syscall.Errno(5)
+	errorSharingViolation = syscall.Errno(32)
+	errorLockViolation    = syscall.Errno(33)
+)
+
+// isTransientLaunchError returns true for errors caused by another process
+// (linker, antivirus) having the exe open
+func isTransientLaunchError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case errorAccessDenied, errorSharingViolation, errorLockViolation:
+		return true
+	}
+	return false
+}
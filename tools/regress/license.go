@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+)
+
+/*
+Not all test files can be redistributed. License: CC0 in a test (or
+License in ${sha1}.meta.json) says under what license the file is.
+
+-licenses CC0,MIT,public-domain lists licenses that allow redistribution.
+With it, we don't publish (-archive-s3) artifacts of tests whose file has
+a different or unknown license. Such files can still be used locally.
+*/
+
+// testLicense returns license of test file, "" if unknown
+func testLicense(t *Test) string {
+	if t.License != "" {
+		return t.License
+	}
+	if t.FileMeta != nil {
+		return t.FileMeta.License
+	}
+	return ""
+}
+
+func parseLicenses(s string) []string {
+	var res []string
+	for _, l := range strings.Split(s, ",") {
+		l = strings.TrimSpace(l)
+		if l != "" {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+// canPublishTestFile returns false if -licenses is given and the license
+// of test file is not one of them
+func canPublishTestFile(t *Test) bool {
+	if flgLicenses == "" {
+		return true
+	}
+	license := testLicense(t)
+	if license == "" {
+		return false
+	}
+	for _, l := range parseLicenses(flgLicenses) {
+		if strings.EqualFold(l, license) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// longPath is only needed on Windows
+func longPath(path string) string {
+	return path
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPath converts path to extended-length \\?\ form if it's too long
+// for Win32 APIs. \\?\ paths must be absolute and can't contain "..".
+// Go only does this automatically for absolute paths.
+func longPath(path string) string {
+	// MAX_PATH minus space for 8.3 file name, which is the limit for directories
+	const maxPath = 248
+	if strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil || len(absPath) < maxPath {
+		return path
+	}
+	if strings.HasPrefix(absPath, `\\`) {
+		return `\\?\UNC\` + absPath[2:]
+	}
+	return `\\?\` + absPath
+}
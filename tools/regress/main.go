@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/sha1"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,6 +12,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 /*
@@ -25,29 +28,117 @@ Test succeeds if the output is same as expected.
 // Test describes a single test
 type Test struct {
 	// values from parsing test file
+	Name           string // optional
 	CmdUnparsed    string
 	FileSha1Hex    string
 	FileURL        string
 	ExpectedOutput string
+	ExpectedStderr string   // from Err:
+	Oracles        []string // e.g. gs, pdfium, pdftotext
+	SaveAs         string   // file name to use for the test file
+	OrigName       string   // original name of the test file, $origname
+	License        string   // license of the test file, overrides .meta.json
+	Compare        string   // external comparator command, from Compare: exec <command>
+	Asserts        []*Assert
+	MaxRenderMs    float64        // 0 if not set
+	Budget         time.Duration  // expected max run time, 0 if not set
+	Timeout        time.Duration  // overrides -timeout, 0 if not set
+	Stabilize      bool           // re-run until output is the same twice in a row
+	CleanTemp      bool           // fail if the test leaves temp files
+	ExpectedPages  map[int]string // page number => expected output, from Out[N]:
+	Env            []string       // NAME=value added to environment of Cmd:
+	Settings       string         // path of settings file template
+	Restrict       []string       // lines of sumatrapdfrestrict.ini
+	Restricted     bool           // has Restrict: even if empty
+	// variant name => expected output, from Out@gpu=sw:
+	VariantOutputs map[string]*VariantOutput
+	Matrix         []string        // opt-in -matrix dimensions, from Matrix: dpi
+	Needs          []string        // names of fixtures the test needs
+	Fonts          []*ExpectedFont // fonts the binary must resolve
+	Display        bool            // opens a window so can't run headless
+	ProducesFiles  []*ProducedFile // files Cmd: must create
+	Bug            string          // url of the issue the test is for
+	Notes          []string        // why expected output is what it is etc.
+	Tags           []string        // lower-case, for -tags and -skip-tags
+	SkipReason     string          // from Skip:, the test is not run
+
+	// set if the block is a fixture and not a test
+	fixture *Fixture
+	// defined in Go code with Register and not in a tests file
+	registered bool
+	// test a -matrix variant was expanded from, nil if not a variant
+	base *Test
+
+	// where the test is defined
+	Path      string
+	LineNo    int
+	OutLineNo int
 
 	// computed values
+	Variant  string // e.g. gpu=sw when running with -matrix
+	CmdDir   string // directory with CmdName, found automatically if ""
 	CmdName  string // e.g. SumatraPDF.exe
 	CmdPath  string // e.g. rel64\SumatraPDF.exe
 	CmdArgs  []string
 	FilePath string
-	Error    error
-	Output   string
+	FileMeta *CacheMeta // from ${sha1}.meta.json, can be nil
+	TempDir  string     // per-test temp dir inside scratchDir
+	// files we keep for investigating failures e.g. images rendered by oracles
+	Artifacts []string
+	Error     error
+	Output    string
+	Stderr    string
+	ExitCode  int
+	Duration  time.Duration // how long the process ran
+	// peak memory use of the process, 0 if not known
+	PeakMemoryKB int64
+	// files left in TMP of the test or in real temp dir
+	TempLeftovers []string
+	// files Cmd: created in TempDir, relative to it
+	NewScratchFiles []string
+	// test file in the cache before Cmd: ran, FilePath can be a hard link
+	// to it, see checkTestFileUnchanged
+	testFileInfo  os.FileInfo
+	testCachePath string
+	// Cmd: modified test file in the cache, see checkTestFileUnchanged
+	testFileMismatches []string
+	// font name => path of non-embedded fonts the binary loaded
+	ResolvedFonts map[string]string
+	// why output doesn't match expected, one entry per failed check
+	OutputMismatches []string
+	Done             bool // ran or restored from checkpoint
+	// skipped because it passed before with the same binary and inputs
+	FromCache bool
+	// expected to fail, from known failures file
+	KnownFailure *KnownFailure
+
+	span *Span
+	// cached parsed Output, see testRenderLog
+	renderLog       *RenderLog
+	renderLogOutput string
+	// problem with test environment (e.g. disk full) and not with SumatraPDF
+	InfraError error
+
+	OracleResults  []*OracleResult
+	OracleMismatch string
+	OracleNotes    []string
 }
 
 // TestFile describes as test file
 type TestFile struct {
 	Path    string
 	Sha1Hex string
+	Meta    *CacheMeta // can be nil
 }
 
 var (
 	inFatal         bool
 	testFilesBySha1 map[string]*TestFile
+	// problems with the test suite itself, they fail the run
+	// but don't stop it
+	suiteErrors []string
+	// why we stopped running tests early e.g. -failfast
+	runStopReason string
 )
 
 func init() {
@@ -62,7 +153,11 @@ func errStr(err error) string {
 }
 
 func dumpTest(t *Test) {
-	fmt.Printf(`CmdUnparsed: '%s'
+	if t.Name != "" {
+		fmt.Printf("Name: %s\n", t.Name)
+	}
+	fmt.Printf(`ID: %s
+CmdUnparsed: '%s'
 FileSha1Hex: %s
 FileURL: '%s'
 ExpectedOutput: '%s'
@@ -72,8 +167,9 @@ CmdArgs: %v
 FilePath: '%s'
 Error: '%s'
 Output: '%s'
-
-`, t.CmdUnparsed, t.FileSha1Hex, t.FileURL, t.ExpectedOutput, t.CmdName, t.CmdPath, t.CmdArgs, t.FilePath, errStr(t.Error), t.Output)
+`, testID(t), t.CmdUnparsed, t.FileSha1Hex, t.FileURL, t.ExpectedOutput, t.CmdName, t.CmdPath, t.CmdArgs, t.FilePath, errStr(t.Error), t.Output)
+	dumpStderr(t)
+	fmt.Printf("\n")
 }
 
 func printStack() {
@@ -89,7 +185,7 @@ var (
 func getCacheDirMust() string {
 	if cacheDir == "" {
 		d := filepath.Join("..", "sumatra-test-files")
-		err := os.MkdirAll(d, 0755)
+		err := os.MkdirAll(longPath(d), 0755)
 		fatalIfErr(err)
 		cacheDir = d
 	}
@@ -99,10 +195,11 @@ func getCacheDirMust() string {
 func fatalf(format string, args ...interface{}) {
 	fmt.Printf(format, args...)
 	printStack()
+	restoreCrashDialogs()
 	os.Exit(1)
 }
 
-func panicIfcond bool, format string, args ...interface{}) {
+func panicIf(cond bool, format string, args ...interface{}) {
 	if cond {
 		if inFatal {
 			os.Exit(1)
@@ -110,10 +207,17 @@ func panicIfcond bool, format string, args ...interface{}) {
 		inFatal = true
 		fmt.Printf(format, args...)
 		printStack()
+		restoreCrashDialogs()
 		os.Exit(1)
 	}
 }
 
+func addSuiteError(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	fmt.Printf("suite error: %s\n", s)
+	suiteErrors = append(suiteErrors, s)
+}
+
 func fatalIfErr(err error) {
 	if err != nil {
 		fatalf("%s\n", err.Error())
@@ -129,28 +233,38 @@ func toTrimmedLines(d []byte) []string {
 	return res
 }
 
-func collapseMultipleEmptyLines(lines []string) []string {
-	var res []string
+// TestLine is a line of a test file, remembers its position for error messages
+type TestLine struct {
+	Text   string
+	LineNo int
+}
+
+func collapseMultipleEmptyLines(lines []TestLine) []TestLine {
+	var res []TestLine
 	prevWasEmpty := false
 	for _, l := range lines {
-		if l == "" && prevWasEmpty {
+		if l.Text == "" && prevWasEmpty {
 			continue
 		}
-		prevWasEmpty = l == ""
+		prevWasEmpty = l.Text == ""
 		res = append(res, l)
 	}
 	return res
 }
 
-func parseTest(lines []string) (*Test, []string) {
-	t := &Test{}
+func parseTest(path string, lines []TestLine) (*Test, []TestLine) {
+	t := &Test{
+		Path: path,
+	}
 	//fmt.Printf("parseTest: %d lines\n", len(lines))
 	if len(lines) == 0 {
 		return nil, nil
 	}
 	for len(lines) > 0 {
-		l := lines[0]
+		tl := lines[0]
 		lines = lines[1:]
+		l := tl.Text
+		pos := fmt.Sprintf("%s:%d", path, tl.LineNo)
 		// skip comments
 		if strings.HasPrefix(l, "#") {
 			continue
@@ -160,52 +274,213 @@ func parseTest(lines []string) (*Test, []string) {
 		if l == "" {
 			break
 		}
+		if t.LineNo == 0 {
+			t.LineNo = tl.LineNo
+		}
 
 		parts := strings.SplitN(l, ":", 2)
-		panicIflen(parts) != 2, "invalid line: '%s'", l)
+		panicIf(len(parts) != 2, "%s: invalid line: '%s'\n", pos, l)
 		name := strings.ToLower(parts[0])
 		val := strings.TrimSpace(parts[1])
+		if pageNo, ok := parsePageOutField(name); ok {
+			_, dup := t.ExpectedPages[pageNo]
+			panicIf(dup, "%s: duplicate Out[%d]:\n", pos, pageNo)
+			if t.ExpectedPages == nil {
+				t.ExpectedPages = map[int]string{}
+			}
+			t.ExpectedPages[pageNo] = val
+			continue
+		}
+		if variant, ok := parseVariantOutField(name); ok {
+			_, dup := t.VariantOutputs[variant]
+			panicIf(dup, "%s: duplicate Out@%s:\n", pos, variant)
+			if t.VariantOutputs == nil {
+				t.VariantOutputs = map[string]*VariantOutput{}
+			}
+			t.VariantOutputs[variant] = &VariantOutput{Output: val, LineNo: tl.LineNo}
+			continue
+		}
 		switch name {
+		case "fixture":
+			panicIf(val == "", "%s: Fixture: needs a name\n", pos)
+			t.fixture = &Fixture{Name: val, Pos: pos}
+		case "setup", "teardown":
+			panicIf(t.fixture == nil, "%s: %s: is only valid after Fixture:\n", pos, parts[0])
+			if name == "setup" {
+				t.fixture.Setup = val
+			} else {
+				t.fixture.Teardown = val
+			}
+		case "tags":
+			t.Tags = append(t.Tags, parseTags(pos, val)...)
+		case "bug":
+			t.Bug = parseBug(pos, val)
+		case "note":
+			t.Notes = append(t.Notes, val)
+		case "skip":
+			t.SkipReason = parseSkip(pos, val)
+		case "producesfile":
+			t.ProducesFiles = append(t.ProducesFiles, parseProducesFile(pos, val))
+		case "display":
+			t.Display = parseDisplay(pos, val)
+		case "needs":
+			t.Needs = append(t.Needs, parseNeeds(pos, val)...)
+		case "fonts":
+			if t.fixture != nil {
+				t.fixture.Fonts = val
+			} else {
+				t.Fonts = append(t.Fonts, parseExpectedFonts(pos, val)...)
+			}
+		case "name":
+			t.Name = val
 		case "url":
 			t.FileURL = val
 		case "sha1":
-			panicIflen(val) != 40, "len(val) != 40 (%d)", len(val))
+			panicIf(len(val) != 40, "%s: len(val) != 40 (%d)\n", pos, len(val))
 			t.FileSha1Hex = val
 		case "cmd":
 			t.CmdUnparsed = val
 		case "out":
 			t.ExpectedOutput = val
+			t.OutLineNo = tl.LineNo
+		case "err":
+			t.ExpectedStderr = val
+		case "oracle":
+			t.Oracles = parseOracleNames(val)
+		case "origname":
+			t.OrigName = val
+		case "assert":
+			t.Asserts = append(t.Asserts, parseAssert(pos, tl.LineNo, val))
+		case "outcontains":
+			t.Asserts = append(t.Asserts, parseOutContains(pos, tl.LineNo, val))
+		case "outlinecount":
+			t.Asserts = append(t.Asserts, parseOutLineCount(pos, tl.LineNo, val))
+		case "outnotcontains":
+			t.Asserts = append(t.Asserts, parseOutNotContains(pos, tl.LineNo, val))
+		case "exitcode":
+			t.Asserts = append(t.Asserts, parseExitCode(pos, tl.LineNo, val))
+		case "outregex":
+			t.Asserts = append(t.Asserts, parseOutRegex(pos, tl.LineNo, val))
+		case "outnotregex":
+			t.Asserts = append(t.Asserts, parseOutNotRegex(pos, tl.LineNo, val))
+		case "maxrenderms":
+			t.MaxRenderMs = parseMaxRenderMs(pos, val)
+		case "license":
+			t.License = val
+		case "matrix":
+			t.Matrix = parseMatrixField(pos, val)
+		case "stabilize":
+			t.Stabilize = parseStabilize(pos, val)
+		case "cleantemp":
+			t.CleanTemp = parseCleanTemp(pos, val)
+		case "budget":
+			t.Budget = parseBudget(pos, val)
+		case "timeout":
+			t.Timeout = parseTimeout(pos, val)
+		case "env":
+			t.Env = append(t.Env, parseEnvVar(pos, val))
+		case "settings":
+			t.Settings = parseSettingsPath(pos, path, val)
+		case "restrict":
+			t.Restrict = append(t.Restrict, parseRestrict(pos, val))
+			t.Restricted = true
+		case "compare":
+			t.Compare = parseCompare(pos, val)
+		case "saveas":
+			panicIf(val == "" || strings.ContainsAny(val, `/\`), "%s: SaveAs: must be a file name, got '%s'\n", pos, val)
+			t.SaveAs = val
+		default:
+			panicIf(!flgNoStrict, "%s: unknown field '%s' (use -no-strict to ignore unknown fields)\n", pos, parts[0])
+			fmt.Printf("%s: ignoring unknown field '%s'\n", pos, parts[0])
 		}
 	}
-	panicIft.FileURL == "", "Url: filed missing")
-	panicIft.FileSha1Hex == "", "Sha1: field missing")
-	panicIft.CmdUnparsed == "", "Cmd: field missing")
-	panicIft.ExpectedOutput == "", "Out: field missing")
-
-	parts := strings.Split(t.CmdUnparsed, " ")
-	t.CmdName = parts[0]
-	t.CmdArgs = parts[1:]
+	// block with only comments
+	if t.LineNo == 0 {
+		return parseTest(path, lines)
+	}
+	pos := fmt.Sprintf("%s:%d", path, t.LineNo)
+	if t.fixture != nil {
+		fx := t.fixture
+		panicIf(fx.Setup == "" && fx.Teardown == "" && fx.Fonts == "", "%s: fixture '%s' needs Setup:, Teardown: or Fonts:\n", pos, fx.Name)
+		return t, lines
+	}
+	verifyTestFieldsMust(t, pos)
 	return t, lines
 }
 
-func parseTestsMust(path string) []*Test {
-	var res []*Test
-	var test *Test
-	d, err := ioutil.ReadFile(path)
+// verifyTestFieldsMust checks required fields and sets Cmd: derived values,
+// it's shared by tests from files and registered tests (see registry.go)
+func verifyTestFieldsMust(t *Test, pos string) {
+	panicIf(t.FileURL == "", "%s: Url: field missing\n", pos)
+	panicIf(t.FileSha1Hex == "", "%s: Sha1: field missing\n", pos)
+	if t.CmdUnparsed == "" {
+		format := testFileFormat(t)
+		t.CmdUnparsed = formatCmd(format)
+		panicIf(t.CmdUnparsed == "", "%s: Cmd: field missing and %s\n", pos, formatCmdMissingMsg(format))
+	}
+	hasChecks := t.ExpectedOutput != "" || t.ExpectedStderr != "" || len(t.ExpectedPages) > 0 || len(t.VariantOutputs) > 0 || len(t.Asserts) > 0 || t.MaxRenderMs != 0
+	panicIf(!hasChecks, "%s: Out:, Out[N]:, Err:, Assert:, OutContains:, OutRegex:, OutLineCount: or MaxRenderMs: field missing\n", pos)
+
+	setCmd(t, t.CmdUnparsed)
+	msg := textOracleMisuse(t)
+	panicIf(msg != "", "%s: %s\n", pos, msg)
+}
+
+func readTestLinesMust(path string) []TestLine {
+	d, err := ioutil.ReadFile(longPath(path))
 	fatalIfErr(err)
-	lines := toTrimmedLines(d)
-	lines = collapseMultipleEmptyLines(lines)
-	for {
-		test, lines = parseTest(lines)
-		if test == nil {
-			break
-		}
-		res = append(res, test)
+	var lines []TestLine
+	if isJSONTestsFile(path) {
+		lines = jsonToTestLines(path, d)
+	} else if isYAMLTestsFile(path) {
+		lines = yamlToTestLines(path, d)
+	} else {
+		lines = toTestLinesWithHeredocs(path, d)
 	}
+	return collapseMultipleEmptyLines(lines)
+}
+
+func parseTestsMust(path string) []*Test {
+	p := &testsParser{fixtures: map[string]*Fixture{}}
+	p.parseFileMust(path)
+	if path == flgTests {
+		p.tests = append(p.tests, registeredTestsMust()...)
+	}
+	res := p.tests
 	fmt.Printf("%d tests\n", len(res))
+	verifyFixturesMust(res, p.fixtures)
+	suiteFixtures = p.fixtures
+	checkDuplicateTests(res)
 	return res
 }
 
+func testPos(t *Test) string {
+	return fmt.Sprintf("%s:%d", t.Path, t.LineNo)
+}
+
+// duplicate tests double the runtime and make it unclear which
+// test a failure refers to
+func checkDuplicateTests(tests []*Test) {
+	byCmd := map[string]*Test{}
+	byName := map[string]*Test{}
+	for _, t := range tests {
+		key := testKey(t)
+		if prev := byCmd[key]; prev != nil {
+			addSuiteError("%s: duplicate test, same Sha1: and Cmd: as test at %s", testPos(t), testPos(prev))
+		} else {
+			byCmd[key] = t
+		}
+		if t.Name == "" {
+			continue
+		}
+		if prev := byName[t.Name]; prev != nil {
+			addSuiteError("%s: duplicate test name '%s', already used by test at %s", testPos(t), t.Name, testPos(prev))
+		} else {
+			byName[t.Name] = t
+		}
+	}
+}
+
 func cmdToStrLong2(cmd *exec.Cmd) string {
 	arr := []string{`"` + cmd.Path + `"`}
 	arr = append(arr, cmd.Args...)
@@ -217,82 +492,225 @@ func cmdToStrLong(cmd *exec.Cmd) string {
 }
 
 func isOutputEqual(s1, s2 string) bool {
-	// TODO: normalize newlines
-	s1 = strings.TrimSpace(s1)
-	s2 = strings.TrimSpace(s2)
+	s1 = strings.TrimSpace(normalizeNewlines(s1))
+	s2 = strings.TrimSpace(normalizeNewlines(s2))
 	return s1 == s2
 }
 
 func runTest(t *Test) {
+	err := stageTestFile(t)
+	if err != nil {
+		unstageTestFile(t)
+		t.InfraError = err
+		fmt.Printf("Failed to stage test file: %s\n", err)
+		return
+	}
+	defer unstageTestFile(t)
 	for i, arg := range t.CmdArgs {
-		if arg == "$file" {
-			t.CmdArgs[i] = t.FilePath
+		t.CmdArgs[i] = substVars(arg, t)
+	}
+	args, err := settingsArgs(t)
+	if err != nil {
+		t.InfraError = err
+		return
+	}
+	args = append(args, t.CmdArgs...)
+	args, err = fontLogArgs(t, args)
+	if err != nil {
+		t.InfraError = err
+		return
+	}
+	cmdPath := t.CmdPath
+	if t.Restricted {
+		cmdPath, err = stageRestrictedExe(t)
+		if err != nil {
+			t.InfraError = err
+			return
 		}
 	}
-	cmd := exec.Command(t.CmdPath, t.CmdArgs...)
-	fmt.Printf("Running: %s\n", cmdToStrLong(cmd))
-	res, err := cmd.Output()
+	defer func() {
+		if shouldRerunWithDiagnostics(t) {
+			rerunWithDiagnostics(t, cmdPath, args)
+		}
+	}()
+	realTempBefore := listRealTemp()
+	scratchBefore := listScratchFiles(t)
+	cmd, res, err := runTestCmdStabilized(t, cmdPath, args)
+	if isLaunchError(err) {
+		t.InfraError = err
+		fmt.Printf("Failed test:\n")
+		dumpTest(t)
+		return
+	}
+	collectTempLeftovers(t, realTempBefore)
+	collectResolvedFonts(t)
+	collectNewScratchFiles(t, scratchBefore)
+	t.testFileMismatches = checkTestFileUnchanged(t)
 	t.Output = strings.TrimSpace(string(res))
+	if isCrashError(err) {
+		collectCrashDump(t, cmd.ProcessState.Pid())
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && !isCrashError(err) {
+		t.ExitCode = exitErr.ExitCode()
+		if checksExitCode(t) {
+			err = nil
+		}
+	}
 	if err != nil {
 		t.Error = err
 		fmt.Printf("Failed test:\n")
 		dumpTest(t)
 		return
 	}
-	if !isOutputEqual(t.Output, t.ExpectedOutput) {
+	span := startSpan(t.span, "regress.compare")
+	t.OutputMismatches = checkOutput(t)
+	span.SetAttr("regress.equal", len(t.OutputMismatches) == 0)
+	span.Finish()
+	if len(t.OutputMismatches) > 0 || t.InfraError != nil {
 		fmt.Printf("Failed test:\n")
 		dumpTest(t)
 		return
 	}
-	fmt.Printf("test passed, output: %s\n", res)
+	span = startSpan(t.span, "regress.oracles")
+	runOracles(t)
+	span.SetAttr("regress.oracle_mismatch", t.OracleMismatch)
+	span.Finish()
+	if t.OracleMismatch != "" || t.InfraError != nil {
+		fmt.Printf("Failed test:\n")
+		dumpTest(t)
+		return
+	}
+	fmt.Printf("test passed, output: %s\n", shortenOutput(t.Output))
+}
+
+// shortenOutput limits output we log for passing tests, some print a lot
+func shortenOutput(s string) string {
+	const maxLen = 4096
+	if len(s) <= maxLen {
+		return s
+	}
+	return fmt.Sprintf("%s\n... (%d more bytes)", s[:maxLen], len(s)-maxLen)
 }
 
 func isFailedTest(t *Test) bool {
+	if t.InfraError != nil {
+		return true
+	}
 	if t.Error != nil {
 		return true
 	}
-	return !isOutputEqual(t.Output, t.ExpectedOutput)
+	if len(t.OutputMismatches) > 0 {
+		return true
+	}
+	return t.OracleMismatch != ""
 }
 
 func dumpFailedTest(t *Test) {
 	args := strings.Join(t.CmdArgs, " ")
-	fmt.Printf("Test %s %s failed\n", t.CmdPath, args)
+	if t.Name != "" {
+		fmt.Printf("Test '%s' (%s) failed: %s %s\n", t.Name, testPos(t), t.CmdPath, args)
+	} else {
+		fmt.Printf("Test %s %s failed\n", t.CmdPath, args)
+	}
 	dumpTest(t)
+	if s := t.FileMeta.provenance(); s != "" {
+		fmt.Printf("Test file: %s\n", s)
+	}
+	dumpAnnotations(t)
+	dumpTriageLabel(t)
+	// unstageTestFile keeps temp dir of failed tests
+	if t.TempDir != "" {
+		fmt.Printf("Temp dir: '%s'\n", t.TempDir)
+	}
+	if t.InfraError != nil {
+		fmt.Printf("Reason: infrastructure error '%s'\n", t.InfraError)
+		return
+	}
 	if t.Error != nil {
 		fmt.Printf("Reason: process exited with error '%s'\n", t.Error)
 		return
 	}
-	if !isOutputEqual(t.Output, t.ExpectedOutput) {
-		fmt.Printf(`
-Reason: got output:
------
-%s
------
-expected:
------
-%s
------
-`, t.Output, t.ExpectedOutput)
+	if len(t.OutputMismatches) > 0 {
+		dumpOutputMismatches(t)
+		return
+	}
+	if t.OracleMismatch != "" {
+		fmt.Printf("Reason: %s\n", t.OracleMismatch)
+		dumpOracleResults(t)
 		return
 	}
 	fmt.Printf("Internal error: unknown reason\n")
 }
 
+// failureReason is a short, one line description of why the test failed
+func failureReason(t *Test) string {
+	if t.InfraError != nil {
+		return fmt.Sprintf("infrastructure error '%s'", t.InfraError)
+	}
+	if t.Error != nil {
+		return fmt.Sprintf("process exited with error '%s'", t.Error)
+	}
+	if n := len(t.OutputMismatches); n > 0 {
+		s := strings.SplitN(t.OutputMismatches[0], "\n", 2)[0]
+		if n > 1 {
+			s += fmt.Sprintf(" (and %d more)", n-1)
+		}
+		return s
+	}
+	if t.OracleMismatch != "" {
+		return t.OracleMismatch
+	}
+	return ""
+}
+
 func dumpFailedTests(tests []*Test) int {
 	nFailed := 0
+	nInfraErrors := 0
 	for _, test := range tests {
-		if !isFailedTest(test) {
+		if !isUnexpectedFailure(test) {
 			continue
 		}
 		nFailed++
+		if test.InfraError != nil {
+			nInfraErrors++
+		}
+		// summarized by dumpMissingBinaries and dumpNoDisplay
+		if missingBinary(test) != "" || isNoDisplayError(test) {
+			continue
+		}
 		dumpFailedTest(test)
 	}
+	dumpFailuresByCategory(tests)
+	dumpFailureClusters(tests)
+	dumpKnownFailures(tests)
+	dumpOverBudget(tests)
+	dumpVariantsSummary(tests)
+	dumpBuildParityDiffs(tests)
+	dumpMissingBinaries(tests)
+	dumpNoDisplay(tests)
+	dumpSkippedTests()
+	dumpRunResources(tests)
+	nNotRun := 0
+	for _, test := range tests {
+		if !test.Done {
+			nNotRun++
+		}
+	}
+	if nNotRun > 0 {
+		fmt.Printf("%d tests %s\n", nNotRun, runStopReason)
+	}
+	for _, s := range suiteErrors {
+		fmt.Printf("Suite error: %s\n", s)
+	}
 	if nFailed == 0 {
 		fmt.Printf("All tests passed!\n")
 	} else {
 		fmt.Printf("Failed %d out of %d tests\n", nFailed, len(tests))
 	}
-	return nFailed
+	if nInfraErrors > 0 {
+		fmt.Printf("%d failures are infrastructure errors\n", nInfraErrors)
+	}
+	return nFailed + len(suiteErrors)
 }
 
 func sha1OfBytes(data []byte) []byte {
@@ -305,7 +723,7 @@ func sha1HexOfBytes(data []byte) string {
 }
 
 func sha1OfFile(path string) ([]byte, error) {
-	f, err := os.Open(path)
+	f, err := os.Open(longPath(path))
 	fatalIfErr(err)
 	defer f.Close()
 	h := sha1.New()
@@ -328,6 +746,7 @@ func httpDlMust(uri string) []byte {
 	d, err := ioutil.ReadAll(res.Body)
 	res.Body.Close()
 	fatalIfErr(err)
+	atomic.AddInt64(&metricBytesDownloaded, int64(len(d)))
 	return d
 }
 
@@ -335,36 +754,201 @@ func testFileExists(sha1Hex string) bool {
 	return nil != testFilesBySha1[sha1Hex]
 }
 
-func dlIfNotExistsMust(uri, sha1Hex string) {
+// dlIfNotExists only fails if it can't save the file e.g. because disk is full
+func dlIfNotExists(uri, sha1Hex, origName string) (err error) {
 	if testFileExists(sha1Hex) {
-		return
+		return nil
 	}
+	var tf *TestFile
+	span := startSpan(runSpan, "regress.download")
+	span.SetAttr("url", uri)
+	span.SetAttr("regress.sha1", sha1Hex)
+	defer func() {
+		if tf != nil {
+			testFilesBySha1[sha1Hex] = tf
+		}
+		span.SetAttr("error", errStr(err))
+		span.Finish()
+	}()
 	fmt.Printf("downloading '%s'...", uri)
-	d := httpDlMust(uri)
+	d := dlTestFileMust(uri)
 	realSha1Hex := sha1HexOfBytes(d)
-	panicIfsha1Hex != realSha1Hex, "sha1Hex != realSha1Hex (%s != %s)", sha1Hex, realSha1Hex)
+	panicIf(sha1Hex != realSha1Hex, "sha1Hex != realSha1Hex (%s != %s)", sha1Hex, realSha1Hex)
+	tf, err = saveTestFile(d, uri, origName)
+	return err
+}
+
+// saveTestFile saves downloaded test file and its meta data in the cache
+func saveTestFile(d []byte, uri string, origName string) (*TestFile, error) {
+	sha1Hex := sha1HexOfBytes(d)
 	ext := filepath.Ext(uri)
 	fileName := sha1Hex + ext
 	path := filepath.Join(getCacheDirMust(), fileName)
-	err := ioutil.WriteFile(path, d, 0644)
-	fatalIfErr(err)
+	err := ioutil.WriteFile(longPath(path), d, 0644)
+	if err != nil {
+		fmt.Printf(" failed to save to '%s': %s\n", path, err)
+		os.Remove(longPath(path))
+		return nil, err
+	}
 	fmt.Printf(" saved to '%s'\n", path)
-	testFilesBySha1[sha1Hex] = &TestFile{
+	meta := &CacheMeta{
+		OrigName: origName,
+		URL:      uri,
+		Format:   strings.TrimPrefix(strings.ToLower(ext), "."),
+	}
+	if meta.OrigName == "" {
+		meta.OrigName = origNameFromURL(uri)
+	}
+	err = saveCacheMeta(sha1Hex, meta)
+	if err != nil {
+		os.Remove(longPath(path))
+		return nil, err
+	}
+	tf := &TestFile{
 		Path:    path,
 		Sha1Hex: sha1Hex,
+		Meta:    meta,
+	}
+	return tf, nil
+}
+
+func setInfraErrorForFile(tests []*Test, sha1Hex string, err error) {
+	for _, t := range tests {
+		if t.FileSha1Hex == sha1Hex {
+			t.InfraError = fmt.Errorf("failed to download test file: %w", err)
+		}
+	}
+}
+
+func httpContentLength(uri string) (int64, error) {
+	res, err := http.Head(uri)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HEAD '%s' failed with '%s'", uri, res.Status)
+	}
+	return res.ContentLength, nil
+}
+
+// checkDiskSpaceMust fails early if we don't have enough space to download
+// missing test files, instead of failing in the middle of a long run
+func checkDiskSpaceMust(tests []*Test) {
+	var needed int64
+	seen := map[string]bool{}
+	for _, test := range tests {
+		if seen[test.FileSha1Hex] || testFileExists(test.FileSha1Hex) {
+			continue
+		}
+		seen[test.FileSha1Hex] = true
+		size, err := httpContentLength(test.FileURL)
+		if err != nil {
+			fmt.Printf("can't get size of '%s': %s\n", test.FileURL, err)
+			continue
+		}
+		if size > 0 {
+			needed += size
+		}
 	}
+	needed += flgMinFreeMB * 1024 * 1024
+	free, err := diskFreeSpace(getCacheDirMust())
+	if err != nil {
+		fmt.Printf("can't get free disk space: %s\n", err)
+		return
+	}
+	fmt.Printf("need %d MB of disk space, %d MB free\n", needed/(1024*1024), free/(1024*1024))
+	panicIf(uint64(needed) > free, "not enough disk space in '%s': need %d MB, have %d MB\n", getCacheDirMust(), needed/(1024*1024), free/(1024*1024))
 }
 
+// many tests can use the same file so we only download it once
 func downloadTestFilesMust(tests []*Test) {
+	seen := map[string]bool{}
+	var diskFullErr error
 	for _, test := range tests {
-		dlIfNotExistsMust(test.FileURL, test.FileSha1Hex)
+		if seen[test.FileSha1Hex] {
+			continue
+		}
+		seen[test.FileSha1Hex] = true
+		if diskFullErr != nil {
+			if !testFileExists(test.FileSha1Hex) {
+				setInfraErrorForFile(tests, test.FileSha1Hex, diskFullErr)
+			}
+			continue
+		}
+		err := dlIfNotExists(test.FileURL, test.FileSha1Hex, test.OrigName)
+		if err == nil {
+			continue
+		}
+		setInfraErrorForFile(tests, test.FileSha1Hex, err)
+		if isDiskFullError(err) {
+			fmt.Printf("disk is full, not downloading remaining test files\n")
+			diskFullErr = err
+		}
 	}
+	fmt.Printf("%d unique test files used by %d tests\n", len(seen), len(tests))
 }
 
 func runTests(tests []*Test) {
+	lastCheckpointTime = time.Now()
+	nToRun := 0
 	for _, test := range tests {
-		runTest(test)
+		if !test.Done && test.InfraError == nil {
+			nToRun++
+		}
 	}
+	atomic.StoreInt64(&metricQueueDepth, int64(nToRun))
+	emitEvent(&Event{Event: "run_start", Tests: len(tests)})
+	nFailed := 0
+	for _, test := range testRunOrder(tests) {
+		if runStopReason != "" {
+			emitTestSkip(test, runStopReason)
+			continue
+		}
+		if test.Done {
+			reason := "resumed from checkpoint"
+			if test.FromCache {
+				reason = "passed before with the same binary and inputs"
+			}
+			emitTestSkip(test, reason)
+			continue
+		}
+		if test.InfraError != nil {
+			test.Done = true
+			emitTestSkip(test, failureReason(test))
+			continue
+		}
+		emitTestStart(test)
+		timeStart := time.Now()
+		runHookTestStart(test)
+		atomic.AddInt64(&metricQueueDepth, -1)
+		atomic.StoreInt64(&metricWorkersBusy, 1)
+		test.span = startSpan(runSpan, "regress.test")
+		if test.InfraError == nil {
+			runTest(test)
+		}
+		runHookTestEnd(test)
+		test.span.SetAttr("regress.name", testDisplayName(test))
+		test.span.SetAttr("regress.sha1", test.FileSha1Hex)
+		test.span.SetAttr("regress.cmd", test.CmdUnparsed)
+		test.span.SetAttr("regress.failed", isFailedTest(test))
+		test.span.Finish()
+		atomic.StoreInt64(&metricWorkersBusy, 0)
+		test.Done = true
+		emitTestEnd(test, time.Since(timeStart))
+		updateTestMetrics(test)
+		saveCheckpoint(tests, false)
+		if !isUnexpectedFailure(test) {
+			continue
+		}
+		nFailed++
+		if flgFailFast {
+			runStopReason = "not run because of -failfast"
+		} else if flgMaxFailures > 0 && nFailed >= flgMaxFailures {
+			runStopReason = fmt.Sprintf("not run because %d tests failed (-max-failures)", nFailed)
+		}
+	}
+	saveCheckpoint(tests, true)
 }
 
 func removeExt(s string) string {
@@ -377,18 +961,22 @@ func removeExt(s string) string {
 
 func verifyTestFiles() {
 	d := getCacheDirMust()
-	files, err := ioutil.ReadDir(d)
+	files, err := ioutil.ReadDir(longPath(d))
 	fatalIfErr(err)
 	for _, fi := range files {
+		if isCacheMetaFile(fi.Name()) {
+			continue
+		}
 		path := filepath.Join(d, fi.Name())
 		sha1HexFromName := removeExt(fi.Name())
-		panicIflen(sha1HexFromName) != 40, "len(sha1HexFromName) != 40 (%d)", len(sha1HexFromName))
+		panicIf(len(sha1HexFromName) != 40, "len(sha1HexFromName) != 40 (%d)", len(sha1HexFromName))
 		sha1Hex, err := sha1HexOfFile(path)
 		fatalIfErr(err)
-		panicIfsha1Hex != sha1HexFromName, "sha1Hex != sha1HexFromName (%s != %s)", sha1Hex, sha1HexFromName)
+		panicIf(sha1Hex != sha1HexFromName, "sha1Hex != sha1HexFromName (%s != %s)", sha1Hex, sha1HexFromName)
 		testFilesBySha1[sha1Hex] = &TestFile{
 			Path:    path,
 			Sha1Hex: sha1Hex,
+			Meta:    loadCacheMeta(sha1Hex),
 		}
 	}
 	fmt.Printf("%d test files locally\n", len(testFilesBySha1))
@@ -399,7 +987,7 @@ func isOS64Bit() bool {
 }
 
 func dirExists(path string) bool {
-	fi, err := os.Stat(path)
+	fi, err := os.Stat(longPath(path))
 	if err != nil {
 		return false
 	}
@@ -407,7 +995,7 @@ func dirExists(path string) bool {
 }
 
 func fileExists(path string) bool {
-	fi, err := os.Stat(path)
+	fi, err := os.Stat(longPath(path))
 	if err != nil {
 		return false
 	}
@@ -415,6 +1003,17 @@ func fileExists(path string) bool {
 }
 
 func verifyCommandsMust(tests []*Test) {
+	if flgBinDir != "" {
+		for _, t := range tests {
+			if t.CmdDir == "" {
+				t.CmdDir = flgBinDir
+			}
+		}
+	}
+	tests = verifyCommandsInCmdDir(tests)
+	if len(tests) == 0 {
+		return
+	}
 	var dirsToCheck []string
 	cmds := make(map[string]bool)
 	if isOS64Bit() && dirExists("rel64") {
@@ -424,11 +1023,14 @@ func verifyCommandsMust(tests []*Test) {
 		dirsToCheck = append(dirsToCheck, "rel")
 	}
 	// TODO: also check dbg64 and dbg?
-	panicIflen(dirsToCheck) == 0, "there is no rel or rel64 directory with executables")
+	panicIf(len(dirsToCheck) == 0, "there is no rel or rel64 directory with executables")
 	for _, test := range tests {
 		cmds[test.CmdName] = true
 	}
+	// if no dir has all commands we use the one with most of them and
+	// tests with missing commands fail
 	var dirWithCommands string
+	nMostFound := -1
 	for _, dir := range dirsToCheck {
 		var cmdsFound []string
 		for cmd := range cmds {
@@ -437,17 +1039,22 @@ func verifyCommandsMust(tests []*Test) {
 				cmdsFound = append(cmdsFound, cmd)
 			}
 		}
+		if len(cmdsFound) > nMostFound {
+			dirWithCommands = dir
+			nMostFound = len(cmdsFound)
+		}
 		if len(cmdsFound) == len(cmds) {
 			fmt.Printf("found all test commands in '%s'\n", dir)
-			dirWithCommands = dir
 			break
 		} else {
 			fmt.Printf("dir '%s' has only %d out of %d commands\n", dir, len(cmdsFound), len(cmds))
 		}
 	}
-	panicIfdirWithCommands == "", "didn't find a directory with all tests commands %v\n", cmds)
 	for _, test := range tests {
 		test.CmdPath = filepath.Join(dirWithCommands, test.CmdName)
+		if !fileExists(test.CmdPath) {
+			setMissingBinary(test, test.CmdPath)
+		}
 	}
 }
 
@@ -457,34 +1064,352 @@ func dumpTests(tests []*Test) {
 	}
 }
 
-func substFileVar(s, filePath string) string {
-	return strings.Replace(s, "$file", filePath, -1)
+// substVars expands $file (path of the test file), $filename (base name
+// of the test file), $origname (original name of the test file) and $sha1
+func substVars(s string, t *Test) string {
+	r := strings.NewReplacer(
+		"$filename", filepath.Base(t.FilePath),
+		"$dir", t.TempDir,
+		"$file", t.FilePath,
+		"$origname", t.OrigName,
+		"$sha1", t.FileSha1Hex,
+	)
+	return r.Replace(s)
 }
 
-func substFileVarAll(tests []*Test) {
+// expectedOutput expands variables at comparison time so that
+// ExpectedOutput can be written without knowing cache layout
+func expectedOutput(t *Test) string {
+	return substVars(t.ExpectedOutput, t)
+}
+
+func setTestFilePathsMust(tests []*Test) {
 	for _, test := range tests {
 		sha1Hex := test.FileSha1Hex
 		tf := testFilesBySha1[sha1Hex]
-		panicIftf == nil, "no test file for '%s'\n", sha1Hex)
-		test.FilePath = tf.Path
-		test.ExpectedOutput = substFileVar(test.ExpectedOutput, tf.Path)
-		for i, arg := range test.CmdArgs {
-			test.CmdArgs[i] = substFileVar(arg, tf.Path)
+		if test.InfraError != nil {
+			continue
 		}
+		panicIf(tf == nil, "no test file for '%s'\n", sha1Hex)
+		test.FilePath = longPath(tf.Path)
+		resolveOrigName(test, tf)
+		test.FileMeta = tf.Meta
 	}
 }
 
+var (
+	flgTests    string
+	flgNoStrict bool
+	flgSelect   string
+	flgTags     string
+	flgRun      string
+	flgShard    string
+	flgSkipTags string
+
+	flgGsPath   string
+	flgGsDPI    int
+	flgGsDevice string
+
+	flgPdfiumPath string
+	flgPdfiumDPI  int
+
+	flgPdftotextPath     string
+	flgPdfinfoPath       string
+	flgTextMinSimilarity float64
+
+	flgOracleMaxDiff      float64
+	flgOracleSuppressions string
+
+	flgFormatCmds string
+
+	flgMinFreeMB    int64
+	flgMetricsPort  int
+	flgOtlpEndpoint string
+	flgEvents       string
+
+	flgTimeout          time.Duration
+	flgKillGrace        time.Duration
+	flgNoSandbox        bool
+	flgSandboxNoNetwork bool
+	flgBinDir           string
+	flgLaunchRetries    int
+	flgDisplaySetup     string
+	flgProcdumpPath     string
+	flgCrashDialogs     bool
+	flgCdbPath          string
+	flgOrder            string
+	flgFailFast         bool
+	flgMaxFailures      int
+	flgEnforceBudgets   bool
+	flgMatrix           string
+	flgSwRenderArgs     string
+	flgDpiArgs          string
+	flgDiagArgs         string
+	flgBin32Dir         string
+	flgBin64Dir         string
+	flgDbgDir           string
+	flgRelDir           string
+	flgSymbolServer     string
+	flgSymbolsURL       string
+	flgCleanEnv         bool
+	flgSharedSettings   bool
+	flgSmokeFlags       bool
+
+	flgHookRunStart     string
+	flgHookTestStart    string
+	flgHookTestEnd      string
+	flgHookRunEnd       string
+	flgKeepTemp         bool
+	flgCheckTempCleanup bool
+
+	flgResults       string
+	flgHistory       string
+	flgCSV           string
+	flgJUnit         string
+	flgReportsDir    string
+	flgArchiveS3     string
+	flgMilestone     string
+	flgLicenses      string
+	flgBaseline      string
+	flgKnownFailures string
+	flgResultCache   string
+	flgForce         bool
+
+	flgCheckpoint         string
+	flgCheckpointInterval time.Duration
+	flgResume             bool
+)
+
+func parseFlags() {
+	flag.StringVar(&flgTests, "tests", filepath.Join("tools", "regress", "tests.txt"), "file with tests, .txt, .json (see testsjson.go) or .yaml (see testsyaml.go)")
+	flag.BoolVar(&flgNoStrict, "no-strict", false, "ignore unknown fields in test files instead of failing")
+	flag.StringVar(&flgSelect, "select", "", "only run tests whose files match e.g. 'encrypted' or 'pages>500' (needs corpus-index, see corpusindex.go)")
+	flag.StringVar(&flgRun, "run", "", "only run tests whose Name: matches this regexp")
+	flag.StringVar(&flgShard, "shard", "", "only run i-th of n parts of tests, e.g. 2/4")
+	flag.StringVar(&flgTags, "tags", "", "only run tests with any of these comma-separated Tags: (see tags.go)")
+	flag.StringVar(&flgSkipTags, "skip-tags", "", "don't run tests with any of these comma-separated Tags:")
+	flag.StringVar(&flgGsPath, "gs", defaultGsPath(), "path of Ghostscript executable, used by tests with 'Oracle: gs'")
+	flag.IntVar(&flgGsDPI, "gs-dpi", 72, "resolution used when rendering with Ghostscript")
+	flag.StringVar(&flgGsDevice, "gs-device", "png16m", "Ghostscript output device (only png devices can be compared)")
+	flag.StringVar(&flgPdfiumPath, "pdfium", "pdfium_test", "path of pdfium_test executable, used by tests with 'Oracle: pdfium'")
+	flag.IntVar(&flgPdfiumDPI, "pdfium-dpi", 72, "resolution used when rendering with pdfium_test")
+	flag.StringVar(&flgPdftotextPath, "pdftotext", "pdftotext", "path of Poppler's pdftotext executable, used by tests with 'Oracle: pdftotext'")
+	flag.StringVar(&flgPdfinfoPath, "pdfinfo", "pdfinfo", "path of Poppler's pdfinfo executable, used by corpus-index")
+	flag.Float64Var(&flgTextMinSimilarity, "text-min-similarity", 0.8, "min similarity (0...1) of text extracted by SumatraPDF and pdftotext")
+	flag.Float64Var(&flgOracleMaxDiff, "oracle-max-diff", 1, "max percentage of different pixels in images rendered by oracles")
+	flag.StringVar(&flgFormatCmds, "format-cmds", filepath.Join("tools", "regress", "format-cmds.txt"), "file with default commands per file format, for tests without Cmd:")
+	flag.StringVar(&flgOracleSuppressions, "oracle-suppressions", filepath.Join("tools", "regress", "oracle-suppressions.txt"), "file with known oracle disagreements")
+	flag.Int64Var(&flgMinFreeMB, "min-free-mb", 512, "disk space (in MB) to keep free in addition to test files we need to download")
+	flag.IntVar(&flgMetricsPort, "metrics-port", 0, "if not 0, serve Prometheus metrics on http://localhost:${port}/metrics during the run")
+	flag.StringVar(&flgOtlpEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "if set, send OpenTelemetry spans to this OTLP/HTTP endpoint e.g. http://localhost:4318")
+	flag.StringVar(&flgEvents, "events", "", "write test events as NDJSON to this file (- for stdout)")
+	flag.DurationVar(&flgTimeout, "timeout", 5*time.Minute, "kill a test that runs longer than this and save its stacks (0 for no timeout)")
+	flag.BoolVar(&flgNoSandbox, "no-sandbox", false, "don't run tests in a job object with UI restrictions (Windows only, see sandbox_windows.go)")
+	flag.BoolVar(&flgSandboxNoNetwork, "sandbox-no-network", false, "run tests with a restricted token that can't use network (Windows only, best effort)")
+	flag.IntVar(&flgLaunchRetries, "launch-retries", 3, "how many times to retry launching a test when the exe is locked e.g. by antivirus (see launchretry.go)")
+	flag.StringVar(&flgDisplaySetup, "display-setup", "", "command that sets up a virtual display if we're headless and tests need one, NAME=value lines it prints are added to environment (see display.go)")
+	flag.DurationVar(&flgKillGrace, "kill-grace", 10*time.Second, "after -timeout ask the test to exit and wait this long before killing it (0 to kill right away)")
+	flag.StringVar(&flgProcdumpPath, "procdump", "procdump.exe", "path of procdump used to save stacks of hung tests")
+	flag.BoolVar(&flgCrashDialogs, "crash-dialogs", false, "don't suppress Windows crash dialogs (by default they're suppressed and crash dumps are saved)")
+	flag.BoolVar(&flgCleanEnv, "clean-env", false, "run tests with minimal environment instead of inheriting it (see env.go)")
+	flag.BoolVar(&flgSharedSettings, "shared-settings", false, "don't give each test its own settings dir (-appdata)")
+	flag.BoolVar(&flgSmokeFlags, "smoke-flags", false, "instead of -tests run SumatraPDF with bad command-line arguments on a file from -tests")
+	flag.StringVar(&flgCdbPath, "cdb", "cdb.exe", "path of cdb used to get stacks from crash and hang dumps")
+	flag.StringVar(&flgSymbolServer, "symbol-server", "https://msdl.microsoft.com/download/symbols", "symbol server for symbolizing dumps")
+	flag.StringVar(&flgSymbolsURL, "symbols-url", "", "url of .pdb.zip with symbols of tested binary, can use ${ver}, ${build} and ${arch} (see symbols.go)")
+	flag.StringVar(&flgOrder, "order", "likely-failing", "order of running tests: likely-failing (recently failing, flaky and new tests first) or file")
+	flag.BoolVar(&flgFailFast, "failfast", false, "stop running tests after the first failure")
+	flag.StringVar(&flgMatrix, "matrix", "", "run tests for each variant of comma-separated dimensions: "+strings.Join(matrixDimNames(), ", "))
+	flag.StringVar(&flgSwRenderArgs, "sw-render-args", "", "arguments that force software rendering, for -matrix gpu")
+	flag.StringVar(&flgDiagArgs, "diag-args", "-log", "re-run failed render tests with these extra arguments and save the output as an artifact (\"\" to not re-run)")
+	flag.StringVar(&flgDpiArgs, "dpi-args", "", "arguments that set DPI, with ${dpi} replaced by 96, 144 or 192, for -matrix dpi")
+	flag.StringVar(&flgBinDir, "bin-dir", "", "directory with tested executables (default: rel64 or rel, whichever has them)")
+	flag.StringVar(&flgBin32Dir, "bin32", "rel", "directory with 32-bit executables, for -matrix arch")
+	flag.StringVar(&flgBin64Dir, "bin64", "rel64", "directory with 64-bit executables, for -matrix arch")
+	flag.StringVar(&flgDbgDir, "dbg", "dbg64", "directory with debug executables, for -matrix build")
+	flag.StringVar(&flgRelDir, "rel", "rel64", "directory with release executables, for -matrix build")
+	flag.BoolVar(&flgEnforceBudgets, "enforce-budgets", false, "fail tests that run longer than their Budget:")
+	flag.IntVar(&flgMaxFailures, "max-failures", 0, "stop running tests after this many failures (0 means no limit)")
+	flag.StringVar(&flgHookRunStart, "hook-run-start", "", "shell command to run before running tests (see hooks.go for env variables)")
+	flag.StringVar(&flgHookTestStart, "hook-test-start", "", "shell command to run before each test")
+	flag.StringVar(&flgHookTestEnd, "hook-test-end", "", "shell command to run after each test")
+	flag.StringVar(&flgHookRunEnd, "hook-run-end", "", "shell command to run after running tests")
+	flag.BoolVar(&flgCheckTempCleanup, "check-temp-cleanup", false, "fail tests that leave temp files, like CleanTemp: true for every test")
+	flag.BoolVar(&flgKeepTemp, "keep-temp", false, "don't delete temp files of the run (test files staged for SaveAs:, outputs), temp files of failed tests are always kept")
+	flag.StringVar(&flgResults, "results", filepath.Join("out", "regress", "results.json"), "file where results of the run are saved")
+	flag.StringVar(&flgCSV, "csv", "", "also save results as csv file, one row per test")
+	flag.StringVar(&flgReportsDir, "reports-dir", filepath.Join("out", "regress", "reports"), "save reports of the run in a new directory there, with index.json and latest pointing to the newest (\"\" to not save, see reports.go)")
+	flag.StringVar(&flgJUnit, "junit", "", "also save results as JUnit xml file, for CI")
+	flag.StringVar(&flgArchiveS3, "archive-s3", "", "upload results, report and artifacts to s3 bucket/prefix")
+	flag.StringVar(&flgMilestone, "milestone", "", "mark the run archived with -archive-s3 as a milestone (e.g. release name), archive-prune keeps it")
+	flag.StringVar(&flgLicenses, "licenses", "", "comma-separated licenses that allow publishing test files, artifacts of other tests are not archived")
+	flag.StringVar(&flgHistory, "history", filepath.Join("out", "regress", "history"), "directory where results of all runs are kept")
+	flag.StringVar(&flgBaseline, "baseline", "", "results.json of a previous run, only report and fail on new failures")
+	flag.StringVar(&flgKnownFailures, "known-failures", filepath.Join("tools", "regress", "known-failures.txt"), "file with tests that are expected to fail")
+	flag.StringVar(&flgResultCache, "result-cache", filepath.Join("out", "regress", "result-cache.json"), "file with results of passed tests, used to skip tests whose binary and inputs didn't change")
+	flag.BoolVar(&flgForce, "force", false, "run all tests, even if they passed before with the same binary and inputs")
+	flag.StringVar(&flgCheckpoint, "checkpoint", filepath.Join("out", "regress", "checkpoint.json"), "file where results of completed tests are periodically saved")
+	flag.DurationVar(&flgCheckpointInterval, "checkpoint-interval", time.Minute, "how often to save the checkpoint")
+	flag.BoolVar(&flgResume, "resume", false, "continue interrupted run, skipping tests completed according to -checkpoint")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: regress [flags] [command]\n%s\nflags:\n", commandsHelp)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+}
+
+const commandsHelp = `commands:
+  (none)          run the tests
+  triage          step through failures of the last run and update test files
+  label           set owner and labels of a failing test, e.g. label <test> -owner X -label fonts
+  serve           web ui for browsing results of runs, e.g. serve -port 8080
+  import-crashes  reproduce documents from crash reports and write test entries for them
+  smoke-all       open every file in the cache and check for crashes and hangs
+  diff-results    show tests that newly fail, pass or changed output, e.g. diff-results a.json b.json
+  autodiff        if binaries changed since the last autodiff, run all tests with previous and current ones and diff results
+  archive-prune   delete old runs archived with -archive-s3, keeping failed runs and milestones
+  scrub           remove metadata, attachments and optionally text from a pdf, e.g. scrub -replace-text in.pdf
+  dedupe          find near-duplicate pdf files in the cache
+  check-urls      check that urls of test files still work
+  check-growth    check that changed tests don't grow download size and run time too much, e.g. check-growth -base origin/master
+  sync            download missing test files and verify cached ones
+  nightly         build, sync, run all tests in shards and notify, resumes if interrupted, e.g. nightly -config tools/regress/nightly.txt
+  gen-tests       generate tests from files in a directory, e.g. gen-tests dir/
+  corpus-index    record page count, producer etc. of cached pdf files, for -select
+  selftest        check that the harness itself works
+`
+
 func main() {
+	parseFlags()
+	cmd := flag.Arg(0)
+	switch cmd {
+	case "":
+		runRegress()
+	case "triage":
+		triage()
+	case "serve":
+		serve(flag.Args()[1:])
+	case "import-crashes":
+		importCrashes(flag.Args()[1:])
+	case "smoke-all":
+		smokeAll(flag.Args()[1:])
+	case "diff-results":
+		diffResults(flag.Args()[1:])
+	case "autodiff":
+		autodiff(flag.Args()[1:])
+	case "archive-prune":
+		archivePrune(flag.Args()[1:])
+	case "scrub":
+		scrub(flag.Args()[1:])
+	case "dedupe":
+		dedupe(flag.Args()[1:])
+	case "check-urls":
+		checkURLs(flag.Args()[1:])
+	case "check-growth":
+		checkGrowth(flag.Args()[1:])
+	case "sync":
+		syncCorpus(flag.Args()[1:])
+	case "nightly":
+		nightly(flag.Args()[1:])
+	case "label":
+		labelTest(flag.Args()[1:])
+	case "gen-tests":
+		genTests(flag.Args()[1:])
+	case "corpus-index":
+		corpusIndex(flag.Args()[1:])
+	case "selftest":
+		selftest(flag.Args()[1:])
+	case "selftest-helper":
+		selftestHelper(flag.Args()[1:])
+	default:
+		fatalf("unknown command '%s'\n", cmd)
+	}
+}
+
+func runRegress() {
+	nFailed := runAllTests()
+	os.Exit(nFailed)
+}
+
+// runAllTests returns number of failures. Unlike runRegress it doesn't
+// call os.Exit so that its defers run.
+func runAllTests() int {
 	fmt.Printf("regress, 64-bit os: %v\n", isOS64Bit())
+	startMetricsServer()
+	runSpan = startSpan(nil, "regress.run")
+	openEvents()
 
 	verifyTestFiles()
-	p := filepath.Join("tools", "regress", "tests.txt")
-	tests := parseTestsMust(p)
+	loadOracleSuppressions(flgOracleSuppressions)
+	var tests []*Test
+	if runSmokeAll {
+		tests = genSmokeAllTests()
+	} else {
+		tests = parseTestsMust(flgTests)
+		if flgSmokeFlags {
+			tests = genSmokeFlagsTests(tests)
+		}
+	}
+	// before filters so that known failures of filtered out tests match
+	known := loadKnownFailures(tests)
+	tests = selectTests(tests)
+	tests = filterTestsByTags(tests)
+	tests = filterTestsByName(tests)
+	tests = filterTestsByShard(tests)
+	tests = filterSkippedTests(tests)
+	tests = expandMatrix(tests)
+	applyKnownFailures(tests, known)
 	verifyCommandsMust(tests)
+	checkDiskSpaceMust(tests)
 	downloadTestFilesMust(tests)
-	substFileVarAll(tests)
+	setTestFilePathsMust(tests)
 	//dumpTests(tests)
+	// load early so that we don't find out it's invalid after a long run
+	var baseline *RunResults
+	if flgBaseline != "" {
+		var err error
+		baseline, err = loadRunResults(flgBaseline)
+		fatalIfErr(err)
+	}
+	if flgResume {
+		resumeFromCheckpoint(tests)
+	}
+	skipCachedTests(tests)
+	runHookRunStartMust()
+	checkDisplay(tests)
+	setupFixtures(tests)
 
+	suppressCrashDialogs(testExeNames(tests))
+	// if we panic, restoreCrashDialogs below doesn't run
+	defer restoreCrashDialogs()
 	runTests(tests)
-	os.Exit(dumpFailedTests(tests))
+	restoreCrashDialogs()
+	teardownFixtures()
+	updateResultCache(tests)
+	saveResults(tests)
+	removeCheckpoint()
+	saveResultsCSV(tests)
+	saveResultsJUnit(append(tests, skippedTests...))
+	saveRunReports(tests)
+	archiveRunToS3(tests)
+	nFailed := dumpFailedTests(tests)
+	// with baseline we only fail on regressions
+	if baseline != nil {
+		nFailed = dumpBaselineDiff(tests, baseline) + len(suiteErrors)
+	}
+	nUnexpected := 0
+	for _, t := range tests {
+		if isUnexpectedFailure(t) {
+			nUnexpected++
+		}
+	}
+	emitEvent(&Event{Event: "run_end", Tests: len(tests), Passed: len(tests) - nUnexpected, Failed: nUnexpected})
+	runHookRunEnd(len(tests), nUnexpected)
+	closeEvents()
+	runSpan.SetAttr("regress.tests", len(tests))
+	runSpan.SetAttr("regress.failed", nFailed)
+	runSpan.Finish()
+	flushSpans()
+	removeScratchDir()
+	return nFailed
 }
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+-matrix gpu runs tests once for each variant of a dimension (e.g. hardware
+accelerated and software rendering) because they regress independently.
+Dimensions can be combined: -matrix gpu,dpi.
+
+A variant adds arguments and environment variables to Cmd: and its name
+(e.g. gpu=sw) is added to test's name. Each variant is compared against
+its own expected output:
+
+Out@gpu=sw: rendering page 1 ...
+
+For combined dimensions the variant is e.g. Out@gpu=sw,dpi=144:
+Variants without their own Out@ use Out:.
+
+Some dimensions (e.g. dpi) are only used by tests that ask for them with
+Matrix: dpi.
+*/
+
+// VariantOutput is expected output for a variant
+type VariantOutput struct {
+	Output string
+	LineNo int
+}
+
+type matrixVariant struct {
+	Name string
+	Args []string
+	Env  []string
+	// directory with executables, "" for default
+	CmdDir string
+}
+
+type matrixDim struct {
+	Name string
+	// only used by tests that have it in Matrix:
+	optIn bool
+	// tests the dimension is relevant for, nil means all
+	applies  func(t *Test) bool
+	variants func() []matrixVariant
+}
+
+var matrixDims = []*matrixDim{
+	{
+		Name:     "gpu",
+		applies:  isRenderTest,
+		variants: gpuVariants,
+	},
+	{
+		Name:     "dpi",
+		optIn:    true,
+		variants: dpiVariants,
+	},
+	{
+		Name:     "locale",
+		applies:  canSetLocale,
+		variants: localeVariants,
+	},
+	{
+		Name:     "arch",
+		variants: archVariants,
+	},
+	{
+		Name:     "build",
+		variants: buildVariants,
+	},
+}
+
+var matrixDpis = []string{"96", "144", "192"}
+
+func isRenderTest(t *Test) bool {
+	return isSumatraCmd(t) && hasArg(t.CmdArgs, "-render")
+}
+
+// SumatraPDF uses hardware acceleration by default, the flag that forces
+// software rendering is given with -sw-render-args
+func gpuVariants() []matrixVariant {
+	panicIf(flgSwRenderArgs == "", "-matrix gpu needs -sw-render-args with arguments that force software rendering\n")
+	return []matrixVariant{
+		{Name: "hw"},
+		{Name: "sw", Args: strings.Fields(flgSwRenderArgs)},
+	}
+}
+
+// SumatraPDF doesn't have a flag to override DPI so there are no default
+// arguments. In -dpi-args ${dpi} is replaced by DPI of the variant
+func dpiVariants() []matrixVariant {
+	panicIf(!strings.Contains(flgDpiArgs, "${dpi}"), "-matrix dpi needs -dpi-args with ${dpi} in it\n")
+	var res []matrixVariant
+	for _, dpi := range matrixDpis {
+		s := strings.ReplaceAll(flgDpiArgs, "${dpi}", dpi)
+		res = append(res, matrixVariant{Name: dpi, Args: strings.Fields(s)})
+	}
+	return res
+}
+
+func canSetLocale(t *Test) bool {
+	return isSumatraCmd(t) && !hasArg(t.CmdArgs, "-lang")
+}
+
+// UI language is set with -lang. LC_ALL sets system locale where the
+// environment controls it (e.g. wine), de has decimal comma, ar is RTL
+func localeVariants() []matrixVariant {
+	return []matrixVariant{
+		{Name: "en", Args: []string{"-lang", "en"}, Env: []string{"LC_ALL=en_US.UTF-8"}},
+		{Name: "de", Args: []string{"-lang", "de"}, Env: []string{"LC_ALL=de_DE.UTF-8"}},
+		{Name: "ar", Args: []string{"-lang", "ar"}, Env: []string{"LC_ALL=ar_SA.UTF-8"}},
+	}
+}
+
+// some bugs only happen in one of them e.g. allocations over 2 GB
+func archVariants() []matrixVariant {
+	return []matrixVariant{
+		{Name: "32", CmdDir: flgBin32Dir},
+		{Name: "64", CmdDir: flgBin64Dir},
+	}
+}
+
+// tests that pass in one and fail in the other show behavior that depends
+// on asserts or optimizations, see dumpBuildParityDiffs
+func buildVariants() []matrixVariant {
+	return []matrixVariant{
+		{Name: "dbg", CmdDir: flgDbgDir},
+		{Name: "rel", CmdDir: flgRelDir},
+	}
+}
+
+func dimAppliesTo(d *matrixDim, t *Test) bool {
+	if d.optIn && !hasArg(t.Matrix, d.Name) {
+		return false
+	}
+	return d.applies == nil || d.applies(t)
+}
+
+// parseMatrixField parses "Matrix: dpi, gpu"
+func parseMatrixField(pos string, val string) []string {
+	var res []string
+	for _, name := range strings.Split(val, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		panicIf(findMatrixDim(name) == nil, "%s: Matrix: unknown dimension '%s', known: %s\n", pos, name, strings.Join(matrixDimNames(), ", "))
+		res = append(res, name)
+	}
+	return res
+}
+
+func findMatrixDim(name string) *matrixDim {
+	for _, d := range matrixDims {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+func matrixDimNames() []string {
+	var res []string
+	for _, d := range matrixDims {
+		res = append(res, d.Name)
+	}
+	return res
+}
+
+func parseMatrixDimsMust(s string) []*matrixDim {
+	var res []*matrixDim
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		d := findMatrixDim(name)
+		panicIf(d == nil, "-matrix: unknown dimension '%s', known: %s\n", name, strings.Join(matrixDimNames(), ", "))
+		res = append(res, d)
+	}
+	panicIf(hasMatrixDim(res, "arch") && hasMatrixDim(res, "build"), "-matrix: arch and build can't be combined, both pick the executable\n")
+	// so that variant names don't depend on the order in -matrix
+	sort.SliceStable(res, func(i, j int) bool {
+		return matrixDimIdx(res[i]) < matrixDimIdx(res[j])
+	})
+	return res
+}
+
+func hasMatrixDim(dims []*matrixDim, name string) bool {
+	for _, d := range dims {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func matrixDimIdx(d *matrixDim) int {
+	for i, d2 := range matrixDims {
+		if d == d2 {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseVariantOutField parses "out@gpu=sw" field name
+func parseVariantOutField(name string) (string, bool) {
+	if !strings.HasPrefix(name, "out@") {
+		return "", false
+	}
+	return strings.TrimPrefix(name, "out@"), true
+}
+
+func cloneForVariant(t *Test, variant string, vs []matrixVariant) *Test {
+	c := *t
+	c.Variant = variant
+	c.base = t
+	if c.Name != "" {
+		c.Name += " [" + variant + "]"
+	}
+	var args, env []string
+	for _, v := range vs {
+		args = append(args, v.Args...)
+		env = append(env, v.Env...)
+		if v.CmdDir != "" {
+			c.CmdDir = v.CmdDir
+		}
+	}
+	c.CmdArgs = append(args, t.CmdArgs...)
+	if len(args) > 0 {
+		c.CmdUnparsed = c.CmdName + " " + strings.Join(c.CmdArgs, " ")
+	}
+	c.Env = append(append([]string{}, t.Env...), env...)
+	if vo, ok := t.VariantOutputs[variant]; ok {
+		c.ExpectedOutput = vo.Output
+		c.OutLineNo = vo.LineNo
+	} else {
+		// accepting output in triage must not overwrite shared Out:
+		c.OutLineNo = 0
+	}
+	return &c
+}
+
+// expandTest returns a test for each combination of variants of dims
+func expandTest(t *Test, dims []*matrixDim) []*Test {
+	type combo struct {
+		names []string
+		vs    []matrixVariant
+	}
+	combos := []combo{{}}
+	for _, d := range dims {
+		if !dimAppliesTo(d, t) {
+			continue
+		}
+		var next []combo
+		for _, c := range combos {
+			for _, v := range d.variants() {
+				names := append(append([]string{}, c.names...), d.Name+"="+v.Name)
+				vs := append(append([]matrixVariant{}, c.vs...), v)
+				next = append(next, combo{names, vs})
+			}
+		}
+		combos = next
+	}
+	if len(combos) == 1 && len(combos[0].names) == 0 {
+		return []*Test{t}
+	}
+	var res []*Test
+	for _, c := range combos {
+		res = append(res, cloneForVariant(t, strings.Join(c.names, ","), c.vs))
+	}
+	return res
+}
+
+// verifyCommandsInCmdDir sets CmdPath of tests that have CmdDir
+// and returns the remaining tests
+func verifyCommandsInCmdDir(tests []*Test) []*Test {
+	var res []*Test
+	for _, t := range tests {
+		if t.CmdDir == "" {
+			res = append(res, t)
+			continue
+		}
+		t.CmdPath = filepath.Join(t.CmdDir, t.CmdName)
+		if !fileExists(t.CmdPath) {
+			setMissingBinary(t, t.CmdPath)
+		}
+	}
+	return res
+}
+
+// dumpVariantsSummary shows how many tests failed in each variant e.g.
+// arch=32, so that a problem specific to one variant stands out
+func dumpVariantsSummary(tests []*Test) {
+	nRun := map[string]int{}
+	nFailed := map[string]int{}
+	for _, t := range tests {
+		if !t.Done || t.Variant == "" {
+			continue
+		}
+		for _, v := range strings.Split(t.Variant, ",") {
+			nRun[v]++
+			if isUnexpectedFailure(t) {
+				nFailed[v]++
+			}
+		}
+	}
+	if len(nRun) == 0 {
+		return
+	}
+	var variants []string
+	for v := range nRun {
+		variants = append(variants, v)
+	}
+	sort.Strings(variants)
+	fmt.Printf("\nfailures by variant:\n")
+	for _, v := range variants {
+		fmt.Printf("  %s: %d failed out of %d\n", v, nFailed[v], nRun[v])
+	}
+}
+
+// dumpBuildParityDiffs shows tests that pass in debug build and fail in
+// release build or the other way around
+func dumpBuildParityDiffs(tests []*Test) {
+	failedByBuild := map[string]map[string]bool{}
+	var keys []string
+	for _, t := range tests {
+		build := variantValue(t.Variant, "build")
+		if !t.Done || build == "" {
+			continue
+		}
+		// the same for dbg and rel variant of a test
+		key := strings.Replace(testDisplayName(t), "["+t.Variant+"]", "["+withoutVariantDim(t.Variant, "build")+"]", 1)
+		key = strings.Replace(key, " []", "", 1)
+		if failedByBuild[key] == nil {
+			failedByBuild[key] = map[string]bool{}
+			keys = append(keys, key)
+		}
+		failedByBuild[key][build] = isUnexpectedFailure(t)
+	}
+	var diffs []string
+	for _, key := range keys {
+		m := failedByBuild[key]
+		dbgFailed, ok1 := m["dbg"]
+		relFailed, ok2 := m["rel"]
+		if !ok1 || !ok2 || dbgFailed == relFailed {
+			continue
+		}
+		if dbgFailed {
+			diffs = append(diffs, key+": fails in dbg, passes in rel")
+		} else {
+			diffs = append(diffs, key+": fails in rel, passes in dbg")
+		}
+	}
+	if len(diffs) == 0 {
+		return
+	}
+	fmt.Printf("\n%d tests with different result in dbg and rel:\n", len(diffs))
+	for _, s := range diffs {
+		fmt.Printf("  %s\n", s)
+	}
+}
+
+// withoutVariantDim returns e.g. "gpu=sw" for "gpu=sw,build=dbg"
+func withoutVariantDim(variant string, dim string) string {
+	var res []string
+	for _, v := range strings.Split(variant, ",") {
+		if !strings.HasPrefix(v, dim+"=") {
+			res = append(res, v)
+		}
+	}
+	return strings.Join(res, ",")
+}
+
+// variantValue returns e.g. "dbg" for dim "build" of variant "gpu=sw,build=dbg"
+func variantValue(variant string, dim string) string {
+	for _, v := range strings.Split(variant, ",") {
+		if strings.HasPrefix(v, dim+"=") {
+			return strings.TrimPrefix(v, dim+"=")
+		}
+	}
+	return ""
+}
+
+func expandMatrix(tests []*Test) []*Test {
+	if flgMatrix == "" {
+		return tests
+	}
+	dims := parseMatrixDimsMust(flgMatrix)
+	var res []*Test
+	for _, t := range tests {
+		res = append(res, expandTest(t, dims)...)
+	}
+	fmt.Printf("-matrix %s: %d tests expanded to %d\n", flgMatrix, len(tests), len(res))
+	return res
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	defer func(matrix, swArgs string) {
+		flgMatrix, flgSwRenderArgs = matrix, swArgs
+	}(flgMatrix, flgSwRenderArgs)
+	flgSwRenderArgs = "-disable-gpu"
+
+	tests := []struct {
+		matrix string
+		cmd    string
+		exp    []string // Variant: CmdUnparsed of expanded tests
+	}{
+		{"", "SumatraPDF.exe -render 1 $file", []string{": SumatraPDF.exe -render 1 $file"}},
+		{"gpu", "SumatraPDF.exe -render 1 $file", []string{
+			"gpu=hw: SumatraPDF.exe -render 1 $file",
+			"gpu=sw: SumatraPDF.exe -disable-gpu -render 1 $file",
+		}},
+		{"gpu", "SumatraPDF.exe -extract-text 1 $file", []string{": SumatraPDF.exe -extract-text 1 $file"}},
+		{"gpu", "mutool.exe draw -render $file", []string{": mutool.exe draw -render $file"}},
+		// order in -matrix doesn't matter
+		{"locale,gpu", "SumatraPDF.exe -render 1 $file", []string{
+			"gpu=hw,locale=en: SumatraPDF.exe -lang en -render 1 $file",
+			"gpu=hw,locale=de: SumatraPDF.exe -lang de -render 1 $file",
+			"gpu=hw,locale=ar: SumatraPDF.exe -lang ar -render 1 $file",
+			"gpu=sw,locale=en: SumatraPDF.exe -disable-gpu -lang en -render 1 $file",
+			"gpu=sw,locale=de: SumatraPDF.exe -disable-gpu -lang de -render 1 $file",
+			"gpu=sw,locale=ar: SumatraPDF.exe -disable-gpu -lang ar -render 1 $file",
+		}},
+		{"locale", "SumatraPDF.exe -lang fr -render 1 $file", []string{": SumatraPDF.exe -lang fr -render 1 $file"}},
+		// dpi is only used by tests with Matrix: dpi
+		{"dpi", "SumatraPDF.exe -render 1 $file", []string{": SumatraPDF.exe -render 1 $file"}},
+	}
+	for _, tc := range tests {
+		flgMatrix = tc.matrix
+		test := &Test{Name: "t"}
+		setCmd(test, tc.cmd)
+		var got []string
+		for _, e := range expandMatrix([]*Test{test}) {
+			got = append(got, e.Variant+": "+e.CmdUnparsed)
+			if e.Variant != "" && e.Name != "t ["+e.Variant+"]" {
+				t.Errorf("-matrix %s: got name '%s' for variant %s", tc.matrix, e.Name, e.Variant)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("-matrix %s '%s': got:\n%s\nexpected:\n%s", tc.matrix, tc.cmd, strings.Join(got, "\n"), strings.Join(tc.exp, "\n"))
+		}
+	}
+}
+
+func TestExpandMatrixVariantOutputs(t *testing.T) {
+	defer func(matrix, swArgs string) {
+		flgMatrix, flgSwRenderArgs = matrix, swArgs
+	}(flgMatrix, flgSwRenderArgs)
+	flgMatrix = "gpu"
+	flgSwRenderArgs = "-disable-gpu"
+
+	test := &Test{
+		ExpectedOutput: "out",
+		OutLineNo:      3,
+		VariantOutputs: map[string]*VariantOutput{"gpu=sw": {Output: "sw out", LineNo: 4}},
+	}
+	setCmd(test, "SumatraPDF.exe -render 1 $file")
+	exp := map[string]VariantOutput{
+		"gpu=hw": {Output: "out", LineNo: 0},
+		"gpu=sw": {Output: "sw out", LineNo: 4},
+	}
+	for _, e := range expandMatrix([]*Test{test}) {
+		got := VariantOutput{Output: e.ExpectedOutput, LineNo: e.OutLineNo}
+		if got != exp[e.Variant] {
+			t.Errorf("%s: got %+v, expected %+v", e.Variant, got, exp[e.Variant])
+		}
+	}
+}
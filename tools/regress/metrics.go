@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+/*
+With -metrics-port we serve /metrics in Prometheus text format
+so that long runs can be monitored with standard tooling.
+*/
+
+var (
+	metricTestsRun        int64
+	metricTestsFailed     int64
+	metricCrashes         int64
+	metricBytesDownloaded int64
+	metricQueueDepth      int64
+	metricWorkersBusy     int64
+	metricWorkers         int64 = 1
+)
+
+func writeMetric(w http.ResponseWriter, name, typ, help string, v interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, v)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	workers := atomic.LoadInt64(&metricWorkers)
+	busy := atomic.LoadInt64(&metricWorkersBusy)
+	utilization := 0.0
+	if workers > 0 {
+		utilization = float64(busy) / float64(workers)
+	}
+	writeMetric(w, "regress_tests_run_total", "counter", "Number of tests run.", atomic.LoadInt64(&metricTestsRun))
+	writeMetric(w, "regress_tests_failed_total", "counter", "Number of failed tests.", atomic.LoadInt64(&metricTestsFailed))
+	writeMetric(w, "regress_crashes_total", "counter", "Number of tests where the process crashed.", atomic.LoadInt64(&metricCrashes))
+	writeMetric(w, "regress_downloaded_bytes_total", "counter", "Number of bytes of test files downloaded.", atomic.LoadInt64(&metricBytesDownloaded))
+	writeMetric(w, "regress_queue_depth", "gauge", "Number of tests waiting to run.", atomic.LoadInt64(&metricQueueDepth))
+	writeMetric(w, "regress_workers", "gauge", "Number of workers running tests.", workers)
+	writeMetric(w, "regress_worker_utilization", "gauge", "Fraction of workers busy running a test.", utilization)
+}
+
+func startMetricsServer() {
+	if flgMetricsPort == 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	addr := fmt.Sprintf(":%d", flgMetricsPort)
+	fmt.Printf("serving metrics on http://localhost%s/metrics\n", addr)
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			fmt.Printf("metrics server failed: %s\n", err)
+		}
+	}()
+}
+
+func updateTestMetrics(t *Test) {
+	atomic.AddInt64(&metricTestsRun, 1)
+	if isFailedTest(t) {
+		atomic.AddInt64(&metricTestsFailed, 1)
+	}
+	if isCrashError(t.Error) {
+		atomic.AddInt64(&metricCrashes, 1)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+/*
+Sometimes not all binaries were built e.g. we only have rel64 and not
+dbg32. Instead of not running any tests we mark tests whose binary is
+missing as errored and run the rest.
+*/
+
+// missingBinaryError is InfraError of a test whose binary doesn't exist
+type missingBinaryError struct {
+	path string
+}
+
+func (e *missingBinaryError) Error() string {
+	return fmt.Sprintf("missing binary %s", e.path)
+}
+
+func setMissingBinary(t *Test, path string) {
+	t.InfraError = &missingBinaryError{path: path}
+}
+
+func missingBinary(t *Test) string {
+	var err *missingBinaryError
+	if errors.As(t.InfraError, &err) {
+		return err.path
+	}
+	return ""
+}
+
+// dumpMissingBinaries shows e.g. "3 tests skipped: missing binary dbg32/SumatraPDF.exe"
+func dumpMissingBinaries(tests []*Test) {
+	counts := map[string]int{}
+	for _, t := range tests {
+		if path := missingBinary(t); path != "" {
+			counts[path]++
+		}
+	}
+	var paths []string
+	for path := range counts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Printf("%d tests skipped: missing binary %s\n", counts[path], path)
+	}
+}
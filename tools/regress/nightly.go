@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+regress [flags] nightly is for a scheduled task on a machine that runs all
+tests every night. It does, in order:
+- build: runs the command that builds or fetches binaries
+- sync: downloads missing test files and verifies sha1 of cached files
+  (also available as regress sync)
+- runs tests in shards (see -shard), as many at once as parallel:
+- merges results of shards into -results and -history
+- notify: runs the command with the result in env variables
+
+What to do is in -config (tools/regress/nightly.txt by default):
+
+build: ./doit.bat -build-release
+shards: 4
+parallel: 2
+args: -matrix gpu -sw-render-args -disable-gpu
+notify: curl -d "nightly $REGRESS_NIGHTLY_STATUS" https://example.com/hook
+
+Flags given before nightly are passed to test runs, args: adds more
+(e.g. -archive-s3 to archive each shard).
+
+It's idempotent: progress is saved in out/regress/nightly/<id>/state.json
+(id is the date by default) and running it again skips finished steps,
+resumes interrupted shards from their checkpoint and does nothing if
+the nightly finished. -restart starts over.
+
+notify: gets REGRESS_NIGHTLY_ID, REGRESS_NIGHTLY_STATUS (passed, failed
+or error), REGRESS_NIGHTLY_ERROR, REGRESS_TESTS, REGRESS_FAILED and
+REGRESS_RESULTS.
+*/
+
+// NightlyConfig is parsed -config of nightly
+type NightlyConfig struct {
+	Build    string
+	Shards   int
+	Parallel int
+	Args     []string
+	Notify   string
+}
+
+// NightlyState is progress of a nightly, saved after each step
+type NightlyState struct {
+	ID          string
+	Started     time.Time
+	Built       bool
+	Synced      bool
+	ShardsDone  map[int]bool
+	Done        bool
+	Status      string `json:",omitempty"`
+	Error       string `json:",omitempty"`
+	Tests       int
+	Failed      int
+	ResultsPath string `json:",omitempty"`
+}
+
+func parseNightlyConfigMust(path string) *NightlyConfig {
+	cfg := &NightlyConfig{Shards: 1, Parallel: 1}
+	d, err := ioutil.ReadFile(longPath(path))
+	if os.IsNotExist(err) {
+		fmt.Printf("'%s' doesn't exist, using defaults\n", path)
+		return cfg
+	}
+	fatalIfErr(err)
+	for i, l := range toTrimmedLines(d) {
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		pos := fmt.Sprintf("%s:%d", path, i+1)
+		parts := strings.SplitN(l, ":", 2)
+		panicIf(len(parts) != 2, "%s: invalid line: '%s'\n", pos, l)
+		val := strings.TrimSpace(parts[1])
+		switch strings.ToLower(parts[0]) {
+		case "build":
+			cfg.Build = val
+		case "shards":
+			cfg.Shards, err = strconv.Atoi(val)
+			panicIf(err != nil || cfg.Shards < 1, "%s: shards: must be a number >= 1, got '%s'\n", pos, val)
+		case "parallel":
+			cfg.Parallel, err = strconv.Atoi(val)
+			panicIf(err != nil || cfg.Parallel < 1, "%s: parallel: must be a number >= 1, got '%s'\n", pos, val)
+		case "args":
+			cfg.Args = append(cfg.Args, strings.Fields(val)...)
+		case "notify":
+			cfg.Notify = val
+		default:
+			panicIf(true, "%s: unknown field '%s'\n", pos, parts[0])
+		}
+	}
+	return cfg
+}
+
+func nightlyStatePath(dir string) string {
+	return filepath.Join(dir, "state.json")
+}
+
+func loadNightlyState(dir string, id string) *NightlyState {
+	st := &NightlyState{}
+	d, err := ioutil.ReadFile(longPath(nightlyStatePath(dir)))
+	if err == nil && json.Unmarshal(d, st) == nil {
+		return st
+	}
+	return &NightlyState{ID: id, Started: time.Now(), ShardsDone: map[int]bool{}}
+}
+
+func saveNightlyState(dir string, st *NightlyState) {
+	d, err := json.MarshalIndent(st, "", "  ")
+	fatalIfErr(err)
+	err = ioutil.WriteFile(longPath(nightlyStatePath(dir)), d, 0644)
+	fatalIfErr(err)
+}
+
+// runSelf runs regress with args, its output goes to ours
+func runSelf(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	fmt.Printf("Running: %s\n", cmdToStrLong(cmd))
+	return cmd.Run()
+}
+
+// shardArgs makes a shard save results, checkpoint, result cache and
+// reports in dir of the nightly. History is saved once for all shards.
+// Shards run at the same time so they leave crash dialogs to us (see
+// runShards) and don't serve metrics or write events.
+func shardArgs(cfg *NightlyConfig, dir string, shard int) []string {
+	args := append([]string{}, regressArgsBeforeCommand("nightly")...)
+	args = append(args, cfg.Args...)
+	prefix := filepath.Join(dir, fmt.Sprintf("shard-%d", shard))
+	args = append(args,
+		"-shard", fmt.Sprintf("%d/%d", shard, cfg.Shards),
+		"-results", prefix+".json",
+		"-checkpoint", prefix+"-checkpoint.json",
+		"-reports-dir", prefix+"-reports",
+		"-result-cache", prefix+"-result-cache.json",
+		"-history", "",
+		"-crash-dialogs",
+		"-metrics-port", "0",
+		"-events", "",
+	)
+	if fileExists(prefix + "-checkpoint.json") {
+		args = append(args, "-resume")
+	}
+	return args
+}
+
+func shardResultsPath(dir string, shard int) string {
+	return filepath.Join(dir, fmt.Sprintf("shard-%d.json", shard))
+}
+
+// runShards runs shards that are not done yet, cfg.Parallel at a time.
+// Failing tests are fine, a shard failed if it didn't save results.
+// Crash dialogs are suppressed once for all shards, if each shard did
+// it, the first to finish would restore them while others still run.
+func runShards(cfg *NightlyConfig, dir string, st *NightlyState) error {
+	suppressCrashDialogs(testExeNames(parseTestsMust(flgTests)))
+	defer restoreCrashDialogs()
+	var mu sync.Mutex
+	var errs []string
+	sem := make(chan bool, cfg.Parallel)
+	var wg sync.WaitGroup
+	for shard := 1; shard <= cfg.Shards; shard++ {
+		if st.ShardsDone[shard] {
+			fmt.Printf("shard %d/%d already done\n", shard, cfg.Shards)
+			continue
+		}
+		wg.Add(1)
+		sem <- true
+		go func(shard int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			path := shardResultsPath(dir, shard)
+			os.Remove(longPath(path))
+			err := runSelf(shardArgs(cfg, dir, shard))
+			mu.Lock()
+			defer mu.Unlock()
+			if !fileExists(path) {
+				errs = append(errs, fmt.Sprintf("shard %d/%d didn't save results (%v)", shard, cfg.Shards, err))
+				return
+			}
+			st.ShardsDone[shard] = true
+			saveNightlyState(dir, st)
+		}(shard)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// mergeShardResults saves results of all shards as one run
+func mergeShardResults(cfg *NightlyConfig, dir string, st *NightlyState) (*RunResults, error) {
+	res := &RunResults{
+		ID:      "nightly-" + st.ID,
+		Started: st.Started,
+	}
+	for shard := 1; shard <= cfg.Shards; shard++ {
+		run, err := loadRunResults(shardResultsPath(dir, shard))
+		if err != nil {
+			return nil, err
+		}
+		res.Tests = append(res.Tests, run.Tests...)
+	}
+	err := saveRunResults(flgResults, res)
+	if err != nil {
+		return nil, err
+	}
+	saveRunToHistory(res)
+	return res, nil
+}
+
+func notifyNightly(cfg *NightlyConfig, st *NightlyState) {
+	env := []string{
+		"REGRESS_NIGHTLY_ID=" + st.ID,
+		"REGRESS_NIGHTLY_STATUS=" + st.Status,
+		"REGRESS_NIGHTLY_ERROR=" + st.Error,
+		fmt.Sprintf("REGRESS_TESTS=%d", st.Tests),
+		fmt.Sprintf("REGRESS_FAILED=%d", st.Failed),
+		"REGRESS_RESULTS=" + st.ResultsPath,
+	}
+	// failed notification doesn't change the result of the nightly
+	runHook("nightly-notify", cfg.Notify, env)
+}
+
+// runNightlySteps returns error of the first step that failed
+func runNightlySteps(cfg *NightlyConfig, dir string, st *NightlyState) error {
+	if !st.Built && cfg.Build != "" {
+		if err := runHook("nightly-build", cfg.Build, nil); err != nil {
+			return err
+		}
+	}
+	st.Built = true
+	saveNightlyState(dir, st)
+	if !st.Synced {
+		args := append([]string{}, regressArgsBeforeCommand("nightly")...)
+		args = append(args, "sync")
+		if err := runSelf(args); err != nil {
+			return fmt.Errorf("sync failed: %w", err)
+		}
+	}
+	st.Synced = true
+	saveNightlyState(dir, st)
+	if err := runShards(cfg, dir, st); err != nil {
+		return err
+	}
+	run, err := mergeShardResults(cfg, dir, st)
+	if err != nil {
+		return err
+	}
+	st.Tests = len(run.Tests)
+	st.Failed = countFailed(run)
+	st.ResultsPath = flgResults
+	return nil
+}
+
+func nightly(args []string) {
+	fs := flag.NewFlagSet("nightly", flag.ExitOnError)
+	cfgPath := fs.String("config", filepath.Join("tools", "regress", "nightly.txt"), "what the nightly does")
+	id := fs.String("id", time.Now().Format("20060102"), "id of the nightly, a nightly with the same id is resumed")
+	restart := fs.Bool("restart", false, "start over even if a nightly with the same id already ran")
+	fs.Parse(args)
+
+	cfg := parseNightlyConfigMust(*cfgPath)
+	dir := filepath.Join("out", "regress", "nightly", *id)
+	if *restart {
+		os.RemoveAll(longPath(dir))
+	}
+	err := os.MkdirAll(longPath(dir), 0755)
+	fatalIfErr(err)
+	st := loadNightlyState(dir, *id)
+	if st.Done {
+		fmt.Printf("nightly %s already finished: %s, %d of %d tests failed (use -restart to run again)\n", st.ID, st.Status, st.Failed, st.Tests)
+		return
+	}
+	if st.ShardsDone == nil {
+		st.ShardsDone = map[int]bool{}
+	}
+
+	err = runNightlySteps(cfg, dir, st)
+	switch {
+	case err != nil:
+		st.Status = "error"
+		st.Error = err.Error()
+	case st.Failed > 0:
+		st.Status = "failed"
+	default:
+		st.Status = "passed"
+	}
+	// only a finished nightly is done, after an error it's resumed
+	st.Done = err == nil
+	saveNightlyState(dir, st)
+	notifyNightly(cfg, st)
+	if err != nil {
+		fmt.Printf("nightly %s failed: %s\n", st.ID, err)
+		os.Exit(1)
+	}
+	fmt.Printf("nightly %s %s: %d of %d tests failed\n", st.ID, st.Status, st.Failed, st.Tests)
+}
+
+// syncCorpus downloads test files that are not in the cache and verifies
+// sha1 of cached files. Exits with 1 if a file couldn't be downloaded.
+func syncCorpus(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	fs.Parse(args)
+
+	verifyTestFiles()
+	tests := parseTestsMust(flgTests)
+	checkDiskSpaceMust(tests)
+	downloadTestFilesMust(tests)
+	nFailed := 0
+	seen := map[string]bool{}
+	for _, t := range tests {
+		if t.InfraError == nil || seen[t.FileSha1Hex] {
+			continue
+		}
+		seen[t.FileSha1Hex] = true
+		fmt.Printf("failed to download '%s': %s\n", t.FileURL, t.InfraError)
+		nFailed++
+	}
+	if nFailed > 0 {
+		fmt.Printf("%d test files couldn't be downloaded\n", nFailed)
+		os.Exit(1)
+	}
+	fmt.Printf("all test files are in the cache\n")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+/*
+Output often has absolute paths of the test file or of files the test
+created, e.g. "loading C:\Users\kjk\AppData\Local\Temp\regress-run-123\test-456\foo.pdf".
+They differ between machines and between runs so before comparing with
+Out: we rewrite paths under the cache and scratch directories, both in
+expected and actual output:
+
+  <cache dir>\<sha1>.pdf    => $CACHE/<sha1>.pdf
+  <temp dir of the test>\a  => $TMP/a
+
+The rest of the path uses / so that Out: is the same on all platforms.
+Temp dirs of other tests are also under $TMP, e.g. $TMP/test-789/a,
+which is not stable but shouldn't show up in output.
+*/
+
+type pathRoot struct {
+	dir   string
+	token string
+}
+
+// don't stop at spaces because Windows paths often have them, quotes and
+// end of line end the path
+const pathRestPattern = `[^\r\n"'<>|]*`
+
+// pathRoots returns roots to rewrite, longest first because the temp dir
+// of the test is inside scratch dir
+func pathRoots(t *Test) []pathRoot {
+	var res []pathRoot
+	add := func(dir string, token string) {
+		if dir == "" {
+			return
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return
+		}
+		res = append(res, pathRoot{dir, token})
+	}
+	add(t.TempDir, "$TMP")
+	add(scratchDir, "$TMP")
+	add(cacheDir, "$CACHE")
+	sort.SliceStable(res, func(i, j int) bool {
+		return len(res[i].dir) > len(res[j].dir)
+	})
+	return res
+}
+
+// rxPathRoot matches dir (also with / instead of \ and with \\?\ prefix
+// of long paths) followed by the rest of the path. It must not match
+// a prefix of a name e.g. regress-run-1 in regress-run-12 so dir must be
+// followed by a separator or a character that can't be in a name
+func rxPathRoot(dir string) *regexp.Regexp {
+	var alts []string
+	for _, s := range []string{dir, filepath.ToSlash(dir)} {
+		alts = append(alts, regexp.QuoteMeta(s))
+	}
+	s := `(?:\\\\\?\\)?(?:` + strings.Join(alts, "|") + `)` + `(?:([\\/]` + pathRestPattern + `)|([^\w.\-]|$))`
+	if runtime.GOOS == "windows" {
+		// file system is case-insensitive
+		s = "(?i)" + s
+	}
+	return regexp.MustCompile(s)
+}
+
+// normalizePaths rewrites absolute paths under cache and scratch
+// directories to $CACHE/... and $TMP/...
+func normalizePaths(s string, t *Test) string {
+	for _, root := range pathRoots(t) {
+		rx := rxPathRoot(root.dir)
+		s = rx.ReplaceAllStringFunc(s, func(m string) string {
+			sm := rx.FindStringSubmatch(m)
+			return root.token + strings.ReplaceAll(sm[1], `\`, "/") + sm[2]
+		})
+	}
+	return s
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+/*
+Oracles are independent renderers that we run on the same page as
+SumatraPDF. They give us a reference for what the correct result is
+so that we don't have to trust our own expected output blindly.
+
+A test opts in with e.g.:
+Oracle: gs, pdfium
+
+Oracles vote on whether SumatraPDF is right to render the page (or to
+fail). If Cmd: saves the rendered page as .png listed in ProducesFile:,
+they also vote on how it looks: the renderer whose image differs from all
+others, while they agree with each other, is the one that's wrong. If
+there's no majority (e.g. SumatraPDF and a single oracle differ) the test
+fails with "disagreement", someone has to look at the images.
+*/
+
+// OracleResult describes the result of rendering a page with an oracle
+type OracleResult struct {
+	Name      string
+	ImagePath string
+	Output    string
+	Error     error
+}
+
+var (
+	knownOracles   = []string{"gs", "pdfium", "pdftotext"}
+	missingOracles = map[string]bool{}
+)
+
+func defaultGsPath() string {
+	if runtime.GOOS == "windows" {
+		return "gswin64c.exe"
+	}
+	return "gs"
+}
+
+func isKnownOracle(name string) bool {
+	for _, s := range knownOracles {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseOracleNames(s string) []string {
+	var res []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		panicIf(!isKnownOracle(name), "unknown oracle '%s'\n", name)
+		res = append(res, name)
+	}
+	return res
+}
+
+// testPageNo returns page number from -render N or -extract-text N
+// and 1 if the command doesn't specify a page
+func testPageNo(t *Test) int {
+	args := t.CmdArgs
+	for i, arg := range args {
+		if arg != "-render" && arg != "-extract-text" {
+			continue
+		}
+		if i+1 < len(args) {
+			n, err := strconv.Atoi(args[i+1])
+			if err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	return 1
+}
+
+// getOracleDir is per test because tests (e.g. -matrix variants) can
+// render the same page of the same file
+func getOracleDir(t *Test) (string, error) {
+	name := fmt.Sprintf("%s-%d-%s", t.FileSha1Hex, testPageNo(t), testID(t))
+	d := filepath.Join("out", "regress", "oracles", name)
+	err := os.MkdirAll(longPath(d), 0755)
+	return d, err
+}
+
+// we don't want to fail the tests if oracle is not installed
+func isOracleAvailable(name, exePath string) bool {
+	_, err := exec.LookPath(exePath)
+	if err == nil {
+		return true
+	}
+	if !missingOracles[name] {
+		fmt.Printf("oracle '%s': '%s' not found, skipping\n", name, exePath)
+		missingOracles[name] = true
+	}
+	return false
+}
+
+func runOracleCmd(name string, cmd *exec.Cmd, imgPath string) *OracleResult {
+	res := &OracleResult{
+		Name: name,
+	}
+	os.Remove(longPath(imgPath))
+	fmt.Printf("Running oracle: %s\n", cmdToStrLong(cmd))
+	out, err := cmd.CombinedOutput()
+	res.Output = strings.TrimSpace(string(out))
+	res.Error = err
+	if err == nil && !fileExists(imgPath) {
+		res.Error = fmt.Errorf("oracle '%s' didn't create '%s'", name, imgPath)
+	}
+	if res.Error == nil {
+		res.ImagePath = imgPath
+	}
+	return res
+}
+
+func runGsOracle(t *Test) *OracleResult {
+	if !isOracleAvailable("gs", flgGsPath) {
+		return nil
+	}
+	pageNo := testPageNo(t)
+	ext := ".png"
+	if !strings.HasPrefix(flgGsDevice, "png") {
+		ext = "." + flgGsDevice
+	}
+	dir, err := getOracleDir(t)
+	if err != nil {
+		t.InfraError = err
+		return nil
+	}
+	imgPath := filepath.Join(dir, "gs"+ext)
+	args := []string{
+		"-q", "-dSAFER", "-dBATCH", "-dNOPAUSE",
+		"-sDEVICE=" + flgGsDevice,
+		fmt.Sprintf("-r%d", flgGsDPI),
+		fmt.Sprintf("-dFirstPage=%d", pageNo),
+		fmt.Sprintf("-dLastPage=%d", pageNo),
+		"-sOutputFile=" + imgPath,
+		t.FilePath,
+	}
+	cmd := exec.Command(flgGsPath, args...)
+	return runOracleCmd("gs", cmd, imgPath)
+}
+
+// pdfium_test saves rendered page next to the input file as
+// ${file}.${pageIdx}.png so we move it to the oracle dir
+func runPdfiumOracle(t *Test) *OracleResult {
+	if !isOracleAvailable("pdfium", flgPdfiumPath) {
+		return nil
+	}
+	pageIdx := testPageNo(t) - 1
+	dir, err := getOracleDir(t)
+	if err != nil {
+		t.InfraError = err
+		return nil
+	}
+	imgPath := filepath.Join(dir, "pdfium.png")
+	args := []string{
+		"--png",
+		fmt.Sprintf("--pages=%d", pageIdx),
+		fmt.Sprintf("--scale=%.4f", float64(flgPdfiumDPI)/72),
+		t.FilePath,
+	}
+	cmd := exec.Command(flgPdfiumPath, args...)
+	pdfiumPath := fmt.Sprintf("%s.%d.png", t.FilePath, pageIdx)
+	os.Remove(longPath(pdfiumPath))
+	res := runOracleCmd("pdfium", cmd, pdfiumPath)
+	if res.Error != nil {
+		return res
+	}
+	os.Remove(longPath(imgPath))
+	res.Error = os.Rename(longPath(pdfiumPath), longPath(imgPath))
+	res.ImagePath = imgPath
+	if res.Error != nil {
+		res.ImagePath = ""
+	}
+	return res
+}
+
+func loadPngImage(path string) (image.Image, error) {
+	f, err := os.Open(longPath(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// compareImages returns percentage of pixels that differ noticeably
+// (to allow for different anti-aliasing)
+func compareImages(path1, path2 string) (float64, error) {
+	img1, err := loadPngImage(path1)
+	if err != nil {
+		return 0, err
+	}
+	img2, err := loadPngImage(path2)
+	if err != nil {
+		return 0, err
+	}
+	b1, b2 := img1.Bounds(), img2.Bounds()
+	if b1.Dx() != b2.Dx() || b1.Dy() != b2.Dy() {
+		return 100, fmt.Errorf("different sizes: %dx%d vs. %dx%d", b1.Dx(), b1.Dy(), b2.Dx(), b2.Dy())
+	}
+	const tolerance = 32 * 256
+	nDiff := 0
+	for y := 0; y < b1.Dy(); y++ {
+		for x := 0; x < b1.Dx(); x++ {
+			r1, g1, bl1, _ := img1.At(b1.Min.X+x, b1.Min.Y+y).RGBA()
+			r2, g2, bl2, _ := img2.At(b2.Min.X+x, b2.Min.Y+y).RGBA()
+			if absDiff(r1, r2) > tolerance || absDiff(g1, g2) > tolerance || absDiff(bl1, bl2) > tolerance {
+				nDiff++
+			}
+		}
+	}
+	nTotal := b1.Dx() * b1.Dy()
+	if nTotal == 0 {
+		return 0, nil
+	}
+	return float64(nDiff) * 100 / float64(nTotal), nil
+}
+
+// SumatraPDF prints those when it can't load a file or render a page
+func didSumatraRender(t *Test) bool {
+	if t.Error != nil {
+		return false
+	}
+	if strings.Contains(t.Output, "failed to create engine") {
+		return false
+	}
+	return !strings.Contains(t.Output, "failed to render page")
+}
+
+// checkOracleResults does a majority vote between oracles on whether
+// SumatraPDF is right to render (or fail to render) the page and then
+// on how the page looks (see checkOracleImages). It fails the test if
+// SumatraPDF is the odd one out or if there's no majority. Oracles that
+// are the odd one out are recorded in OracleNotes.
+func checkOracleResults(t *Test) string {
+	pageNo := testPageNo(t)
+	sumatraOk := didSumatraRender(t)
+	var agree, disagree []string
+	for _, res := range t.OracleResults {
+		if isTextOracle(res.Name) {
+			continue
+		}
+		if (res.Error == nil) == sumatraOk {
+			agree = append(agree, res.Name)
+		} else {
+			disagree = append(disagree, res.Name)
+		}
+	}
+	if len(disagree) == 0 {
+		return checkOracleImages(t)
+	}
+	names := strings.Join(disagree, ", ")
+	switch {
+	case len(agree) > len(disagree):
+		// SumatraPDF is in majority so we assume oracle is wrong
+		note := fmt.Sprintf("oracle %s disagrees with SumatraPDF about page %d", names, pageNo)
+		t.OracleNotes = append(t.OracleNotes, note)
+		return checkOracleImages(t)
+	case len(agree) == len(disagree):
+		rendered, failed := agree, disagree
+		if !sumatraOk {
+			rendered, failed = disagree, agree
+		}
+		return fmt.Sprintf("disagreement about page %d: oracle %s rendered it, oracle %s failed", pageNo, strings.Join(rendered, ", "), strings.Join(failed, ", "))
+	case sumatraOk:
+		return fmt.Sprintf("SumatraPDF rendered page %d but oracle %s failed", pageNo, names)
+	}
+	return fmt.Sprintf("oracle %s rendered page %d but SumatraPDF failed", names, pageNo)
+}
+
+const sumatraImageName = "SumatraPDF"
+
+// renderedImage is a page rendered by SumatraPDF or an oracle
+type renderedImage struct {
+	Name string
+	Path string
+}
+
+// sumatraImagePath returns the page rendered by Cmd: if it saves it as .png
+// listed in ProducesFile:, "" if it doesn't
+func sumatraImagePath(t *Test) string {
+	for _, pf := range t.ProducesFiles {
+		path := producedFilePath(t, pf)
+		if strings.EqualFold(filepath.Ext(path), ".png") && fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// findImageOutlier returns index of the image that differs from all
+// others while they agree with each other, -1 if there's no such image.
+// differs has pairs of indexes i < j, missing pairs couldn't be compared.
+func findImageOutlier(n int, differs map[[2]int]bool) int {
+	for i := 0; i < n; i++ {
+		nSame, nDiff, nOthersSame := 0, 0, 0
+		othersAgree := true
+		for pair, diff := range differs {
+			inPair := pair[0] == i || pair[1] == i
+			switch {
+			case inPair && diff:
+				nDiff++
+			case inPair:
+				nSame++
+			case diff:
+				othersAgree = false
+			default:
+				nOthersSame++
+			}
+		}
+		// with 2 images we can't tell which one is wrong
+		if nSame == 0 && nDiff >= 2 && othersAgree && nOthersSame > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkOracleImages compares pages rendered by SumatraPDF and oracles with
+// each other. The renderer whose image differs from all others while they
+// agree is the one that's wrong. It fails the test if that's SumatraPDF or
+// if SumatraPDF's image differs and there's no majority.
+func checkOracleImages(t *Test) string {
+	var images []*renderedImage
+	if path := sumatraImagePath(t); path != "" {
+		images = append(images, &renderedImage{Name: sumatraImageName, Path: path})
+	}
+	for _, res := range t.OracleResults {
+		if res.ImagePath != "" && strings.HasSuffix(res.ImagePath, ".png") {
+			images = append(images, &renderedImage{Name: res.Name, Path: res.ImagePath})
+		}
+	}
+	differs := map[[2]int]bool{}
+	var diffNotes []string
+	sumatraDiffers := false
+	for i := 0; i < len(images); i++ {
+		for j := i + 1; j < len(images); j++ {
+			r1, r2 := images[i], images[j]
+			diff, err := compareImages(r1.Path, r2.Path)
+			if err != nil {
+				// e.g. rendered at different resolution, not a vote
+				note := fmt.Sprintf("images from %s and %s can't be compared: %s", r1.Name, r2.Name, err)
+				t.OracleNotes = append(t.OracleNotes, note)
+				continue
+			}
+			differs[[2]int{i, j}] = diff > flgOracleMaxDiff
+			if diff > flgOracleMaxDiff {
+				diffNotes = append(diffNotes, fmt.Sprintf("%s and %s differ in %.2f%% of pixels", r1.Name, r2.Name, diff))
+				sumatraDiffers = sumatraDiffers || r1.Name == sumatraImageName
+			}
+		}
+	}
+	if len(diffNotes) == 0 {
+		return ""
+	}
+	pageNo := testPageNo(t)
+	outlier := findImageOutlier(len(images), differs)
+	switch {
+	case outlier >= 0 && images[outlier].Name == sumatraImageName:
+		return fmt.Sprintf("page %d rendered by SumatraPDF differs from oracles that agree with each other: %s", pageNo, strings.Join(diffNotes, ", "))
+	case outlier >= 0:
+		note := fmt.Sprintf("image of page %d from oracle %s differs from others, assuming it's wrong: %s", pageNo, images[outlier].Name, strings.Join(diffNotes, ", "))
+		t.OracleNotes = append(t.OracleNotes, note)
+		return ""
+	case sumatraDiffers:
+		return fmt.Sprintf("disagreement about image of page %d: %s", pageNo, strings.Join(diffNotes, ", "))
+	}
+	// only oracles differ and without SumatraPDF's image we can't tell
+	// which one is wrong
+	for _, s := range diffNotes {
+		t.OracleNotes = append(t.OracleNotes, "images from "+s)
+	}
+	return ""
+}
+
+func runOracles(t *Test) {
+	for _, name := range t.Oracles {
+		var res *OracleResult
+		switch name {
+		case "gs":
+			res = runGsOracle(t)
+		case "pdfium":
+			res = runPdfiumOracle(t)
+		case "pdftotext":
+			res = runPdftotextOracle(t)
+		}
+		if res != nil {
+			t.OracleResults = append(t.OracleResults, res)
+			if res.ImagePath != "" {
+				t.Artifacts = append(t.Artifacts, res.ImagePath)
+			}
+		}
+	}
+	t.OracleMismatch = checkOracleResults(t)
+	if t.OracleMismatch == "" {
+		t.OracleMismatch = checkOracleText(t)
+	}
+	suppressOracleMismatch(t)
+}
+
+func dumpOracleResults(t *Test) {
+	for _, note := range t.OracleNotes {
+		fmt.Printf("note: %s\n", note)
+	}
+	for _, res := range t.OracleResults {
+		if res.Error == nil && isTextOracle(res.Name) {
+			fmt.Printf("oracle '%s': extracted text:\n-----\n%s\n-----\n", res.Name, strings.TrimSpace(res.Output))
+			continue
+		}
+		if res.Error == nil {
+			fmt.Printf("oracle '%s': rendered to '%s'\n", res.Name, res.ImagePath)
+			continue
+		}
+		fmt.Printf("oracle '%s': failed with '%s'\n", res.Name, res.Error)
+		if res.Output != "" {
+			fmt.Printf("-----\n%s\n-----\n", res.Output)
+		}
+	}
+}
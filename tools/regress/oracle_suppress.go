@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+Oracles sometimes legitimately disagree with SumatraPDF (e.g. a broken
+file that mupdf repairs and Ghostscript refuses to render). Those are
+listed in a suppression file, one per line:
+
+# sha1 page reason
+6fd389a36816f1ab490d46c0c7a6b34b678f72bf 2 gs can't repair broken xref
+
+Page can be * to suppress all pages of a file.
+*/
+
+// OracleSuppression describes a known, benign oracle disagreement
+type OracleSuppression struct {
+	Sha1Hex string
+	PageNo  int // 0 means all pages
+	Reason  string
+}
+
+var (
+	oracleSuppressions []*OracleSuppression
+)
+
+func parseOracleSuppressionsMust(path string) []*OracleSuppression {
+	var res []*OracleSuppression
+	d, err := ioutil.ReadFile(longPath(path))
+	fatalIfErr(err)
+	for i, l := range toTrimmedLines(d) {
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		// sha1 and page can be separated by any whitespace, the rest is reason
+		parts := strings.Fields(l)
+		panicIf(len(parts) < 3, "%s:%d: invalid line '%s', expected: sha1 page reason\n", path, i+1, l)
+		reason := strings.TrimSpace(strings.TrimPrefix(l, parts[0]))
+		reason = strings.TrimSpace(strings.TrimPrefix(reason, parts[1]))
+		s := &OracleSuppression{
+			Sha1Hex: parts[0],
+			Reason:  reason,
+		}
+		panicIf(len(s.Sha1Hex) != 40, "%s:%d: len(sha1) != 40 (%d)\n", path, i+1, len(s.Sha1Hex))
+		if parts[1] != "*" {
+			s.PageNo, err = strconv.Atoi(parts[1])
+			panicIf(err != nil || s.PageNo < 1, "%s:%d: invalid page '%s'\n", path, i+1, parts[1])
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+func loadOracleSuppressions(path string) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(longPath(path)); os.IsNotExist(err) {
+		return
+	}
+	oracleSuppressions = parseOracleSuppressionsMust(path)
+	fmt.Printf("%d oracle suppressions\n", len(oracleSuppressions))
+}
+
+func findOracleSuppression(sha1Hex string, pageNo int) *OracleSuppression {
+	for _, s := range oracleSuppressions {
+		if s.Sha1Hex != sha1Hex {
+			continue
+		}
+		if s.PageNo == 0 || s.PageNo == pageNo {
+			return s
+		}
+	}
+	return nil
+}
+
+// suppressOracleMismatch turns a known disagreement into a note
+func suppressOracleMismatch(t *Test) {
+	if t.OracleMismatch == "" {
+		return
+	}
+	s := findOracleSuppression(t.FileSha1Hex, testPageNo(t))
+	if s == nil {
+		return
+	}
+	note := fmt.Sprintf("suppressed: %s (%s)", t.OracleMismatch, s.Reason)
+	t.OracleNotes = append(t.OracleNotes, note)
+	t.OracleMismatch = ""
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseOracleSuppressions(t *testing.T) {
+	sha1Hex := "6fd389a36816f1ab490d46c0c7a6b34b678f72bf"
+	tests := []struct {
+		line   string
+		pageNo int
+		reason string
+	}{
+		{sha1Hex + " 2 gs can't repair broken xref", 2, "gs can't repair broken xref"},
+		{sha1Hex + "  2   gs can't  repair", 2, "gs can't  repair"},
+		{sha1Hex + "\t*\tbroken fonts ", 0, "broken fonts"},
+	}
+	for _, tc := range tests {
+		path := filepath.Join(t.TempDir(), "oracle-suppressions.txt")
+		err := ioutil.WriteFile(path, []byte("# sha1 page reason\n"+tc.line+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := parseOracleSuppressionsMust(path)
+		if len(res) != 1 {
+			t.Errorf("'%s': got %d suppressions, expected 1", tc.line, len(res))
+			continue
+		}
+		s := res[0]
+		if s.Sha1Hex != sha1Hex || s.PageNo != tc.pageNo || s.Reason != tc.reason {
+			t.Errorf("'%s': got %s %d '%s', expected %s %d '%s'", tc.line, s.Sha1Hex, s.PageNo, s.Reason, sha1Hex, tc.pageNo, tc.reason)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFindImageOutlier(t *testing.T) {
+	tests := []struct {
+		n       int
+		differs map[[2]int]bool
+		exp     int
+	}{
+		// all agree
+		{3, map[[2]int]bool{{0, 1}: false, {0, 2}: false, {1, 2}: false}, -1},
+		// 0 differs from 1 and 2 which agree
+		{3, map[[2]int]bool{{0, 1}: true, {0, 2}: true, {1, 2}: false}, 0},
+		// 2 differs from 0 and 1 which agree
+		{3, map[[2]int]bool{{0, 1}: false, {0, 2}: true, {1, 2}: true}, 2},
+		// all differ, no majority
+		{3, map[[2]int]bool{{0, 1}: true, {0, 2}: true, {1, 2}: true}, -1},
+		// 1 vs. 1 is a tie
+		{2, map[[2]int]bool{{0, 1}: true}, -1},
+		// 1 and 2 couldn't be compared so we don't know if they agree
+		{3, map[[2]int]bool{{0, 1}: true, {0, 2}: true}, -1},
+	}
+	for i, tc := range tests {
+		got := findImageOutlier(tc.n, tc.differs)
+		if got != tc.exp {
+			t.Errorf("%d: got %d, expected %d", i, got, tc.exp)
+		}
+	}
+}
+
+func TestCheckOracleResultsVote(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		sumatraOutput string
+		gsErr         error
+		pdfiumErr     error
+		expPrefix     string
+	}{
+		{"rendering page 1", nil, nil, ""},
+		// oracles are split, SumatraPDF doesn't decide
+		{"rendering page 1", nil, errFailed, "disagreement about page 1: oracle gs rendered it, oracle pdfium failed"},
+		{"failed to render page 1", nil, nil, "oracle gs, pdfium rendered page 1 but SumatraPDF failed"},
+		{"failed to render page 1", errFailed, errFailed, ""},
+		{"failed to render page 1", nil, errFailed, "disagreement about page 1"},
+	}
+	for i, tc := range tests {
+		test := &Test{Output: tc.sumatraOutput}
+		setCmd(test, "SumatraPDF.exe -render 1 $file")
+		test.OracleResults = []*OracleResult{
+			{Name: "gs", Error: tc.gsErr},
+			{Name: "pdfium", Error: tc.pdfiumErr},
+		}
+		got := checkOracleResults(test)
+		if !strings.HasPrefix(got, tc.expPrefix) || (tc.expPrefix == "") != (got == "") {
+			t.Errorf("%d: got '%s', expected '%s'", i, got, tc.expPrefix)
+		}
+	}
+}
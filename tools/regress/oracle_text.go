@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+pdftotext oracle cross-checks text extracted by SumatraPDF -extract-text
+with text extracted by Poppler's pdftotext. Text extraction of different
+engines never matches exactly (spacing, line breaks, ligatures) so we
+compare bags of tokens and only flag a test when similarity is below
+-text-min-similarity. Only tests with -extract-text can use it, output of
+other commands (e.g. log of -render) is not text of the document.
+If pdftotext fails the test fails too (suppress it in -oracle-suppressions
+if it's a known pdftotext problem).
+*/
+
+// matches "text on page 1: 'f0 9d 91 93 5f '"
+var rxSumatraPageText = regexp.MustCompile(`text on page (\d+): '([0-9a-f ]*)'`)
+
+// parseSumatraText decodes output of SumatraPDF -extract-text
+// which prints text as hex bytes, with '\n' replaced by '_'
+func parseSumatraText(s string) (string, int, int) {
+	var res []string
+	firstPage, lastPage := 0, 0
+	for _, m := range rxSumatraPageText.FindAllStringSubmatch(s, -1) {
+		pageNo, _ := strconv.Atoi(m[1])
+		if firstPage == 0 || pageNo < firstPage {
+			firstPage = pageNo
+		}
+		if pageNo > lastPage {
+			lastPage = pageNo
+		}
+		d, err := hex.DecodeString(strings.Replace(m[2], " ", "", -1))
+		if err != nil {
+			continue
+		}
+		res = append(res, string(d))
+	}
+	return strings.Join(res, "\n"), firstPage, lastPage
+}
+
+func isTextOracle(name string) bool {
+	return name == "pdftotext"
+}
+
+// textOracleMisuse returns why the test can't use the text oracle, "" if it can
+func textOracleMisuse(t *Test) string {
+	for _, name := range t.Oracles {
+		if isTextOracle(name) && !hasExtractTextArg(t) {
+			return fmt.Sprintf("Oracle: %s needs Cmd: with -extract-text, got '%s'", name, t.CmdUnparsed)
+		}
+	}
+	return ""
+}
+
+func hasExtractTextArg(t *Test) bool {
+	for _, arg := range t.CmdArgs {
+		if arg == "-extract-text" {
+			return true
+		}
+	}
+	return false
+}
+
+func runPdftotextOracle(t *Test) *OracleResult {
+	if !isOracleAvailable("pdftotext", flgPdftotextPath) {
+		return nil
+	}
+	_, firstPage, lastPage := parseSumatraText(t.Output)
+	if firstPage == 0 {
+		firstPage, lastPage = 1, 1
+	}
+	args := []string{
+		"-f", strconv.Itoa(firstPage),
+		"-l", strconv.Itoa(lastPage),
+		"-enc", "UTF-8",
+		t.FilePath,
+		"-",
+	}
+	cmd := exec.Command(flgPdftotextPath, args...)
+	fmt.Printf("Running oracle: %s\n", cmdToStrLong(cmd))
+	res := &OracleResult{
+		Name: "pdftotext",
+	}
+	out, err := cmd.Output()
+	res.Output = string(out)
+	res.Error = err
+	return res
+}
+
+// SumatraPDF replaces '\n' with '_' so we have to treat it as a separator
+func textToTokens(s string) map[string]int {
+	res := map[string]int{}
+	s = strings.Replace(s, "_", " ", -1)
+	for _, tok := range strings.Fields(s) {
+		res[tok]++
+	}
+	return res
+}
+
+// textSimilarity returns Dice coefficient of bags of tokens, in 0...1 range
+func textSimilarity(s1, s2 string) float64 {
+	toks1 := textToTokens(s1)
+	toks2 := textToTokens(s2)
+	n1, n2, nCommon := 0, 0, 0
+	for tok, c1 := range toks1 {
+		n1 += c1
+		c2 := toks2[tok]
+		if c2 < c1 {
+			nCommon += c2
+		} else {
+			nCommon += c1
+		}
+	}
+	for _, c2 := range toks2 {
+		n2 += c2
+	}
+	if n1+n2 == 0 {
+		return 1
+	}
+	return float64(2*nCommon) / float64(n1+n2)
+}
+
+// checkOracleText fails the test if the oracle failed so that e.g. crashing
+// pdftotext doesn't look like it agrees with SumatraPDF
+func checkOracleText(t *Test) string {
+	for _, res := range t.OracleResults {
+		if !isTextOracle(res.Name) {
+			continue
+		}
+		if res.Error != nil {
+			return fmt.Sprintf("SumatraPDF extracted text but oracle %s failed with '%s'", res.Name, res.Error)
+		}
+		text, _, _ := parseSumatraText(t.Output)
+		sim := textSimilarity(text, res.Output)
+		if sim < flgTextMinSimilarity {
+			return fmt.Sprintf("text extracted by SumatraPDF and %s differs, similarity: %.2f (min %.2f)", res.Name, sim, flgTextMinSimilarity)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTextOracleMisuse(t *testing.T) {
+	tests := []struct {
+		cmd     string
+		oracles []string
+		ok      bool
+	}{
+		{"SumatraPDF.exe -extract-text 1 $file", []string{"pdftotext"}, true},
+		{"SumatraPDF.exe -render 1 -zoom 5 $file", []string{"pdftotext"}, false},
+		{"SumatraPDF.exe -render 1 -zoom 5 $file", []string{"gs", "pdfium"}, true},
+		{"SumatraPDF.exe -render 1 $file", nil, true},
+	}
+	for _, tc := range tests {
+		test := &Test{Oracles: tc.oracles}
+		setCmd(test, tc.cmd)
+		msg := textOracleMisuse(test)
+		if (msg == "") != tc.ok {
+			t.Errorf("'%s' with oracles %v: got '%s', expected ok: %v", tc.cmd, tc.oracles, msg, tc.ok)
+		}
+	}
+}
+
+func TestCheckOracleText(t *testing.T) {
+	defer func(v float64) { flgTextMinSimilarity = v }(flgTextMinSimilarity)
+	flgTextMinSimilarity = 0.8
+	tests := []struct {
+		res *OracleResult
+		ok  bool
+	}{
+		{&OracleResult{Name: "pdftotext", Output: "hello"}, true},
+		{&OracleResult{Name: "pdftotext", Output: "something else"}, false},
+		{&OracleResult{Name: "pdftotext", Error: errors.New("exit status 1")}, false},
+		{&OracleResult{Name: "gs", Error: errors.New("exit status 1")}, true},
+	}
+	for _, tc := range tests {
+		test := &Test{
+			Output:        "text on page 1: '68 65 6c 6c 6f '",
+			OracleResults: []*OracleResult{tc.res},
+		}
+		msg := checkOracleText(test)
+		if (msg == "") != tc.ok {
+			t.Errorf("%s with output '%s' and error %v: got '%s', expected ok: %v", tc.res.Name, tc.res.Output, tc.res.Error, msg, tc.ok)
+		}
+	}
+}
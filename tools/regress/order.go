@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+By default tests that are likely to fail run first so that -failfast and
+people watching the output learn about breakage early. Likelihood comes
+from the last historyRunsForOrder runs in -history:
+- failing in the latest run
+- failures, recent ones count more
+- flakiness i.e. how often the result flipped between runs
+- new tests that don't have history yet
+-order file runs tests in the order of the tests file.
+*/
+
+const historyRunsForOrder = 20
+
+// loadRecentHistory returns up to n latest runs, newest first
+func loadRecentHistory(n int) []*RunResults {
+	var res []*RunResults
+	for _, id := range loadHistoryRunIDs() {
+		if len(res) >= n {
+			break
+		}
+		run, err := loadHistoryRun(id)
+		if err != nil {
+			continue
+		}
+		res = append(res, run)
+	}
+	return res
+}
+
+// failureScores returns score by test id, bigger means more likely to fail
+func failureScores(tests []*Test, runs []*RunResults) map[string]float64 {
+	// results of a test in runs, newest first
+	results := map[string][]bool{}
+	for _, run := range runs {
+		for _, r := range run.Tests {
+			id := resultID(r)
+			results[id] = append(results[id], r.Failed)
+		}
+	}
+	res := map[string]float64{}
+	for _, t := range tests {
+		id := testID(t)
+		failed, ok := results[id]
+		if !ok {
+			res[id] = 50
+			continue
+		}
+		score := 0.0
+		if failed[0] {
+			score += 1000
+		}
+		for i, f := range failed {
+			if f {
+				score += 100 / float64(i+1)
+			}
+			if i > 0 && f != failed[i-1] {
+				score += 10
+			}
+		}
+		res[id] = score
+	}
+	return res
+}
+
+// testRunOrder returns tests in the order in which to run them
+func testRunOrder(tests []*Test) []*Test {
+	res := append([]*Test{}, tests...)
+	if flgOrder == "file" || flgHistory == "" {
+		return res
+	}
+	panicIf(flgOrder != "likely-failing", "invalid -order '%s', must be likely-failing or file\n", flgOrder)
+	runs := loadRecentHistory(historyRunsForOrder)
+	if len(runs) == 0 {
+		return res
+	}
+	scores := failureScores(tests, runs)
+	sort.SliceStable(res, func(i, j int) bool {
+		return scores[testID(res[i])] > scores[testID(res[j])]
+	})
+	nLikely := 0
+	for _, t := range res {
+		if scores[testID(t)] > 0 {
+			nLikely++
+		}
+	}
+	fmt.Printf("running %d tests that failed recently, are flaky or new first\n", nLikely)
+	return res
+}
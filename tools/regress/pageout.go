@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+/*
+Commands that dump many pages (e.g. -extract-text 1-100) produce
+output like:
+
+text on page 1: '...'
+text on page 2: '...'
+
+Out[2]: text on page 2: '...'
+
+checks only the part of output for page 2 so that a failure says
+which page differs instead of showing a diff of the whole document.
+A page starts with a line "text on page N" or "rendering page N".
+*/
+
+var (
+	rxPageOutField = regexp.MustCompile(`^out\[(\d+)\]$`)
+	rxPageStart    = regexp.MustCompile(`^(?:text on|rendering) page (\d+)\b`)
+)
+
+// parsePageOutField returns page number for Out[N]: field name
+func parsePageOutField(name string) (int, bool) {
+	m := rxPageOutField.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	return n, err == nil && n > 0
+}
+
+// splitOutputByPage returns output for each page
+func splitOutputByPage(s string) map[int]string {
+	res := map[int]string{}
+	pageNo := 0
+	for _, l := range toTrimmedLines([]byte(s)) {
+		if m := rxPageStart.FindStringSubmatch(l); m != nil {
+			pageNo, _ = strconv.Atoi(m[1])
+		}
+		if pageNo == 0 {
+			continue
+		}
+		if res[pageNo] != "" {
+			res[pageNo] += "\n"
+		}
+		res[pageNo] += l
+	}
+	return res
+}
+
+func sortedPageNos(m map[int]string) []int {
+	var res []int
+	for pageNo := range m {
+		res = append(res, pageNo)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// checkPageOutputs returns a reason for each page that differs
+func checkPageOutputs(t *Test) []string {
+	if len(t.ExpectedPages) == 0 {
+		return nil
+	}
+	var res []string
+	pages := splitOutputByPage(normalizePaths(t.Output, t))
+	for _, pageNo := range sortedPageNos(t.ExpectedPages) {
+		expected := normalizePaths(substVars(t.ExpectedPages[pageNo], t), t)
+		got, ok := pages[pageNo]
+		if !ok {
+			res = append(res, fmt.Sprintf("page %d missing in output", pageNo))
+			continue
+		}
+		if !isOutputEqual(got, expected) {
+			res = append(res, fmt.Sprintf("page %d differs:\n%s", pageNo, diffStrings(expected, got)))
+		}
+	}
+	return res
+}
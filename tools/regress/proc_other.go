@@ -0,0 +1,37 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// isCrashError returns true if process was killed by a signal like SIGSEGV
+func isCrashError(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled()
+}
+
+// peakMemoryKB returns max resident set size of the process
+func peakMemoryKB(ps *os.ProcessState) int64 {
+	if ps == nil {
+		return 0
+	}
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	if runtime.GOOS == "darwin" {
+		// in bytes on mac
+		return int64(ru.Maxrss) / 1024
+	}
+	return int64(ru.Maxrss)
+}
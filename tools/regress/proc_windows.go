@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// isCrashError returns true if process died because of unhandled
+// exception, which shows up as NTSTATUS exit code like
+// 0xC0000005 (access violation)
+func isCrashError(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	return uint32(exitErr.ExitCode()) >= 0xC0000000
+}
+
+// peakMemoryKB returns peak memory use of the process. We can't get it
+// after the process exited so it's 0 on Windows, we get it from the job
+// object instead (see sandbox_windows.go)
+func peakMemoryKB(ps *os.ProcessState) int64 {
+	return 0
+}
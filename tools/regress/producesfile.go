@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+Exporters and save-as code paths create files. To test them a test can
+have one or more:
+
+ProducesFile: $dir/out.png 2ef7bde608ce5404e97d5f042f95f89f1c232871
+
+The path can use variables like Cmd: ($dir is the test's scratch
+directory, relative paths are relative to it). The test fails if the file
+doesn't exist after Cmd: finished or its sha1 is different. It also fails
+if Cmd: created files in the scratch directory that are not listed in
+ProducesFile: (settings, TMP etc. of the test are not checked here).
+*/
+
+// ProducedFile is from ProducesFile: path sha1
+type ProducedFile struct {
+	Path    string
+	Sha1Hex string
+}
+
+const producedFileMismatchPrefix = "produced file: "
+
+var (
+	// sub-directories of test's scratch dir that we create and don't
+	// consider files produced by the test
+	scratchPrivateDirs = []string{"appdata", "tmp", "diag", "fontlog"}
+)
+
+func parseProducesFile(pos string, val string) *ProducedFile {
+	idx := strings.LastIndexAny(val, " \t")
+	ok := idx > 0
+	var path, sha1Hex string
+	if ok {
+		path = strings.TrimSpace(val[:idx])
+		sha1Hex = strings.ToLower(strings.TrimSpace(val[idx+1:]))
+		ok = path != "" && len(sha1Hex) == 40
+	}
+	panicIf(!ok, "%s: ProducesFile: must be 'path sha1', got '%s'\n", pos, val)
+	return &ProducedFile{Path: path, Sha1Hex: sha1Hex}
+}
+
+func producedFilePath(t *Test, pf *ProducedFile) string {
+	path := substVars(pf.Path, t)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(t.TempDir, path)
+	}
+	return filepath.Clean(path)
+}
+
+func isScratchPrivateDir(rel string) bool {
+	first := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+	for _, s := range scratchPrivateDirs {
+		if first == s {
+			return true
+		}
+	}
+	return false
+}
+
+// listScratchFiles returns paths relative to test's scratch dir, nil if the
+// test doesn't check produced files
+func listScratchFiles(t *Test) map[string]bool {
+	if len(t.ProducesFiles) == 0 {
+		return nil
+	}
+	res := map[string]bool{}
+	dir := longPath(t.TempDir)
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && !isScratchPrivateDir(rel) {
+			res[rel] = true
+		}
+		return nil
+	})
+	return res
+}
+
+// collectNewScratchFiles must be called after the test process exited
+func collectNewScratchFiles(t *Test, before map[string]bool) {
+	if before == nil {
+		return
+	}
+	t.NewScratchFiles = nil
+	for rel := range listScratchFiles(t) {
+		if !before[rel] {
+			t.NewScratchFiles = append(t.NewScratchFiles, rel)
+		}
+	}
+	sort.Strings(t.NewScratchFiles)
+}
+
+// checkProducedFiles must be called before the test is unstaged
+func checkProducedFiles(t *Test) []string {
+	var res []string
+	expected := map[string]bool{}
+	for _, pf := range t.ProducesFiles {
+		path := producedFilePath(t, pf)
+		if rel, err := filepath.Rel(t.TempDir, path); err == nil {
+			expected[rel] = true
+		}
+		if !fileExists(path) {
+			res = append(res, fmt.Sprintf("%s'%s' was not created", producedFileMismatchPrefix, path))
+			continue
+		}
+		sha1Hex, err := sha1HexOfFile(path)
+		if err != nil {
+			res = append(res, fmt.Sprintf("%sfailed to read '%s': %s", producedFileMismatchPrefix, path, err))
+			continue
+		}
+		if sha1Hex != pf.Sha1Hex {
+			res = append(res, fmt.Sprintf("%s'%s' has sha1 %s, expected %s", producedFileMismatchPrefix, path, sha1Hex, pf.Sha1Hex))
+		}
+	}
+	for _, rel := range t.NewScratchFiles {
+		if !expected[rel] {
+			res = append(res, fmt.Sprintf("%sunexpected file '%s'", producedFileMismatchPrefix, filepath.Join("$dir", rel)))
+		}
+	}
+	return res
+}
+
+func hasProducedFileMismatch(t *Test) bool {
+	for _, s := range t.OutputMismatches {
+		if strings.HasPrefix(s, producedFileMismatchPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func producesFilesForKey(t *Test) string {
+	var a []string
+	for _, pf := range t.ProducesFiles {
+		a = append(a, pf.Path+" "+pf.Sha1Hex)
+	}
+	return strings.Join(a, "\n")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sumatrapdfreader/sumatrapdf/tools/regress/regresslib"
+)
+
+/*
+Tests can also be defined in Go code with regresslib.Register (see
+regresslib/regresslib.go). Other packages in the repo that register tests
+are linked into regress with a blank import here, e.g.:
+
+import _ "github.com/sumatrapdfreader/sumatrapdf/tools/regress/suites/zoom"
+
+Registered tests are added after tests from -tests file (only that file,
+not e.g. tests of selftest). We convert them to lines of tests.txt format
+so that they have the same fields and checks as tests in the file. Their
+position is the place of Register call. They can't be updated by triage.
+*/
+
+func registeredTestLines(rt *regresslib.Test) []TestLine {
+	var lines []string
+	add := func(name string, vals ...string) {
+		for _, v := range vals {
+			if v != "" {
+				lines = append(lines, name+": "+v)
+			}
+		}
+	}
+	add("Name", rt.Name)
+	add("Url", rt.URL)
+	add("Sha1", rt.Sha1)
+	add("Cmd", rt.Cmd)
+	add("Out", rt.Out)
+	add("Err", rt.Err)
+	add("Assert", rt.Assert...)
+	if len(rt.Tags) > 0 {
+		add("Tags", strings.Join(rt.Tags, ", "))
+	}
+	for _, l := range rt.Fields {
+		lines = append(lines, strings.TrimSpace(l))
+	}
+	var res []TestLine
+	for _, l := range lines {
+		res = append(res, TestLine{Text: l, LineNo: rt.LineNo})
+	}
+	return res
+}
+
+// registeredTestsMust parses tests registered in Go code, each time anew
+// so that a test run can't change them for the next parse
+func registeredTestsMust() []*Test {
+	var res []*Test
+	for _, rt := range regresslib.Registered() {
+		pos := fmt.Sprintf("%s:%d", rt.Path, rt.LineNo)
+		lines := registeredTestLines(&rt)
+		panicIf(len(lines) == 0, "%s: empty registered test\n", pos)
+		t, _ := parseTest(rt.Path, lines)
+		panicIf(t == nil || t.fixture != nil, "%s: only tests can be registered\n", pos)
+		t.registered = true
+		res = append(res, t)
+	}
+	if len(res) > 0 {
+		fmt.Printf("%d tests registered in Go code\n", len(res))
+	}
+	return res
+}
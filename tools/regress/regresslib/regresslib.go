@@ -0,0 +1,73 @@
+// Package regresslib lets Go code define tests for tools/regress, which is
+// better for generated suites (e.g. all combinations of flags or all
+// formats) than writing them out in tests.txt.
+package regresslib
+
+import (
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+/*
+A package registers tests in init():
+
+func init() {
+	for _, zoom := range []string{"fit page", "100", "800"} {
+		regresslib.Register(regresslib.Test{
+			Name:   "zoom " + zoom,
+			URL:    "https://example.com/f.pdf",
+			Sha1:   "735c700545cf48bac8665768739e10e3a950ba33",
+			Cmd:    "SumatraPDF.exe -render 1 -zoom " + zoom + " $file",
+			Assert: []string{`contains("rendering page 1")`},
+			Fields: []string{"Timeout: 30s"},
+		})
+	}
+}
+
+regress runs tests registered by packages linked into it, a package with
+tests is added with a blank import in tools/regress/registry.go.
+*/
+
+// Test is a test defined in Go code. Fields mean the same as fields of
+// a test in tests.txt.
+type Test struct {
+	Name   string
+	URL    string
+	Sha1   string
+	Cmd    string
+	Out    string // can have multiple lines
+	Err    string
+	Assert []string
+	Tags   []string
+	// other fields of tests.txt, e.g. "Env: A=1" or "Out[2]: rendering page 2"
+	Fields []string
+
+	// where Register was called, set by Register
+	Path   string
+	LineNo int
+}
+
+var (
+	mu         sync.Mutex
+	registered []Test
+)
+
+// Register adds a test, it should be called from init()
+func Register(t Test) {
+	t.Path = "registry"
+	if _, file, line, ok := runtime.Caller(1); ok {
+		t.Path = filepath.Base(file)
+		t.LineNo = line
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	registered = append(registered, t)
+}
+
+// Registered returns registered tests in the order of registration
+func Registered() []Test {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Test(nil), registered...)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+)
+
+/*
+Output of render commands is made of lines like:
+
+rendering page 1 for 'foo.pdf', zoom: 5.00
+pageload     1: 3.21 ms
+pagerender   1: 12.34 ms
+page count: 3
+
+We parse them into per-page values so that assertions can check them one
+by one instead of comparing whole lines (see assert.go):
+
+Assert: zoom(1) == 5 && renderMs(1) < 100 && pageCount() == 3
+
+If the binary prints size of a page ("page 1 size: 612x792" or
+", size: 612x792" at the end of "rendering page" line),
+pageWidth(1) and pageHeight(1) return it.
+*/
+
+// RenderedPage has values parsed from output for one page
+type RenderedPage struct {
+	PageNo   int
+	Zoom     float64
+	Width    float64
+	Height   float64
+	LoadMs   float64
+	RenderMs float64
+}
+
+// RenderLog is parsed output of a render command
+type RenderLog struct {
+	Pages     []*RenderedPage // in order of first appearance
+	PageCount int             // -1 if not printed
+}
+
+var (
+	rxRenderingPage = regexp.MustCompile(`(?m)^rendering page (\d+) for '.*', zoom: ([0-9.]+)(?:, size: ([0-9.]+)x([0-9.]+))?\s*$`)
+	rxPageLoad      = regexp.MustCompile(`(?m)^pageload\s+(\d+):\s+([0-9.]+) ms\s*$`)
+	rxPageSize      = regexp.MustCompile(`(?m)^page (\d+) size: ([0-9.]+)x([0-9.]+)\s*$`)
+	rxPageCount     = regexp.MustCompile(`(?m)^page count: (\d+)\s*$`)
+)
+
+func newRenderedPage(pageNo int) *RenderedPage {
+	nan := math.NaN()
+	return &RenderedPage{PageNo: pageNo, Zoom: nan, Width: nan, Height: nan, LoadMs: nan, RenderMs: nan}
+}
+
+func (l *RenderLog) page(pageNo int) *RenderedPage {
+	for _, p := range l.Pages {
+		if p.PageNo == pageNo {
+			return p
+		}
+	}
+	p := newRenderedPage(pageNo)
+	l.Pages = append(l.Pages, p)
+	return p
+}
+
+// findPage returns a page with only NaN values if it's not in the output
+func (l *RenderLog) findPage(pageNo int) *RenderedPage {
+	for _, p := range l.Pages {
+		if p.PageNo == pageNo {
+			return p
+		}
+	}
+	return newRenderedPage(pageNo)
+}
+
+// parseRenderLogNumbers returns matches with numbers, skipping lines with
+// invalid numbers e.g. "1.2.3"
+func parseRenderLogNumbers(rx *regexp.Regexp, s string) [][]float64 {
+	var res [][]float64
+	for _, m := range rx.FindAllStringSubmatch(s, -1) {
+		var nums []float64
+		for _, v := range m[1:] {
+			if v == "" {
+				nums = append(nums, math.NaN())
+				continue
+			}
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				nums = nil
+				break
+			}
+			nums = append(nums, n)
+		}
+		if nums != nil {
+			res = append(res, nums)
+		}
+	}
+	return res
+}
+
+func parseRenderLog(s string) *RenderLog {
+	res := &RenderLog{PageCount: -1}
+	for _, m := range parseRenderLogNumbers(rxRenderingPage, s) {
+		p := res.page(int(m[0]))
+		p.Zoom = m[1]
+		if !math.IsNaN(m[2]) {
+			p.Width, p.Height = m[2], m[3]
+		}
+	}
+	for _, m := range parseRenderLogNumbers(rxPageSize, s) {
+		p := res.page(int(m[0]))
+		p.Width, p.Height = m[1], m[2]
+	}
+	for _, m := range parseRenderLogNumbers(rxPageLoad, s) {
+		res.page(int(m[0])).LoadMs = m[1]
+	}
+	for _, m := range parseRenderLogNumbers(rxRenderTiming, s) {
+		res.page(int(m[0])).RenderMs = m[1]
+	}
+	if m := parseRenderLogNumbers(rxPageCount, s); len(m) > 0 {
+		res.PageCount = int(m[len(m)-1][0])
+	}
+	return res
+}
+
+// testRenderLog parses output of the test once
+func testRenderLog(t *Test) *RenderLog {
+	if t.renderLog == nil || t.renderLogOutput != t.Output {
+		t.renderLog = parseRenderLog(t.Output)
+		t.renderLogOutput = t.Output
+	}
+	return t.renderLog
+}
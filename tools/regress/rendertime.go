@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+/*
+With -bench SumatraPDF prints how long it took to render each page:
+
+pagerender   1: 12.34 ms
+
+MaxRenderMs: 500 fails the test if rendering any page took longer.
+It's for documents that used to render pathologically slow.
+*/
+
+// RenderTiming is time it took to render a page
+type RenderTiming struct {
+	PageNo int
+	Ms     float64
+}
+
+var rxRenderTiming = regexp.MustCompile(`(?m)^pagerender\s+(\d+):\s+([0-9.]+) ms\s*$`)
+
+func parseRenderTimings(s string) []RenderTiming {
+	var res []RenderTiming
+	for _, m := range rxRenderTiming.FindAllStringSubmatch(s, -1) {
+		pageNo, err1 := strconv.Atoi(m[1])
+		ms, err2 := strconv.ParseFloat(m[2], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		res = append(res, RenderTiming{PageNo: pageNo, Ms: ms})
+	}
+	return res
+}
+
+func parseMaxRenderMs(pos string, val string) float64 {
+	ms, err := strconv.ParseFloat(val, 64)
+	panicIf(err != nil || ms <= 0, "%s: MaxRenderMs: must be a number > 0, got '%s'\n", pos, val)
+	return ms
+}
+
+// checkRenderTimings returns a reason for each page that rendered too slow
+func checkRenderTimings(t *Test) []string {
+	if t.MaxRenderMs == 0 {
+		return nil
+	}
+	timings := parseRenderTimings(t.Output)
+	if len(timings) == 0 {
+		return []string{"MaxRenderMs: no render timings in output (use -bench)"}
+	}
+	var res []string
+	for _, rt := range timings {
+		if rt.Ms > t.MaxRenderMs {
+			res = append(res, fmt.Sprintf("rendering page %d took %.2f ms, more than MaxRenderMs: %g", rt.PageNo, rt.Ms, t.MaxRenderMs))
+		}
+	}
+	return res
+}
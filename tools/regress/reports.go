@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+Each run saves its reports in a new directory of -reports-dir so that
+reports of previous runs are not overwritten:
+
+out/regress/reports/
+  20240131-020000-abc1234/
+    index.json   - ReportIndex, lists the files below and artifacts
+    results.json
+    results.csv
+    junit.xml
+    report.html  - the run page of regress serve
+  latest         - symlink to the newest run dir (if we can create symlinks)
+  latest.txt     - name of the newest run dir, for when we can't
+
+Artifacts stay where tests saved them, index.json has their paths
+relative to the run dir.
+*/
+
+const reportsIndexName = "index.json"
+
+// ReportArtifact is an artifact of a failed test
+type ReportArtifact struct {
+	TestID   string
+	TestName string
+	Path     string
+}
+
+// ReportIndex describes files of a run in reports dir
+type ReportIndex struct {
+	ID        string
+	GitSha    string `json:",omitempty"`
+	Started   time.Time
+	Tests     int
+	Failed    int
+	Files     map[string]string // format (json, csv, junit, html) => file name
+	Artifacts []*ReportArtifact `json:",omitempty"`
+}
+
+func writeRunReports(dir string, tests []*Test, index *ReportIndex) error {
+	run := testsToRunResults(tests)
+	index.Tests = len(run.Tests)
+	index.Failed = countFailed(run)
+	index.Files = map[string]string{}
+
+	name := "results.json"
+	if err := saveRunResults(filepath.Join(dir, name), run); err != nil {
+		return err
+	}
+	index.Files["json"] = name
+	name = "results.csv"
+	if err := writeResultsCSV(filepath.Join(dir, name), tests); err != nil {
+		return err
+	}
+	index.Files["csv"] = name
+	name = "junit.xml"
+	if err := writeResultsJUnit(filepath.Join(dir, name), tests); err != nil {
+		return err
+	}
+	index.Files["junit"] = name
+	d, err := renderRunReport(run)
+	if err != nil {
+		return err
+	}
+	name = "report.html"
+	if err = ioutil.WriteFile(longPath(filepath.Join(dir, name)), d, 0644); err != nil {
+		return err
+	}
+	index.Files["html"] = name
+
+	for _, t := range tests {
+		if !isFailedTest(t) {
+			continue
+		}
+		for _, a := range t.Artifacts {
+			rel, err := filepath.Rel(dir, a)
+			if err != nil {
+				rel = a
+			}
+			index.Artifacts = append(index.Artifacts, &ReportArtifact{
+				TestID:   testID(t),
+				TestName: testDisplayName(t),
+				Path:     filepath.ToSlash(rel),
+			})
+		}
+	}
+	d, err = json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(longPath(filepath.Join(dir, reportsIndexName)), d, 0644)
+}
+
+// updateLatestReport points latest and latest.txt to name
+func updateLatestReport(reportsDir string, name string) error {
+	link := filepath.Join(reportsDir, "latest")
+	os.Remove(longPath(link))
+	// creating symlinks on Windows needs developer mode or admin
+	if err := os.Symlink(name, link); err != nil {
+		fmt.Printf("couldn't create symlink '%s', use latest.txt: %s\n", link, err)
+	}
+	tmpPath := filepath.Join(reportsDir, "latest.txt.tmp")
+	err := ioutil.WriteFile(longPath(tmpPath), []byte(name+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(longPath(tmpPath), longPath(filepath.Join(reportsDir, "latest.txt")))
+}
+
+func saveRunReports(tests []*Test) {
+	if flgReportsDir == "" {
+		return
+	}
+	gitSha := gitShortSha()
+	name := runID()
+	if gitSha != "" {
+		name += "-" + gitSha
+	}
+	dir := filepath.Join(flgReportsDir, name)
+	err := os.MkdirAll(longPath(dir), 0755)
+	if err == nil {
+		index := &ReportIndex{ID: runID(), GitSha: gitSha, Started: runStarted}
+		err = writeRunReports(dir, tests, index)
+	}
+	if err == nil {
+		err = updateLatestReport(flgReportsDir, name)
+	}
+	if err != nil {
+		fmt.Printf("failed to save reports to '%s': %s\n", dir, err)
+		return
+	}
+	fmt.Printf("saved reports to '%s'\n", dir)
+}
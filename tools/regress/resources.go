@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Resources used by the whole run, printed at the end and saved in results
+so that we know how much CI capacity the tests need.
+*/
+
+// RunResources is resources used by a run
+type RunResources struct {
+	CPUSeconds      float64 // user + system time of test processes
+	BytesDownloaded int64
+	// tests run one at a time so it's the max of PeakMemoryKB of tests,
+	// 0 if we don't know it (on Windows)
+	PeakMemoryKB   int64 `json:",omitempty"`
+	ArtifactsBytes int64
+}
+
+var (
+	// atomic
+	runCPUTimeNs int64
+)
+
+func addProcessCPUTime(ps *os.ProcessState) {
+	if ps == nil {
+		return
+	}
+	d := ps.UserTime() + ps.SystemTime()
+	atomic.AddInt64(&runCPUTimeNs, int64(d))
+}
+
+func runResources(tests []*Test) *RunResources {
+	// downloads are counted once, for regress_downloaded_bytes_total metric
+	res := &RunResources{
+		CPUSeconds:      time.Duration(atomic.LoadInt64(&runCPUTimeNs)).Seconds(),
+		BytesDownloaded: atomic.LoadInt64(&metricBytesDownloaded),
+	}
+	seen := map[string]bool{}
+	for _, t := range tests {
+		if t.PeakMemoryKB > res.PeakMemoryKB {
+			res.PeakMemoryKB = t.PeakMemoryKB
+		}
+		for _, path := range t.Artifacts {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			if fi, err := os.Stat(longPath(path)); err == nil {
+				res.ArtifactsBytes += fi.Size()
+			}
+		}
+	}
+	return res
+}
+
+func toMB(n int64) float64 {
+	return float64(n) / (1024 * 1024)
+}
+
+func dumpRunResources(tests []*Test) {
+	r := runResources(tests)
+	mem := "unknown"
+	if r.PeakMemoryKB > 0 {
+		mem = fmt.Sprintf("%.1f MB", toMB(r.PeakMemoryKB*1024))
+	}
+	fmt.Printf("resources: cpu %.1f s, downloaded %.1f MB, peak memory %s, artifacts %.1f MB\n", r.CPUSeconds, toMB(r.BytesDownloaded), mem, toMB(r.ArtifactsBytes))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+SumatraPDF reads restrictions from sumatrapdfrestrict.ini in the directory
+of SumatraPDF.exe (see docs/sumatrapdfrestrict.ini). A test with Restrict:
+lines runs a copy of the binary with such file, e.g.:
+
+Restrict: DiskAccess = 1
+Restrict: LinkProtocols = http,https
+
+allows disk access and those link protocols and restricts everything else.
+Empty Restrict: restricts everything. Checks in the test should verify
+that restricted operations were blocked.
+*/
+
+const restrictFileName = "sumatrapdfrestrict.ini"
+
+var knownRestrictPolicies = []string{
+	"InternetAccess", "DiskAccess", "SavePreferences", "RegistryAccess",
+	"PrinterAccess", "CopySelection", "FullscreenAccess", "LinkProtocols",
+	"SafeFileTypes",
+}
+
+func parseRestrict(pos string, val string) string {
+	if val == "" {
+		return ""
+	}
+	parts := strings.SplitN(val, "=", 2)
+	panicIf(len(parts) != 2, "%s: Restrict: must be Policy = value, got '%s'\n", pos, val)
+	name := strings.TrimSpace(parts[0])
+	for _, policy := range knownRestrictPolicies {
+		if strings.EqualFold(policy, name) {
+			return policy + " = " + strings.TrimSpace(parts[1])
+		}
+	}
+	panicIf(true, "%s: Restrict: unknown policy '%s', known: %s\n", pos, name, strings.Join(knownRestrictPolicies, ", "))
+	return ""
+}
+
+func restrictFileContent(t *Test) string {
+	s := "[Policies]\n"
+	for _, l := range t.Restrict {
+		if l != "" {
+			s += l + "\n"
+		}
+	}
+	return s
+}
+
+// stageRestrictedExe copies the binary (and dlls next to it) to test's temp
+// dir, adds sumatrapdfrestrict.ini and returns path of the copy
+func stageRestrictedExe(t *Test) (string, error) {
+	dir := filepath.Join(t.TempDir, "bin")
+	err := os.MkdirAll(longPath(dir), 0755)
+	if err != nil {
+		return "", err
+	}
+	srcDir := filepath.Dir(t.CmdPath)
+	files, err := ioutil.ReadDir(longPath(srcDir))
+	if err != nil {
+		return "", err
+	}
+	for _, fi := range files {
+		if strings.EqualFold(filepath.Ext(fi.Name()), ".dll") {
+			err = linkOrCopyFile(filepath.Join(dir, fi.Name()), filepath.Join(srcDir, fi.Name()))
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	exePath := filepath.Join(dir, filepath.Base(t.CmdPath))
+	err = linkOrCopyFile(exePath, t.CmdPath)
+	if err != nil {
+		return "", err
+	}
+	iniPath := filepath.Join(dir, restrictFileName)
+	err = ioutil.WriteFile(longPath(iniPath), []byte(restrictFileContent(t)), 0644)
+	return exePath, err
+}
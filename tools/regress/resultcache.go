@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+If neither the binary nor the test changed since the test last passed,
+running it again is a waste of time. We remember passed tests keyed by
+sha1 of the binary, test file, command and expected output.
+Use -force to run all tests anyway.
+*/
+
+var (
+	// result cache key => result of a passed test
+	resultCache      map[string]*TestResult
+	binarySha1ByPath = map[string]string{}
+)
+
+func binarySha1Hex(path string) string {
+	if s, ok := binarySha1ByPath[path]; ok {
+		return s
+	}
+	s := ""
+	// binary can be missing, see missingbin.go
+	if fileExists(path) {
+		s, _ = sha1HexOfFile(path)
+	}
+	binarySha1ByPath[path] = s
+	return s
+}
+
+func resultCacheKey(t *Test) string {
+	binSha1 := binarySha1Hex(t.CmdPath)
+	if binSha1 == "" {
+		return ""
+	}
+	parts := []string{
+		binSha1,
+		t.FileSha1Hex,
+		t.CmdUnparsed,
+		sha1HexOfBytes([]byte(t.ExpectedOutput)),
+		sha1HexOfBytes([]byte(t.ExpectedStderr)),
+		t.SaveAs,
+		t.Compare,
+		strings.Join(assertTexts(t), "\n"),
+		fmt.Sprintf("%g", t.MaxRenderMs),
+		fmt.Sprintf("%s %v", budgetString(t), flgEnforceBudgets),
+		t.Timeout.String(),
+		fmt.Sprintf("%v", t.Stabilize),
+		fmt.Sprintf("%v %v", t.CleanTemp, flgCheckTempCleanup),
+		strings.Join(fixturesForKey(t), "\n"),
+		fontsForKey(t),
+		producesFilesForKey(t),
+		fmt.Sprintf("%v", t.ExpectedPages),
+		strings.Join(testEnvForKey(t), "\n"),
+		settingsSha1Hex(t),
+		fmt.Sprintf("%v %v", t.Restricted, t.Restrict),
+		strings.Join(t.Oracles, ","),
+	}
+	return sha1HexOfBytes([]byte(strings.Join(parts, "\n")))
+}
+
+func loadResultCache() {
+	resultCache = map[string]*TestResult{}
+	if flgResultCache == "" {
+		return
+	}
+	d, err := ioutil.ReadFile(longPath(flgResultCache))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(d, &resultCache)
+	if err != nil {
+		fmt.Printf("ignoring invalid result cache '%s': %s\n", flgResultCache, err)
+		resultCache = map[string]*TestResult{}
+	}
+}
+
+func saveResultCache() {
+	if flgResultCache == "" {
+		return
+	}
+	d, err := json.MarshalIndent(resultCache, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(longPath(filepath.Dir(flgResultCache)), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(longPath(flgResultCache), d, 0644)
+	}
+	if err != nil {
+		fmt.Printf("failed to save result cache '%s': %s\n", flgResultCache, err)
+	}
+}
+
+// skipCachedTests marks tests that passed before with the same binary
+// and inputs as done
+func skipCachedTests(tests []*Test) {
+	loadResultCache()
+	if flgForce {
+		return
+	}
+	nSkipped := 0
+	for _, t := range tests {
+		if t.Done || t.InfraError != nil {
+			continue
+		}
+		key := resultCacheKey(t)
+		if r := resultCache[key]; r != nil && key != "" {
+			applyResult(t, r)
+			t.FromCache = true
+			nSkipped++
+		}
+	}
+	if nSkipped > 0 {
+		fmt.Printf("skipping %d tests that passed before with the same binary and inputs (use -force to run them)\n", nSkipped)
+	}
+}
+
+func updateResultCache(tests []*Test) {
+	for _, t := range tests {
+		if !t.Done || t.FromCache {
+			continue
+		}
+		key := resultCacheKey(t)
+		if key == "" {
+			continue
+		}
+		if isFailedTest(t) {
+			delete(resultCache, key)
+		} else {
+			resultCache[key] = testToResult(t)
+		}
+	}
+	saveResultCache()
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TestResult is the part of Test that we persist between runs
+type TestResult struct {
+	ID               string `json:",omitempty"` // see testID
+	Key              string
+	Name             string   `json:",omitempty"`
+	Bug              string   `json:",omitempty"`
+	Notes            []string `json:",omitempty"`
+	Tags             []string `json:",omitempty"`
+	Owner            string   `json:",omitempty"` // see labels.go
+	Labels           []string `json:",omitempty"`
+	FileSha1Hex      string
+	Cmd              string
+	FileURL          string
+	FilePath         string
+	FileMeta         *CacheMeta `json:",omitempty"`
+	License          string     `json:",omitempty"`
+	ExpectedOutput   string
+	Output           string
+	Stderr           string         `json:",omitempty"`
+	OutputMismatches []string       `json:",omitempty"`
+	ExitCode         int            `json:",omitempty"`
+	DurationMs       int64          `json:",omitempty"`
+	Asserts          []string       `json:",omitempty"`
+	MaxRenderMs      float64        `json:",omitempty"`
+	Budget           string         `json:",omitempty"`
+	Stabilize        bool           `json:",omitempty"`
+	TempLeftovers    []string       `json:",omitempty"`
+	Variant          string         `json:",omitempty"`
+	Category         string         `json:",omitempty"` // e.g. timeout, only for failed tests
+	ExpectedPages    map[int]string `json:",omitempty"`
+	Env              []string       `json:",omitempty"`
+	Settings         string         `json:",omitempty"`
+	Restrict         []string       `json:",omitempty"`
+	Error            string         `json:",omitempty"`
+	OracleMismatch   string         `json:",omitempty"`
+	InfraError       string         `json:",omitempty"`
+	Artifacts        []string       `json:",omitempty"`
+	Failed           bool
+}
+
+// RunResults is a serializable result of a run
+type RunResults struct {
+	ID        string
+	Started   time.Time
+	Resources *RunResources `json:",omitempty"`
+	// why not all tests ran e.g. -failfast, such run can be resumed
+	Stopped string `json:",omitempty"`
+	// only in checkpoint, sha1 of binaries the run used
+	BinarySha1 string `json:",omitempty"`
+	Tests      []*TestResult
+}
+
+var (
+	lastCheckpointTime time.Time
+	runStarted         = time.Now()
+)
+
+// runID identifies a run in history, sorts by time
+func runID() string {
+	return runStarted.Format("20060102-150405")
+}
+
+// testKey identifies a test across runs
+func testKey(t *Test) string {
+	key := t.FileSha1Hex + " " + t.CmdUnparsed
+	if t.SaveAs != "" {
+		key += " " + t.SaveAs
+	}
+	if t.Variant != "" {
+		key += " [" + t.Variant + "]"
+	}
+	return key
+}
+
+// testID is a short, stable id of a test: hash of file sha1 and command.
+// Unlike Name: and position in tests.txt it doesn't change when a test
+// is renamed or moved so we use it to match tests in history, baseline
+// and known failures.
+func testID(t *Test) string {
+	return keyToID(testKey(t))
+}
+
+// keyToID ignores whitespace so that re-formatting Cmd: doesn't change id
+func keyToID(key string) string {
+	s := strings.Join(strings.Fields(key), " ")
+	h := sha1.Sum([]byte(s))
+	return hex.EncodeToString(h[:6])
+}
+
+// resultID also works for results saved before we had ID
+func resultID(r *TestResult) string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return keyToID(r.Key)
+}
+
+func testToResult(t *Test) *TestResult {
+	return &TestResult{
+		ID:               testID(t),
+		Key:              testKey(t),
+		Name:             t.Name,
+		Bug:              t.Bug,
+		Notes:            t.Notes,
+		Tags:             t.Tags,
+		Owner:            triageLabelOwner(t),
+		Labels:           triageLabelLabels(t),
+		FileSha1Hex:      t.FileSha1Hex,
+		Cmd:              t.CmdUnparsed,
+		FileURL:          t.FileURL,
+		FilePath:         t.FilePath,
+		FileMeta:         t.FileMeta,
+		License:          testLicense(t),
+		ExpectedOutput:   t.ExpectedOutput,
+		Output:           t.Output,
+		Stderr:           t.Stderr,
+		OutputMismatches: t.OutputMismatches,
+		ExitCode:         t.ExitCode,
+		DurationMs:       t.Duration.Milliseconds(),
+		Asserts:          assertTexts(t),
+		MaxRenderMs:      t.MaxRenderMs,
+		Budget:           budgetString(t),
+		Stabilize:        t.Stabilize,
+		TempLeftovers:    t.TempLeftovers,
+		Variant:          t.Variant,
+		Category:         failureCategory(t),
+		ExpectedPages:    t.ExpectedPages,
+		Env:              t.Env,
+		Settings:         t.Settings,
+		Restrict:         t.Restrict,
+		Error:            errStr(t.Error),
+		OracleMismatch:   t.OracleMismatch,
+		InfraError:       errStr(t.InfraError),
+		Artifacts:        t.Artifacts,
+		Failed:           isFailedTest(t),
+	}
+}
+
+func applyResult(t *Test, r *TestResult) {
+	t.Output = r.Output
+	t.Stderr = r.Stderr
+	t.Error = nil
+	if r.Error != "" {
+		t.Error = errors.New(r.Error)
+	}
+	t.OutputMismatches = r.OutputMismatches
+	t.ExitCode = r.ExitCode
+	t.OracleMismatch = r.OracleMismatch
+	t.Artifacts = r.Artifacts
+	t.InfraError = nil
+	if r.InfraError != "" {
+		t.InfraError = errors.New(r.InfraError)
+	}
+	t.Done = true
+}
+
+func testsToRunResults(tests []*Test) *RunResults {
+	res := &RunResults{
+		ID:        runID(),
+		Started:   runStarted,
+		Resources: runResources(tests),
+		Stopped:   runStopReason,
+	}
+	for _, t := range tests {
+		if t.Done {
+			res.Tests = append(res.Tests, testToResult(t))
+		}
+	}
+	return res
+}
+
+func loadRunResults(path string) (*RunResults, error) {
+	d, err := ioutil.ReadFile(longPath(path))
+	if err != nil {
+		return nil, err
+	}
+	var res RunResults
+	err = json.Unmarshal(d, &res)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &res, nil
+}
+
+// saveRunResults writes to a temp file first so that a crash
+// doesn't leave a half-written file
+func saveRunResults(path string, res *RunResults) error {
+	d, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(longPath(filepath.Dir(path)), 0755)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(longPath(tmpPath), d, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(longPath(tmpPath), longPath(path))
+}
+
+// runBinarySha1 changes when any binary used by tests changes
+func runBinarySha1(tests []*Test) string {
+	seen := map[string]bool{}
+	var parts []string
+	for _, t := range tests {
+		if !seen[t.CmdPath] {
+			seen[t.CmdPath] = true
+			parts = append(parts, t.CmdPath+" "+binarySha1Hex(t.CmdPath))
+		}
+	}
+	sort.Strings(parts)
+	return sha1HexOfBytes([]byte(strings.Join(parts, "\n")))
+}
+
+// isFinishedRun is true if results of a run with this id that wasn't
+// stopped were saved, e.g. when we crashed after saving results but
+// before removing the checkpoint
+func isFinishedRun(id string) bool {
+	res, err := loadRunResults(flgResults)
+	if err != nil || res.ID != id {
+		if flgHistory == "" {
+			return false
+		}
+		res, err = loadRunResults(filepath.Join(flgHistory, id+".json"))
+	}
+	return err == nil && res.ID == id && res.Stopped == ""
+}
+
+// resumeFromCheckpoint marks tests completed in previous, interrupted
+// run as done so that we don't run them again. We don't resume runs that
+// finished or used different binaries.
+func resumeFromCheckpoint(tests []*Test) {
+	res, err := loadRunResults(flgCheckpoint)
+	if os.IsNotExist(err) {
+		fmt.Printf("no checkpoint '%s', starting from scratch\n", flgCheckpoint)
+		return
+	}
+	fatalIfErr(err)
+	if isFinishedRun(res.ID) {
+		fmt.Printf("warning: ignoring checkpoint '%s' of run %s that already finished, starting from scratch\n", flgCheckpoint, res.ID)
+		return
+	}
+	if res.BinarySha1 != runBinarySha1(tests) {
+		fmt.Printf("warning: ignoring checkpoint '%s' of run %s with different binaries, starting from scratch\n", flgCheckpoint, res.ID)
+		return
+	}
+	byKey := map[string]*TestResult{}
+	for _, r := range res.Tests {
+		// infrastructure errors are worth retrying
+		if r.InfraError == "" {
+			byKey[resultID(r)] = r
+		}
+	}
+	nResumed := 0
+	for _, t := range tests {
+		if r := byKey[testID(t)]; r != nil {
+			applyResult(t, r)
+			nResumed++
+		}
+	}
+	fmt.Printf("resuming from '%s', %d out of %d tests already done\n", flgCheckpoint, nResumed, len(tests))
+}
+
+func saveCheckpoint(tests []*Test, force bool) {
+	if flgCheckpoint == "" {
+		return
+	}
+	if !force && time.Since(lastCheckpointTime) < flgCheckpointInterval {
+		return
+	}
+	lastCheckpointTime = time.Now()
+	res := testsToRunResults(tests)
+	res.BinarySha1 = runBinarySha1(tests)
+	err := saveRunResults(flgCheckpoint, res)
+	if err != nil {
+		fmt.Printf("failed to save checkpoint '%s': %s\n", flgCheckpoint, err)
+	}
+}
+
+// removeCheckpoint is called when results are saved, there's nothing to
+// resume unless the run was stopped by -failfast or -max-failures
+func removeCheckpoint() {
+	if flgCheckpoint == "" || runStopReason != "" {
+		return
+	}
+	err := os.Remove(longPath(flgCheckpoint))
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("failed to remove checkpoint '%s': %s\n", flgCheckpoint, err)
+	}
+}
+
+func saveResults(tests []*Test) {
+	if flgResults == "" {
+		return
+	}
+	res := testsToRunResults(tests)
+	err := saveRunResults(flgResults, res)
+	if err != nil {
+		fmt.Printf("failed to save results to '%s': %s\n", flgResults, err)
+		return
+	}
+	fmt.Printf("saved results to '%s'\n", flgResults)
+	saveRunToHistory(res)
+}
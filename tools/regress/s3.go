@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Minimal S3 client (PUT, GET, DELETE, list) with AWS signature v4 so that
+we don't need aws sdk. Credentials are from AWS_ACCESS_KEY_ID,
+AWS_SECRET_ACCESS_KEY and (optional) AWS_SESSION_TOKEN, region from
+AWS_REGION (us-east-1 if not set). S3_ENDPOINT overrides the endpoint
+e.g. for S3-compatible storage.
+*/
+
+type s3Client struct {
+	bucket    string
+	region    string
+	endpoint  string // e.g. https://s3.us-east-1.amazonaws.com
+	accessKey string
+	secretKey string
+	token     string
+}
+
+func newS3ClientMust(bucket string) *s3Client {
+	c := &s3Client{
+		bucket:    bucket,
+		region:    os.Getenv("AWS_REGION"),
+		endpoint:  os.Getenv("S3_ENDPOINT"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	panicIf(c.accessKey == "" || c.secretKey == "", "need AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY env variables for s3\n")
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+	if c.endpoint == "" {
+		c.endpoint = "https://s3." + c.region + ".amazonaws.com"
+	}
+	c.endpoint = strings.TrimSuffix(c.endpoint, "/")
+	return c
+}
+
+// parseS3Dest parses "bucket/prefix"
+func parseS3Dest(s string) (string, string) {
+	s = strings.TrimPrefix(s, "s3://")
+	parts := strings.SplitN(s, "/", 2)
+	prefix := ""
+	if len(parts) == 2 {
+		prefix = strings.Trim(parts[1], "/")
+	}
+	return parts[0], prefix
+}
+
+func sha256Hex(d []byte) string {
+	h := sha256.Sum256(d)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// s3 wants everything except unreserved characters escaped
+func s3Escape(s string, escapeSlash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		isUnreserved := (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~'
+		if isUnreserved || (b == '/' && !escapeSlash) {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func (c *s3Client) do(method string, key string, query url.Values, body []byte) ([]byte, error) {
+	path := "/" + c.bucket + "/" + key
+	var qs []string
+	for k, vals := range query {
+		for _, v := range vals {
+			qs = append(qs, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	sort.Strings(qs)
+	canonicalQuery := strings.Join(qs, "&")
+	uri := c.endpoint + s3Escape(path, false)
+	if canonicalQuery != "" {
+		uri += "?" + canonicalQuery
+	}
+	req, err := http.NewRequest(method, uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if c.token != "" {
+		req.Header.Set("x-amz-security-token", c.token)
+	}
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if c.token != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	var canonicalHeaders string
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonicalHeaders += h + ":" + strings.TrimSpace(v) + "\n"
+	}
+	signedHeaders := strings.Join(headers, ";")
+	canonicalRequest := strings.Join([]string{method, s3Escape(path, false), canonicalQuery, canonicalHeaders, signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	key2 := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key2 = hmacSHA256(key2, c.region)
+	key2 = hmacSHA256(key2, "s3")
+	key2 = hmacSHA256(key2, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key2, toSign))
+	auth := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.accessKey, scope, signedHeaders, sig)
+	req.Header.Set("Authorization", auth)
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	d, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode >= 300 {
+		return nil, fmt.Errorf("s3 %s '%s' failed with '%s': %s", method, key, rsp.Status, d)
+	}
+	return d, nil
+}
+
+func (c *s3Client) put(key string, d []byte) error {
+	_, err := c.do(http.MethodPut, key, nil, d)
+	return err
+}
+
+func (c *s3Client) putFile(key string, path string) error {
+	d, err := ioutil.ReadFile(longPath(path))
+	if err != nil {
+		return err
+	}
+	return c.put(key, d)
+}
+
+func (c *s3Client) get(key string) ([]byte, error) {
+	return c.do(http.MethodGet, key, nil, nil)
+}
+
+func (c *s3Client) delete(key string) error {
+	_, err := c.do(http.MethodDelete, key, nil, nil)
+	return err
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns keys of all objects that start with prefix
+func (c *s3Client) list(prefix string) ([]string, error) {
+	var res []string
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		d, err := c.do(http.MethodGet, "", q, nil)
+		if err != nil {
+			return nil, err
+		}
+		var lr s3ListResult
+		err = xml.Unmarshal(d, &lr)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range lr.Contents {
+			res = append(res, o.Key)
+		}
+		if !lr.IsTruncated || lr.NextContinuationToken == "" {
+			return res, nil
+		}
+		token = lr.NextContinuationToken
+	}
+}
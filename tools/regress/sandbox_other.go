@@ -0,0 +1,25 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+)
+
+// sandbox is only implemented on Windows, see sandbox_windows.go
+type sandbox struct{}
+
+func setupSandbox(cmd *exec.Cmd) (*sandbox, error) {
+	return nil, nil
+}
+
+func (sb *sandbox) start(pid int) error {
+	return nil
+}
+
+func (sb *sandbox) peakMemoryKB() int64 {
+	return 0
+}
+
+func (sb *sandbox) close() {
+}
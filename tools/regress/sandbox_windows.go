@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+/*
+Test files are untrusted so on Windows we run each test process in a job
+object (unless -no-sandbox):
+- kill on close: when we're done with the test, all processes it started
+  are killed, even if they detached from the parent
+- UI restrictions: no access to clipboard, global atoms, handles of other
+  processes' windows, system parameters, display settings, desktops and
+  no logging off / shutting down
+
+The process is started suspended and resumed only after it's in the job
+so that it can't do anything before the restrictions apply.
+
+-sandbox-no-network also runs the process with a restricted token: no
+privileges, Administrators group deny-only and access checks also done
+for only the user, its logon session and Users group. Network stack
+devices are not accessible to those so sockets can't be created. This
+is best effort, it depends on ACLs of the system.
+*/
+
+var (
+	procCreateJobObjectW         = modkernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = modkernel32.NewProc("SetInformationJobObject")
+	procQueryInformationJobObj   = modkernel32.NewProc("QueryInformationJobObject")
+	procAssignProcessToJobObject = modkernel32.NewProc("AssignProcessToJobObject")
+	procCreateToolhelp32Snapshot = modkernel32.NewProc("CreateToolhelp32Snapshot")
+	procThread32First            = modkernel32.NewProc("Thread32First")
+	procThread32Next             = modkernel32.NewProc("Thread32Next")
+	procOpenThread               = modkernel32.NewProc("OpenThread")
+	procResumeThread             = modkernel32.NewProc("ResumeThread")
+
+	procCreateRestrictedToken = modadvapi32.NewProc("CreateRestrictedToken")
+)
+
+const (
+	createSuspended = 0x00000004
+
+	jobObjectBasicUIRestrictions      = 4
+	jobObjectExtendedLimitInformation = 9
+	jobObjectLimitKillOnJobClose      = 0x2000
+	jobObjectUILimitHandles           = 0x1
+	jobObjectUILimitReadClipboard     = 0x2
+	jobObjectUILimitWriteClipboard    = 0x4
+	jobObjectUILimitSystemParameters  = 0x8
+	jobObjectUILimitDisplaySettings   = 0x10
+	jobObjectUILimitGlobalAtoms       = 0x20
+	jobObjectUILimitDesktop           = 0x40
+	jobObjectUILimitExitWindows       = 0x80
+	processSetQuota                   = 0x0100
+	processTerminate                  = 0x0001
+	threadSuspendResume               = 0x0002
+	th32csSnapThread                  = 0x4
+	disableMaxPrivilege               = 0x1
+	seGroupLogonID                    = 0xC0000000
+	sidAdministrators                 = "S-1-5-32-544"
+	sidUsers                          = "S-1-5-32-545"
+)
+
+type jobBasicLimitInfo struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+}
+
+type ioCounters struct {
+	ReadOperationCount  uint64
+	WriteOperationCount uint64
+	OtherOperationCount uint64
+	ReadTransferCount   uint64
+	WriteTransferCount  uint64
+	OtherTransferCount  uint64
+}
+
+type jobExtendedLimitInfo struct {
+	BasicLimitInformation jobBasicLimitInfo
+	IoInfo                ioCounters
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+type threadEntry32 struct {
+	Size           uint32
+	Usage          uint32
+	ThreadID       uint32
+	OwnerProcessID uint32
+	BasePri        int32
+	DeltaPri       int32
+	Flags          uint32
+}
+
+// TOKEN_GROUPS
+type tokenGroups struct {
+	GroupCount uint32
+	Groups     [1]syscall.SIDAndAttributes
+}
+
+// sandbox is a job object a test process runs in
+type sandbox struct {
+	job   syscall.Handle
+	token syscall.Token
+}
+
+func createJobObject() (syscall.Handle, error) {
+	h, _, err := procCreateJobObjectW.Call(0, 0)
+	if h == 0 {
+		return 0, err
+	}
+	job := syscall.Handle(h)
+	limits := jobExtendedLimitInfo{}
+	limits.BasicLimitInformation.LimitFlags = jobObjectLimitKillOnJobClose
+	r, _, err := procSetInformationJobObject.Call(uintptr(job), jobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&limits)), unsafe.Sizeof(limits))
+	if r == 0 {
+		syscall.CloseHandle(job)
+		return 0, err
+	}
+	ui := uint32(jobObjectUILimitHandles | jobObjectUILimitReadClipboard | jobObjectUILimitWriteClipboard | jobObjectUILimitSystemParameters | jobObjectUILimitDisplaySettings | jobObjectUILimitGlobalAtoms | jobObjectUILimitDesktop | jobObjectUILimitExitWindows)
+	r, _, err = procSetInformationJobObject.Call(uintptr(job), jobObjectBasicUIRestrictions, uintptr(unsafe.Pointer(&ui)), unsafe.Sizeof(ui))
+	if r == 0 {
+		syscall.CloseHandle(job)
+		return 0, err
+	}
+	return job, nil
+}
+
+// getTokenGroups returns groups of the token, the sids point into memory
+// that lives as long as the result
+func getTokenGroups(token syscall.Token) ([]syscall.SIDAndAttributes, error) {
+	n := uint32(1024)
+	for {
+		buf := make([]byte, n)
+		err := syscall.GetTokenInformation(token, syscall.TokenGroups, &buf[0], n, &n)
+		if err == syscall.ERROR_INSUFFICIENT_BUFFER {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tg := (*tokenGroups)(unsafe.Pointer(&buf[0]))
+		n := tg.GroupCount
+		return (*[1 << 16]syscall.SIDAndAttributes)(unsafe.Pointer(&tg.Groups[0]))[:n:n], nil
+	}
+}
+
+// createNoNetworkToken creates restricted version of our token, see the
+// comment at the top
+func createNoNetworkToken() (syscall.Token, error) {
+	p, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0, err
+	}
+	var token syscall.Token
+	err = syscall.OpenProcessToken(p, syscall.TOKEN_ALL_ACCESS, &token)
+	if err != nil {
+		return 0, err
+	}
+	defer token.Close()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return 0, err
+	}
+	admins, err := syscall.StringToSid(sidAdministrators)
+	if err != nil {
+		return 0, err
+	}
+	users, err := syscall.StringToSid(sidUsers)
+	if err != nil {
+		return 0, err
+	}
+	restricting := []syscall.SIDAndAttributes{{Sid: user.User.Sid}, {Sid: users}}
+	groups, err := getTokenGroups(token)
+	if err != nil {
+		return 0, err
+	}
+	// logon sid gives access to window station and desktop
+	for _, g := range groups {
+		if g.Attributes&seGroupLogonID == seGroupLogonID {
+			restricting = append(restricting, syscall.SIDAndAttributes{Sid: g.Sid})
+		}
+	}
+	disable := []syscall.SIDAndAttributes{{Sid: admins}}
+	var res syscall.Token
+	r, _, err := procCreateRestrictedToken.Call(uintptr(token), disableMaxPrivilege,
+		uintptr(len(disable)), uintptr(unsafe.Pointer(&disable[0])),
+		0, 0,
+		uintptr(len(restricting)), uintptr(unsafe.Pointer(&restricting[0])),
+		uintptr(unsafe.Pointer(&res)))
+	if r == 0 {
+		return 0, err
+	}
+	return res, nil
+}
+
+// setupSandbox must be called before cmd.Start()
+func setupSandbox(cmd *exec.Cmd) (*sandbox, error) {
+	if flgNoSandbox {
+		return nil, nil
+	}
+	job, err := createJobObject()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job object (use -no-sandbox to run without it): %w", err)
+	}
+	sb := &sandbox{job: job}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= createSuspended
+	if flgSandboxNoNetwork {
+		sb.token, err = createNoNetworkToken()
+		if err != nil {
+			sb.close()
+			return nil, fmt.Errorf("failed to create restricted token: %w", err)
+		}
+		cmd.SysProcAttr.Token = sb.token
+	}
+	return sb, nil
+}
+
+func resumeProcess(pid int) error {
+	snap, _, err := procCreateToolhelp32Snapshot.Call(th32csSnapThread, 0)
+	if syscall.Handle(snap) == syscall.InvalidHandle {
+		return err
+	}
+	defer syscall.CloseHandle(syscall.Handle(snap))
+	te := threadEntry32{}
+	te.Size = uint32(unsafe.Sizeof(te))
+	r, _, err := procThread32First.Call(snap, uintptr(unsafe.Pointer(&te)))
+	nResumed := 0
+	for r != 0 {
+		if int(te.OwnerProcessID) == pid {
+			h, _, err := procOpenThread.Call(threadSuspendResume, 0, uintptr(te.ThreadID))
+			if h == 0 {
+				return err
+			}
+			procResumeThread.Call(h)
+			syscall.CloseHandle(syscall.Handle(h))
+			nResumed++
+		}
+		r, _, err = procThread32Next.Call(snap, uintptr(unsafe.Pointer(&te)))
+	}
+	if nResumed == 0 {
+		return fmt.Errorf("no threads of pid %d to resume: %w", pid, err)
+	}
+	return nil
+}
+
+// start puts the started (suspended) process in the job and resumes it
+func (sb *sandbox) start(pid int) error {
+	h, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(h)
+	r, _, err := procAssignProcessToJobObject.Call(uintptr(sb.job), uintptr(h))
+	if r == 0 {
+		return fmt.Errorf("failed to assign process to job object: %w", err)
+	}
+	return resumeProcess(pid)
+}
+
+// peakMemoryKB returns peak memory of processes in the job
+func (sb *sandbox) peakMemoryKB() int64 {
+	var info jobExtendedLimitInfo
+	r, _, _ := procQueryInformationJobObj.Call(uintptr(sb.job), jobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info), 0)
+	if r == 0 {
+		return 0
+	}
+	return int64(info.PeakProcessMemoryUsed) / 1024
+}
+
+// close kills processes that are still running
+func (sb *sandbox) close() {
+	if sb.token != 0 {
+		sb.token.Close()
+	}
+	syscall.CloseHandle(sb.job)
+}
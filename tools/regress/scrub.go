@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/*
+regress scrub input.pdf makes a user-submitted pdf shareable as a test
+file. With qpdf it removes document info, XMP metadata and attachments.
+
+With -replace-text it also replaces text shown on pages with placeholder
+characters of the same length, keeping fonts and structure that might
+trigger the bug. For that the file is saved uncompressed (qdf) and stream
+lengths are fixed with fix-qdf.
+
+Check that the scrubbed file still reproduces the bug.
+*/
+
+var (
+	// (string) Tj, (string) ' and (string) "
+	rxScrubShowString = regexp.MustCompile(`\((?:\\.|[^\\)])*\)\s*(?:Tj|'|")`)
+	// <hex> Tj
+	rxScrubShowHex = regexp.MustCompile(`<[0-9A-Fa-f\s]*>\s*Tj`)
+	// [(str) -100 <hex>] TJ
+	rxScrubShowArray = regexp.MustCompile(`\[(?:\\.|[^\]\\])*\]\s*TJ`)
+	rxScrubString    = regexp.MustCompile(`\((?:\\.|[^\\)])*\)`)
+	rxScrubHex       = regexp.MustCompile(`<[0-9A-Fa-f\s]*>`)
+)
+
+// scrubLiteralString replaces characters of (string) with x. An escape
+// like \) or \101 is a single character so it becomes a single x
+func scrubLiteralString(s []byte) []byte {
+	res := []byte{'('}
+	inner := s[1 : len(s)-1]
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		if c == '\\' && i+1 < len(inner) {
+			i++
+			// octal escape has up to 3 digits
+			for n := 0; n < 2 && i+1 < len(inner) && isOctalDigit(inner[i]) && isOctalDigit(inner[i+1]); n++ {
+				i++
+			}
+		}
+		if c == ' ' {
+			res = append(res, ' ')
+		} else {
+			res = append(res, 'x')
+		}
+	}
+	return append(res, ')')
+}
+
+func isOctalDigit(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+// scrubHexString replaces glyph codes in <hex> with 0, for 2-byte fonts
+// this is glyph 0 which shows as a box
+func scrubHexString(s []byte) []byte {
+	res := make([]byte, len(s))
+	for i, c := range s {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			c = '0'
+		}
+		res[i] = c
+	}
+	return res
+}
+
+func scrubStrings(s []byte) []byte {
+	s = rxScrubString.ReplaceAllFunc(s, scrubLiteralString)
+	return rxScrubHex.ReplaceAllFunc(s, scrubHexString)
+}
+
+// scrubText replaces text shown with Tj, TJ, ' and " operators in
+// uncompressed content
+func scrubText(d []byte) []byte {
+	d = rxScrubShowString.ReplaceAllFunc(d, scrubStrings)
+	d = rxScrubShowHex.ReplaceAllFunc(d, scrubStrings)
+	return rxScrubShowArray.ReplaceAllFunc(d, scrubStrings)
+}
+
+// listAttachments returns keys of embedded files
+func listAttachments(qpdf string, path string) ([]string, error) {
+	out, err := exec.Command(qpdf, "--list-attachments", path).Output()
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	// lines are like: "report.docx -> 12,0"
+	for _, l := range strings.Split(string(out), "\n") {
+		if idx := strings.Index(l, " -> "); idx > 0 && !strings.HasPrefix(l, " ") {
+			res = append(res, l[:idx])
+		}
+	}
+	return res, nil
+}
+
+func runScrubTool(exe string, args ...string) error {
+	cmd := exec.Command(exe, args...)
+	fmt.Printf("Running: %s\n", cmdToStrLong(cmd))
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	// qpdf exits with 3 on warnings, the file is still written
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 3 {
+		fmt.Printf("%s", out)
+		return nil
+	}
+	return fmt.Errorf("%s failed with '%s':\n%s", exe, err, out)
+}
+
+func scrubPdf(qpdf string, fixQdf string, src string, dst string, replaceText bool) error {
+	attachments, err := listAttachments(qpdf, src)
+	if err != nil {
+		return err
+	}
+	args := []string{"--remove-info", "--remove-metadata"}
+	for _, key := range attachments {
+		args = append(args, "--remove-attachment="+key)
+	}
+	if replaceText {
+		args = append(args, "--qdf", "--object-streams=disable")
+	}
+	args = append(args, src, dst)
+	err = runScrubTool(qpdf, args...)
+	if err != nil || !replaceText {
+		return err
+	}
+	d, err := ioutil.ReadFile(longPath(dst))
+	if err != nil {
+		return err
+	}
+	scrubbed := scrubText(d)
+	if bytes.Equal(d, scrubbed) {
+		fmt.Printf("didn't find any text to replace\n")
+		return nil
+	}
+	tmpPath := dst + ".qdf"
+	err = ioutil.WriteFile(longPath(tmpPath), scrubbed, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(longPath(tmpPath))
+	// fix-qdf writes to stdout
+	cmd := exec.Command(fixQdf, tmpPath)
+	fmt.Printf("Running: %s\n", cmdToStrLong(cmd))
+	fixed, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("%s failed with '%s'", fixQdf, err)
+	}
+	return ioutil.WriteFile(longPath(dst), fixed, 0644)
+}
+
+func scrub(args []string) {
+	fs := flag.NewFlagSet("scrub", flag.ExitOnError)
+	out := fs.String("out", "", "scrubbed file, ${name}-scrubbed.pdf if not given")
+	replaceText := fs.Bool("replace-text", false, "also replace text on pages with placeholder characters")
+	qpdf := fs.String("qpdf", "qpdf", "path of qpdf executable")
+	fixQdf := fs.String("fix-qdf", "fix-qdf", "path of fix-qdf executable (comes with qpdf), for -replace-text")
+	fs.Parse(args)
+	panicIf(fs.NArg() != 1, "usage: regress scrub [-out out.pdf] [-replace-text] input.pdf\n")
+	src := fs.Arg(0)
+	_, err := exec.LookPath(*qpdf)
+	panicIf(err != nil, "scrub needs qpdf, install it or use -qpdf\n")
+	dst := *out
+	if dst == "" {
+		dst = strings.TrimSuffix(src, filepath.Ext(src)) + "-scrubbed.pdf"
+	}
+	err = scrubPdf(*qpdf, *fixQdf, src, dst, *replaceText)
+	fatalIfErr(err)
+	sha1Hex, err := sha1HexOfFile(dst)
+	fatalIfErr(err)
+	fmt.Printf("saved scrubbed file to '%s', sha1: %s\n", dst, sha1Hex)
+	fmt.Printf("check that it still reproduces the bug before adding it as a test file\n")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+regress selftest checks the harness itself. The commands under test are
+regress selftest-helper <mode> which pass, fail, crash, hang or print a
+lot of or unicode output. We check that the parser, the runner, output
+checks and failure categories (used by the report) give expected results.
+
+Exits with 1 if any check fails.
+*/
+
+const selftestUnicode = "zażółć gęślą jaźń 日本語 ✓"
+
+const selftestLargeLines = 200000
+
+// selftestHelper is the command run by selftest tests
+func selftestHelper(args []string) {
+	panicIf(len(args) == 0, "usage: regress selftest-helper <mode>\n")
+	switch args[0] {
+	case "pass":
+		fmt.Printf("ok\n")
+	case "print":
+		fmt.Printf("%s\n", strings.Join(args[1:], " "))
+	case "exit":
+		panicIf(len(args) < 2, "usage: regress selftest-helper exit <code>\n")
+		code, err := strconv.Atoi(args[1])
+		fatalIfErr(err)
+		fmt.Printf("exiting\n")
+		os.Exit(code)
+	case "crash":
+		crashSelf()
+	case "hang":
+		time.Sleep(time.Hour)
+	case "large":
+		var sb strings.Builder
+		for i := 0; i < selftestLargeLines; i++ {
+			fmt.Fprintf(&sb, "line %d\n", i)
+		}
+		os.Stdout.WriteString(sb.String())
+	case "unicode":
+		fmt.Printf("%s\n", selftestUnicode)
+	case "parse":
+		// for checking parser errors which exit the process
+		tests := parseTestsMust(args[1])
+		fmt.Printf("parsed %d tests\n", len(tests))
+	default:
+		fatalf("unknown selftest-helper mode '%s'\n", args[0])
+	}
+}
+
+type selftestCase struct {
+	def        string // test definition without Url:, Sha1: and Cmd:
+	mode       string
+	wantFail   bool
+	categories []string // any of them, for failed tests
+}
+
+var selftestCases = []*selftestCase{
+	{mode: "pass", def: "Name: pass\nOut: ok"},
+	{mode: "print bad", def: "Name: mismatch\nOut: good", wantFail: true, categories: []string{"output mismatch"}},
+	{mode: "exit 2", def: "Name: exit code\nOut: exiting", wantFail: true, categories: []string{"exit code"}},
+	{mode: "exit 3", def: "Name: expected exit code\nAssert: exitCode == 3"},
+	{mode: "crash", def: "Name: crash\nOut: ok", wantFail: true, categories: []string{"crash", "access violation"}},
+	{mode: "hang", def: "Name: hang\nOut: ok", wantFail: true, categories: []string{"timeout"}},
+	{mode: "large", def: fmt.Sprintf("Name: large output\nOutLineCount: %d\nOutContains: line %d", selftestLargeLines, selftestLargeLines-1)},
+	{mode: "unicode", def: "Name: unicode\nOut: " + selftestUnicode},
+	{mode: "print a b", def: "Name: assert fails\nAssert: contains(\"c\")", wantFail: true, categories: []string{"output mismatch"}},
+}
+
+type selftestRunner struct {
+	exe     string
+	dir     string
+	nFailed int
+}
+
+func (r *selftestRunner) check(cond bool, format string, args ...interface{}) {
+	if cond {
+		return
+	}
+	r.nFailed++
+	fmt.Printf("selftest FAILED: "+format+"\n", args...)
+}
+
+func (r *selftestRunner) writeTests(name string, s string) string {
+	path := filepath.Join(r.dir, name)
+	err := ioutil.WriteFile(path, []byte(s), 0644)
+	fatalIfErr(err)
+	return path
+}
+
+// runHelper runs regress selftest-helper and returns its output and exit code
+func (r *selftestRunner) runHelper(args ...string) (string, int) {
+	// default commands from -format-cmds would make tests without Cmd: valid
+	cmd := exec.Command(r.exe, append([]string{"-format-cmds=", "selftest-helper"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		code = -1
+	}
+	return string(out), code
+}
+
+func (r *selftestRunner) checkParser(fileURL, sha1Hex string) {
+	good := r.writeTests("good.txt", fmt.Sprintf("Url: %s\nSha1: %s\nCmd: foo.exe $file\nOut: x\n", fileURL, sha1Hex))
+	out, code := r.runHelper("parse", good)
+	r.check(code == 0 && strings.Contains(out, "parsed 1 tests"), "parser: valid test not parsed, exit code %d, output:\n%s", code, out)
+
+	bad := r.writeTests("bad.txt", fmt.Sprintf("Url: %s\nSha1: %s\nOut: x\n", fileURL, sha1Hex))
+	out, code = r.runHelper("parse", bad)
+	r.check(code != 0 && strings.Contains(out, "Cmd: field missing"), "parser: test without Cmd: not rejected, exit code %d, output:\n%s", code, out)
+
+	dup := r.writeTests("dup.txt", fmt.Sprintf("Url: %s\nSha1: %s\nCmd: foo.exe $file\nOut: x\nOut[a]: y\n", fileURL, sha1Hex))
+	out, code = r.runHelper("parse", dup)
+	r.check(code != 0, "parser: invalid field not rejected, output:\n%s", out)
+}
+
+func selftest(args []string) {
+	panicIf(len(args) > 0, "usage: regress selftest\n")
+	exe, err := os.Executable()
+	fatalIfErr(err)
+	dir, err := ioutil.TempDir("", "regress-selftest-")
+	fatalIfErr(err)
+	defer os.RemoveAll(dir)
+	r := &selftestRunner{exe: exe, dir: dir}
+
+	// test file for $file
+	filePath := filepath.Join(dir, "selftest.pdf")
+	err = ioutil.WriteFile(filePath, []byte("%PDF-1.4 selftest\n"), 0644)
+	fatalIfErr(err)
+	sha1Hex, err := sha1HexOfFile(filePath)
+	fatalIfErr(err)
+	fileURL := "https://example.com/selftest.pdf"
+	testFilesBySha1[sha1Hex] = &TestFile{Path: filePath, Sha1Hex: sha1Hex}
+
+	r.checkParser(fileURL, sha1Hex)
+
+	var defs []string
+	for _, c := range selftestCases {
+		defs = append(defs, fmt.Sprintf("%s\nUrl: %s\nSha1: %s\nCmd: regress selftest-helper %s $file\n", c.def, fileURL, sha1Hex, c.mode))
+	}
+	tests := parseTestsMust(r.writeTests("tests.txt", strings.Join(defs, "\n")))
+	r.check(len(tests) == len(selftestCases), "parser: got %d tests, expected %d", len(tests), len(selftestCases))
+	if len(tests) != len(selftestCases) {
+		os.Exit(1)
+	}
+
+	prevTimeout := flgTimeout
+	flgTimeout = 3 * time.Second
+	for i, t := range tests {
+		c := selftestCases[i]
+		t.CmdPath = exe
+		t.FilePath = filePath
+		runTest(t)
+		t.Done = true
+		name := testDisplayName(t)
+		failed := isFailedTest(t)
+		r.check(failed == c.wantFail, "%s: failed is %v, expected %v (%s)", name, failed, c.wantFail, failureReason(t))
+		if !failed || !c.wantFail {
+			continue
+		}
+		category := failureCategory(t)
+		r.check(hasArg(c.categories, category), "%s: category is '%s', expected one of %v", name, category, c.categories)
+	}
+	flgTimeout = prevTimeout
+
+	// results must survive saving and loading, they're used by triage, serve etc.
+	resultsPath := filepath.Join(dir, "results.json")
+	err = saveRunResults(resultsPath, testsToRunResults(tests))
+	fatalIfErr(err)
+	res, err := loadRunResults(resultsPath)
+	fatalIfErr(err)
+	r.check(len(res.Tests) == len(tests), "results: saved %d tests, loaded %d", len(tests), len(res.Tests))
+	for i, tr := range res.Tests {
+		if i >= len(tests) {
+			break
+		}
+		t := tests[i]
+		r.check(tr.Failed == isFailedTest(t), "results: %s: Failed is %v after loading", tr.Name, tr.Failed)
+		r.check(tr.Category == failureCategory(t), "results: %s: Category is '%s' after loading", tr.Name, tr.Category)
+	}
+	removeScratchDir()
+
+	if r.nFailed > 0 {
+		fmt.Printf("selftest: %d checks failed\n", r.nFailed)
+		os.Exit(1)
+	}
+	fmt.Printf("selftest: all checks passed\n")
+}
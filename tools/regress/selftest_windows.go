@@ -0,0 +1,10 @@
+package main
+
+import (
+	"os"
+)
+
+// crashSelf exits like a process that crashed with access violation
+func crashSelf() {
+	os.Exit(0xC0000005)
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"archive/zip"
+	_ "embed"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+regress serve -port 8080 is a web ui for browsing history of runs
+(-history) and artifacts of failed tests. It also serves JSON api (see api.go).
+*/
+
+//go:embed serve.html
+var serveHTML string
+
+var (
+	serveTemplates = template.Must(template.New("").Parse(serveHTML))
+)
+
+// RunSummary is a run on the index page
+type RunSummary struct {
+	ID      string
+	Started time.Time
+	Tests   []*TestResult
+	Failed  int
+}
+
+// RunTest is a test on the run page
+type RunTest struct {
+	Idx    int
+	Name   string
+	Result *TestResult
+	Label  *TriageLabel // current owner and labels, nil if not set
+}
+
+// DiffLine is a line of a diff on the test page
+type DiffLine struct {
+	Class string
+	Text  string
+}
+
+func resultDisplayName(r *TestResult) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Key
+}
+
+func serveTemplate(w http.ResponseWriter, name string, v interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := serveTemplates.ExecuteTemplate(w, name, v)
+	if err != nil {
+		fmt.Printf("template '%s' failed: %s\n", name, err)
+	}
+}
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	var runs []*RunSummary
+	for _, run := range loadHistory() {
+		rs := &RunSummary{
+			ID:      run.ID,
+			Started: run.Started,
+			Tests:   run.Tests,
+			Failed:  countFailed(run),
+		}
+		runs = append(runs, rs)
+	}
+	serveTemplate(w, "index", runs)
+}
+
+func handleRun(w http.ResponseWriter, r *http.Request) {
+	run, err := loadHistoryRun(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	failedOnly := r.FormValue("failed") == "1"
+	serveTemplate(w, "run", runPageData(run, failedOnly, r.FormValue("q")))
+}
+
+// RunPage is data for the run page
+type RunPage struct {
+	Run        *RunResults
+	Tests      []*RunTest
+	FailedOnly bool
+	Query      string
+}
+
+func runPageData(run *RunResults, failedOnly bool, q string) *RunPage {
+	query := strings.ToLower(q)
+	var tests []*RunTest
+	for i, tr := range run.Tests {
+		if failedOnly && !tr.Failed {
+			continue
+		}
+		name := resultDisplayName(tr)
+		if query != "" && !strings.Contains(strings.ToLower(name+" "+tr.Cmd), query) {
+			continue
+		}
+		rt := &RunTest{
+			Idx:    i,
+			Name:   name,
+			Result: tr,
+			Label:  resultTriageLabel(tr),
+		}
+		tests = append(tests, rt)
+	}
+	return &RunPage{
+		Run:        run,
+		Tests:      tests,
+		FailedOnly: failedOnly,
+		Query:      q,
+	}
+}
+
+// getRunTest returns test result from ?run=${id}&idx=${idx}
+func getRunTest(r *http.Request) (*TestResult, error) {
+	run, err := loadHistoryRun(r.FormValue("run"))
+	if err != nil {
+		return nil, err
+	}
+	idx, err := strconv.Atoi(r.FormValue("idx"))
+	if err != nil || idx < 0 || idx >= len(run.Tests) {
+		return nil, fmt.Errorf("invalid test index '%s'", r.FormValue("idx"))
+	}
+	return run.Tests[idx], nil
+}
+
+func resultDiff(tr *TestResult) []DiffLine {
+	var res []DiffLine
+	diff := diffStrings(tr.ExpectedOutput, outputToExpected(tr))
+	for _, l := range strings.Split(diff, "\n") {
+		dl := DiffLine{
+			Text: l,
+		}
+		if strings.HasPrefix(l, "-") {
+			dl.Class = "del"
+		} else if strings.HasPrefix(l, "+") {
+			dl.Class = "add"
+		}
+		res = append(res, dl)
+	}
+	return res
+}
+
+func handleTest(w http.ResponseWriter, r *http.Request) {
+	tr, err := getRunTest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	var images, files []string
+	for _, path := range tr.Artifacts {
+		if strings.HasSuffix(strings.ToLower(path), ".png") {
+			images = append(images, filepath.ToSlash(path))
+		} else {
+			files = append(files, filepath.ToSlash(path))
+		}
+	}
+	v := struct {
+		RunID  string
+		Idx    string
+		Name   string
+		Result *TestResult
+		Label  *TriageLabel
+		Diff   []DiffLine
+		Images []string
+		Files  []string
+	}{
+		RunID:  r.FormValue("run"),
+		Idx:    r.FormValue("idx"),
+		Name:   resultDisplayName(tr),
+		Result: tr,
+		Label:  resultTriageLabel(tr),
+		Diff:   resultDiff(tr),
+		Images: images,
+		Files:  files,
+	}
+	serveTemplate(w, "test", v)
+}
+
+// isServablePath only allows serving artifacts and files from cache
+func isServablePath(path string) bool {
+	for _, dir := range []string{filepath.Join("out", "regress"), getCacheDirMust()} {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && !strings.HasPrefix(rel, "..") && !filepath.IsAbs(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func handleFile(w http.ResponseWriter, r *http.Request) {
+	path := filepath.Clean(filepath.FromSlash(r.FormValue("path")))
+	if !isServablePath(path) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	http.ServeFile(w, r, longPath(path))
+}
+
+// findCachedFile returns path of the file with a given sha1 in cache
+func findCachedFile(sha1Hex string) string {
+	matches, _ := filepath.Glob(filepath.Join(getCacheDirMust(), sha1Hex+"*"))
+	for _, path := range matches {
+		if !isCacheMetaFile(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+func addFileToZip(zw *zip.Writer, name string, path string) error {
+	f, err := os.Open(longPath(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// resultToTestDef re-creates test definition for tests.txt
+func resultToTestDef(tr *TestResult) string {
+	s := ""
+	if tr.Name != "" {
+		s += "Name: " + tr.Name + "\n"
+	}
+	s += "Url: " + tr.FileURL + "\n"
+	s += "Sha1: " + tr.FileSha1Hex + "\n"
+	s += "Cmd: " + tr.Cmd + "\n"
+	for _, v := range tr.Env {
+		s += "Env: " + v + "\n"
+	}
+	for _, l := range tr.Restrict {
+		s += "Restrict: " + l + "\n"
+	}
+	if tr.Settings != "" {
+		// it's next to tests.txt in repro zip
+		s += "Settings: " + filepath.Base(tr.Settings) + "\n"
+	}
+	for _, a := range tr.Asserts {
+		s += "Assert: " + a + "\n"
+	}
+	if tr.Stabilize {
+		s += "Stabilize: true\n"
+	}
+	if tr.Budget != "" {
+		s += "Budget: " + tr.Budget + "\n"
+	}
+	if tr.MaxRenderMs != 0 {
+		s += fmt.Sprintf("MaxRenderMs: %g\n", tr.MaxRenderMs)
+	}
+	for _, pageNo := range sortedPageNos(tr.ExpectedPages) {
+		s += fmt.Sprintf("Out[%d]: %s\n", pageNo, tr.ExpectedPages[pageNo])
+	}
+	if tr.ExpectedOutput != "" {
+		s += "Out: " + tr.ExpectedOutput + "\n"
+	}
+	return s
+}
+
+// handleRepro sends a zip with test definition, test file and artifacts
+func handleRepro(w http.ResponseWriter, r *http.Request) {
+	tr, err := getRunTest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// sha1 comes from results.json so it can be short or missing
+	name := tr.FileSha1Hex
+	if len(name) > 8 {
+		name = name[:8]
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="repro-%s.zip"`, name))
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+	fw, err := zw.Create("tests.txt")
+	if err != nil {
+		return
+	}
+	io.WriteString(fw, resultToTestDef(tr))
+	fw, err = zw.Create("output.txt")
+	if err != nil {
+		return
+	}
+	io.WriteString(fw, tr.Output)
+	if path := findCachedFile(tr.FileSha1Hex); path != "" {
+		addFileToZip(zw, filepath.Base(path), path)
+	}
+	if tr.Settings != "" {
+		addFileToZip(zw, filepath.Base(tr.Settings), tr.Settings)
+	}
+	for _, path := range tr.Artifacts {
+		addFileToZip(zw, "artifacts/"+filepath.Base(path), path)
+	}
+}
+
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	port := fs.Int("port", 8080, "port to listen on")
+	fs.Parse(args)
+
+	http.HandleFunc("/", handleIndex)
+	http.HandleFunc("/run", handleRun)
+	http.HandleFunc("/test", handleTest)
+	http.HandleFunc("/file", handleFile)
+	http.HandleFunc("/repro", handleRepro)
+	http.HandleFunc("/runs", handleAPIRuns)
+	http.HandleFunc("/runs/", handleAPIRuns)
+	http.HandleFunc("/tests/", handleAPITests)
+	addr := fmt.Sprintf("localhost:%d", *port)
+	fmt.Printf("serving results from '%s' on http://%s\n", flgHistory, addr)
+	err := http.ListenAndServe(addr, nil)
+	fatalIfErr(err)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+SumatraPDF remembers state (zoom, session, thumbnails) in
+SumatraPDF-settings.txt. So that one test can't affect another, each
+test gets its own, initially empty, settings dir passed with -appdata.
+-shared-settings disables that.
+
+Settings: dark-mode-settings.txt installs a settings file (path relative
+to the tests file) as SumatraPDF-settings.txt before running the test.
+It can use $file etc.
+*/
+
+const settingsFileName = "SumatraPDF-settings.txt"
+
+func parseSettingsPath(pos string, testsPath string, val string) string {
+	path := val
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(testsPath), val)
+	}
+	panicIf(!fileExists(path), "%s: Settings: file '%s' doesn't exist\n", pos, path)
+	return path
+}
+
+func installSettings(t *Test, dir string) error {
+	d, err := ioutil.ReadFile(longPath(t.Settings))
+	if err != nil {
+		return err
+	}
+	s := substVars(string(d), t)
+	return ioutil.WriteFile(longPath(filepath.Join(dir, settingsFileName)), []byte(s), 0644)
+}
+
+// settingsSha1Hex is for result cache, "" if the test doesn't use Settings:
+func settingsSha1Hex(t *Test) string {
+	if t.Settings == "" {
+		return ""
+	}
+	s, _ := sha1HexOfFile(t.Settings)
+	return s
+}
+
+func isSumatraCmd(t *Test) bool {
+	name := strings.ToLower(filepath.Base(t.CmdName))
+	return strings.HasPrefix(name, "sumatrapdf")
+}
+
+func hasArg(args []string, arg string) bool {
+	for _, s := range args {
+		if strings.EqualFold(s, arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// settingsArgs creates settings dir for the test and returns arguments
+// that tell SumatraPDF to use it
+func settingsArgs(t *Test) ([]string, error) {
+	if flgSharedSettings || !isSumatraCmd(t) || hasArg(t.CmdArgs, "-appdata") {
+		if t.Settings != "" {
+			return nil, fmt.Errorf("Settings: needs isolated settings dir, can't be used with -shared-settings or -appdata")
+		}
+		return nil, nil
+	}
+	dir := filepath.Join(t.TempDir, "appdata")
+	err := os.MkdirAll(longPath(dir), 0755)
+	if err != nil {
+		return nil, err
+	}
+	if t.Settings != "" {
+		err = installSettings(t, dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []string{"-appdata", longPath(dir)}, nil
+}
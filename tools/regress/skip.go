@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Skip: <reason> disables a test without commenting it out or deleting it:
+
+Skip: crashes in mupdf, re-enable after the update
+
+Skipped tests are not run and don't need their test file, but they're
+still parsed (so they don't rot) and listed with their reason at the end
+of the run and in JUnit results, so that they're not forgotten.
+-run, -tags etc. apply first, we only report skipped tests that would run.
+*/
+
+var (
+	// tests with Skip:, set aside by filterSkippedTests
+	skippedTests []*Test
+)
+
+func parseSkip(pos string, val string) string {
+	panicIf(strings.TrimSpace(val) == "", "%s: Skip: must have a reason why the test is skipped\n", pos)
+	return val
+}
+
+// filterSkippedTests removes tests with Skip: and remembers them for
+// the summary
+func filterSkippedTests(tests []*Test) []*Test {
+	var res []*Test
+	for _, t := range tests {
+		if t.SkipReason != "" {
+			skippedTests = append(skippedTests, t)
+			continue
+		}
+		res = append(res, t)
+	}
+	if len(skippedTests) > 0 {
+		fmt.Printf("skipping %d tests with Skip:\n", len(skippedTests))
+	}
+	return res
+}
+
+// dumpSkippedTests shows e.g. "Skipped: render-2 (tests.txt:12): crashes in mupdf"
+func dumpSkippedTests() {
+	for _, t := range skippedTests {
+		fmt.Printf("Skipped: %s (%s): %s\n", testDisplayName(t), testPos(t), t.SkipReason)
+	}
+	if len(skippedTests) > 0 {
+		fmt.Printf("%d tests skipped with Skip:\n", len(skippedTests))
+	}
+}
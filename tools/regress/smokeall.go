@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+regress smoke-all opens every file in the cache, not only files used by
+tests, with a default command for its format (see formatcmds.go) or -cmd.
+It only checks that SumatraPDF doesn't crash or hang (-timeout) so it's
+robustness coverage from files we already store.
+*/
+
+const smokeAllDefaultArgs = "-render 1 $file"
+
+// set by smoke-all, runRegress generates tests instead of reading them
+var (
+	runSmokeAll  bool
+	smokeAllArgs string
+)
+
+func smokeAll(args []string) {
+	fs := flag.NewFlagSet("smoke-all", flag.ExitOnError)
+	cmdArgs := fs.String("cmd", "", "arguments for SumatraPDF.exe, $file is a file from the cache (default: command for file's format from -format-cmds or '"+smokeAllDefaultArgs+"')")
+	fs.Parse(args)
+	panicIf(*cmdArgs != "" && !strings.Contains(*cmdArgs, "$file"), "smoke-all: -cmd must use $file\n")
+	runSmokeAll = true
+	smokeAllArgs = *cmdArgs
+	runRegress()
+}
+
+func genSmokeAllTests() []*Test {
+	var sha1s []string
+	for sha1Hex := range testFilesBySha1 {
+		sha1s = append(sha1s, sha1Hex)
+	}
+	sort.Strings(sha1s)
+	var res []*Test
+	for i, sha1Hex := range sha1s {
+		pos := fmt.Sprintf("smoke-all:%d", i+1)
+		t := &Test{
+			Name:        "smoke-all " + sha1Hex,
+			FileSha1Hex: sha1Hex,
+			Path:        "smoke-all",
+			LineNo:      i + 1,
+		}
+		if meta := testFilesBySha1[sha1Hex].Meta; meta != nil {
+			t.FileURL = meta.URL
+			t.OrigName = meta.OrigName
+		}
+		cmd := "SumatraPDF.exe " + smokeAllArgs
+		if smokeAllArgs == "" {
+			cmd = formatCmd(testFileFormat(t))
+		}
+		if cmd == "" {
+			cmd = "SumatraPDF.exe " + smokeAllDefaultArgs
+		}
+		setCmd(t, cmd)
+		// broken files can fail to open, only crashes and hangs fail the test
+		t.Asserts = []*Assert{parseAssert(pos, i+1, smokeFlagsAssert)}
+		res = append(res, t)
+	}
+	fmt.Printf("smoke-all: %d files in the cache\n", len(res))
+	return res
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+/*
+-smoke-flags runs a generated suite instead of -tests: SumatraPDF with
+classes of bad command-line arguments (invalid values, missing arguments,
+conflicting and unknown flags) on one small file from -tests. A test
+passes if the process doesn't crash, doesn't hang and exits with a sane
+exit code. Every case includes -render or -extract-text with a valid
+page so that SumatraPDF exits instead of opening a window.
+*/
+
+var smokeFlagsCases = []string{
+	// invalid values
+	"-render 0 $file",
+	"-render -1 $file",
+	"-render abc $file",
+	"-render 99999 $file",
+	"-render 1 -zoom abc $file",
+	"-render 1 -zoom 0 $file",
+	"-render 1 -zoom -5 $file",
+	"-render 1 -zoom 100000 $file",
+	"-extract-text 0 $file",
+	"-extract-text abc $file",
+	"-extract-text 99999 $file",
+	"-render 1 -page abc $file",
+	"-render 1 -view nonsense $file",
+	"-render 1 -bgcolor notacolor $file",
+	"-render 1 -lang xx-nonsense $file",
+	// missing arguments
+	"-render 1 $file -zoom",
+	"-render 1 $file -page",
+	"-render 1 $file -view",
+	"-render 1 $file -bgcolor",
+	"-render 1 $file -lang",
+	"-extract-text 1 $file -nameddest",
+	"-render 1",
+	"-extract-text 1",
+	// conflicting flags
+	"-render 1 -extract-text 1 $file",
+	"-render 1 -render 2 $file",
+	"-extract-text 1 -render 1 $file",
+	"-render 1 -presentation -fullscreen $file",
+	"-render 1 -restrict $file",
+	// unknown flags and odd files
+	"-render 1 -no-such-flag $file",
+	"-render 1 --render 1 $file",
+	"-render 1 $file $file",
+	"-render 1 $file.does-not-exist",
+}
+
+// sane exit code is not a crash (NTSTATUS) or a negative value
+const smokeFlagsAssert = "exitCode >= 0 && exitCode <= 255"
+
+// pickSmokeFile returns a test whose file we use, preferring pdf files
+func pickSmokeFile(tests []*Test) *Test {
+	for _, t := range tests {
+		if strings.EqualFold(filepath.Ext(t.FileURL), ".pdf") {
+			return t
+		}
+	}
+	panicIf(len(tests) == 0, "no tests in '%s' to pick a file for -smoke-flags\n", flgTests)
+	return tests[0]
+}
+
+func genSmokeFlagsTests(tests []*Test) []*Test {
+	src := pickSmokeFile(tests)
+	fmt.Printf("-smoke-flags: using '%s' (%s)\n", src.FileURL, src.FileSha1Hex)
+	var res []*Test
+	for i, args := range smokeFlagsCases {
+		pos := fmt.Sprintf("smoke-flags:%d", i+1)
+		t := &Test{
+			Name:        "smoke-flags " + args,
+			CmdUnparsed: "SumatraPDF.exe " + args,
+			FileSha1Hex: src.FileSha1Hex,
+			FileURL:     src.FileURL,
+			OrigName:    src.OrigName,
+			Path:        "smoke-flags",
+			LineNo:      i + 1,
+			CmdName:     "SumatraPDF.exe",
+			CmdArgs:     strings.Split(args, " "),
+		}
+		t.Asserts = []*Assert{parseAssert(pos, i+1, smokeFlagsAssert)}
+		res = append(res, t)
+	}
+	return res
+}
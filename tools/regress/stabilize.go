@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+/*
+Some commands print different output the first time they run e.g. messages
+about populating font cache. For tests with Stabilize: true we re-run the
+command until two consecutive runs produce the same output (at most
+maxStabilizeRuns times) and check the output of the last run.
+*/
+
+const maxStabilizeRuns = 5
+
+func parseStabilize(pos string, val string) bool {
+	v, err := strconv.ParseBool(val)
+	panicIf(err != nil, "%s: Stabilize: must be true or false, got '%s'\n", pos, val)
+	return v
+}
+
+func runTestCmd(t *Test, cmdPath string, args []string) (*exec.Cmd, []byte, error) {
+	newCmd := func() *exec.Cmd {
+		cmd := exec.Command(cmdPath, args...)
+		cmd.Env = testEnv(t)
+		return cmd
+	}
+	fmt.Printf("Running: %s\n", cmdToStrLong(newCmd()))
+	timeStart := time.Now()
+	t.PeakMemoryKB = 0
+	cmd, res, err := runCmdWithLaunchRetry(t, newCmd)
+	t.Duration = time.Since(timeStart)
+	if kb := peakMemoryKB(cmd.ProcessState); kb > 0 {
+		t.PeakMemoryKB = kb
+	}
+	addProcessCPUTime(cmd.ProcessState)
+	return cmd, res, err
+}
+
+// exited on its own, possibly with non-zero exit code
+func isNormalExit(err error) bool {
+	if err == nil {
+		return true
+	}
+	_, ok := err.(*exec.ExitError)
+	return ok && !isCrashError(err)
+}
+
+func runTestCmdStabilized(t *Test, cmdPath string, args []string) (*exec.Cmd, []byte, error) {
+	cmd, res, err := runTestCmd(t, cmdPath, args)
+	if !t.Stabilize {
+		return cmd, res, err
+	}
+	for i := 1; i < maxStabilizeRuns; i++ {
+		// crashes and hangs are not something to wait out
+		if !isNormalExit(err) {
+			return cmd, res, err
+		}
+		prev := res
+		cmd, res, err = runTestCmd(t, cmdPath, args)
+		if bytes.Equal(prev, res) {
+			return cmd, res, err
+		}
+	}
+	fmt.Printf("output didn't stabilize after %d runs\n", maxStabilizeRuns)
+	return cmd, res, err
+}
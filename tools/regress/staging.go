@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// copyFile preserves permissions of src e.g. executable bit
+func copyFile(dst, src string) error {
+	fsrc, err := os.Open(longPath(src))
+	if err != nil {
+		return err
+	}
+	defer fsrc.Close()
+	fi, err := fsrc.Stat()
+	if err != nil {
+		return err
+	}
+	fdst, err := os.OpenFile(longPath(dst), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fdst, fsrc)
+	err2 := fdst.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+// linkOrCopyFile creates a hard link if possible (same volume) so that
+// staging large files doesn't use more disk space and falls back to copying.
+// Writing to a hard link (or changing its mode) changes the original file.
+func linkOrCopyFile(dst, src string) error {
+	err := os.Link(longPath(src), longPath(dst))
+	if err == nil {
+		return nil
+	}
+	return copyFile(dst, src)
+}
+
+var (
+	// all temporary files of a run go there
+	scratchDir string
+	// number of failed tests whose temp dir we kept for inspection
+	nKeptTempDirs int
+)
+
+func getScratchDirMust() string {
+	if scratchDir == "" {
+		d, err := ioutil.TempDir("", "regress-run-")
+		fatalIfErr(err)
+		scratchDir = d
+	}
+	return scratchDir
+}
+
+// removeScratchDir is called when the run finishes. It's only removed if
+// all tests passed, temp dirs of failed tests are kept to debug their
+// on-disk side effects. With -keep-temp we keep all temp files.
+func removeScratchDir() {
+	if scratchDir == "" {
+		return
+	}
+	if flgKeepTemp {
+		fmt.Printf("kept temp files in '%s'\n", scratchDir)
+		return
+	}
+	if nKeptTempDirs > 0 {
+		fmt.Printf("kept temp files of %d failed tests in '%s'\n", nKeptTempDirs, scratchDir)
+		return
+	}
+	os.RemoveAll(longPath(scratchDir))
+}
+
+// stageTestFile creates a temp directory for the test. If SaveAs: is
+// given, it links test file there under that name so that we can test
+// handling of unusual file names (unicode, spaces etc.). Otherwise we use
+// the file from cache directly. Either way Cmd: must not modify the file
+// in the cache, see checkTestFileUnchanged.
+func stageTestFile(t *Test) error {
+	dir, err := ioutil.TempDir(longPath(getScratchDirMust()), "test-")
+	if err != nil {
+		return err
+	}
+	t.TempDir = dir
+	err = os.Mkdir(longPath(testTmpDir(t)), 0755)
+	if err != nil {
+		return err
+	}
+	t.testCachePath = t.FilePath
+	t.testFileInfo, err = os.Stat(longPath(t.FilePath))
+	if err != nil || t.SaveAs == "" {
+		return err
+	}
+	name := strings.Replace(t.SaveAs, "$origname", t.OrigName, -1)
+	dst := filepath.Join(dir, name)
+	err = linkOrCopyFile(dst, t.FilePath)
+	if err != nil {
+		return err
+	}
+	t.FilePath = longPath(dst)
+	return nil
+}
+
+const testFileModifiedPrefix = "test file: "
+
+// checkTestFileUnchanged fails the test if Cmd: modified or deleted the
+// test file in the cache, directly or through the hard link made for
+// SaveAs:. We only compute sha1 if size or modification time changed.
+// We remove the file from the cache so that the next run downloads it
+// again, later tests of this run that use it fail as infrastructure errors.
+func checkTestFileUnchanged(t *Test) []string {
+	before := t.testFileInfo
+	if before == nil {
+		return nil
+	}
+	path := t.testCachePath
+	after, err := os.Stat(longPath(path))
+	if err == nil && after.Size() == before.Size() && after.ModTime().Equal(before.ModTime()) {
+		return nil
+	}
+	if err == nil {
+		if sha1Hex, err := sha1HexOfFile(path); err == nil && sha1Hex == t.FileSha1Hex {
+			return nil
+		}
+	}
+	os.Remove(longPath(path))
+	delete(testFilesBySha1, t.FileSha1Hex)
+	return []string{fmt.Sprintf("%sCmd: modified or deleted '%s' in the cache, removed it", testFileModifiedPrefix, path)}
+}
+
+func unstageTestFile(t *Test) {
+	if t.TempDir == "" || flgKeepTemp {
+		return
+	}
+	if isFailedTest(t) {
+		nKeptTempDirs++
+		return
+	}
+	os.RemoveAll(longPath(t.TempDir))
+	t.TempDir = ""
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+stdout and stderr of Cmd: are captured separately. Out: and assertions
+check stdout, Err: checks stderr the same way Out: checks stdout (it can
+use $file etc., paths are normalized and Err: <<END starts a multi-line
+block):
+
+Err: error: couldn't open file
+
+Tests without Err: don't check stderr but it's shown when a test fails,
+it often has diagnostics (e.g. mupdf warnings) that explain the failure.
+*/
+
+const stderrMismatchPrefix = "stderr: "
+
+func checkStderr(t *Test) []string {
+	if t.ExpectedStderr == "" {
+		return nil
+	}
+	expected := normalizePaths(substVars(t.ExpectedStderr, t), t)
+	got := normalizePaths(t.Stderr, t)
+	if isOutputEqual(got, expected) {
+		return nil
+	}
+	return []string{fmt.Sprintf("%sdiffers from Err:\n%s", stderrMismatchPrefix, diffStrings(expected, got))}
+}
+
+func hasStderrMismatch(t *Test) bool {
+	for _, s := range t.OutputMismatches {
+		if strings.HasPrefix(s, stderrMismatchPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func dumpStderr(t *Test) {
+	if t.Stderr != "" {
+		fmt.Printf("Stderr: '%s'\n", t.Stderr)
+	}
+}
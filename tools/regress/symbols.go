@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/zip"
+	"debug/pe"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Dumps of crashed or hung tests are symbolized with cdb (from Debugging
+Tools for Windows) into a .txt file next to the dump. Symbols come from:
+- .pdb files next to the tested binary (local builds)
+- published symbols archive for binary's version (-symbols-url)
+- symbol server (-symbol-server) for system dlls
+
+-symbols-url can use ${ver} (e.g. 3.4.6), ${build} (last part of version,
+used for pre-release builds) and ${arch} (-64, -arm64 or empty for 32-bit).
+By default we try release and pre-release archives.
+*/
+
+var defaultSymbolsURLs = []string{
+	"https://www.sumatrapdfreader.org/dl/rel/${ver}/SumatraPDF-${ver}${arch}.pdb.zip",
+	"https://www.sumatrapdfreader.org/dl/prerel/${build}/SumatraPDF${arch}.pdb.zip",
+}
+
+// symbols dirs by binary path, "" if we failed to get them
+var symbolsDirs = map[string]string{}
+
+func binaryArchSuffix(exePath string) string {
+	f, err := pe.Open(longPath(exePath))
+	if err != nil {
+		return "-64"
+	}
+	defer f.Close()
+	switch f.FileHeader.Machine {
+	case pe.IMAGE_FILE_MACHINE_I386:
+		return ""
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		return "-arm64"
+	}
+	return "-64"
+}
+
+func expandSymbolsURL(uri string, ver string, arch string) string {
+	// 3.4.6.0 => 3.4.6
+	for strings.HasSuffix(ver, ".0") {
+		ver = strings.TrimSuffix(ver, ".0")
+	}
+	parts := strings.Split(ver, ".")
+	r := strings.NewReplacer("${ver}", ver, "${build}", parts[len(parts)-1], "${arch}", arch)
+	return r.Replace(uri)
+}
+
+func extractPdbs(zipPath string, dir string) error {
+	zr, err := zip.OpenReader(longPath(zipPath))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if !strings.EqualFold(filepath.Ext(f.Name), ".pdb") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		dst, err := os.Create(longPath(filepath.Join(dir, filepath.Base(f.Name))))
+		if err == nil {
+			_, err = io.Copy(dst, rc)
+			err2 := dst.Close()
+			if err == nil {
+				err = err2
+			}
+		}
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dlSymbols(uri string, dir string) error {
+	rsp, err := http.Get(uri)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("'%s' returned %s", uri, rsp.Status)
+	}
+	zipPath := filepath.Join(dir, "symbols.zip")
+	d, err := ioutil.ReadAll(rsp.Body)
+	if err == nil {
+		err = ioutil.WriteFile(longPath(zipPath), d, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	defer os.Remove(longPath(zipPath))
+	return extractPdbs(zipPath, dir)
+}
+
+// getSymbolsDir returns dir with published symbols for the binary,
+// downloading them if needed
+func getSymbolsDir(exePath string) string {
+	if dir, ok := symbolsDirs[exePath]; ok {
+		return dir
+	}
+	symbolsDirs[exePath] = ""
+	ver := binaryVersion(exePath)
+	if ver == "" {
+		return ""
+	}
+	arch := binaryArchSuffix(exePath)
+	dir := filepath.Join("out", "regress", "symbols", ver+arch)
+	if dirExists(dir) {
+		symbolsDirs[exePath] = dir
+		return dir
+	}
+	urls := defaultSymbolsURLs
+	if flgSymbolsURL != "" {
+		urls = []string{flgSymbolsURL}
+	}
+	err := os.MkdirAll(longPath(dir), 0755)
+	if err != nil {
+		return ""
+	}
+	for _, uri := range urls {
+		uri = expandSymbolsURL(uri, ver, arch)
+		fmt.Printf("downloading symbols from '%s'\n", uri)
+		err = dlSymbols(uri, dir)
+		if err == nil {
+			symbolsDirs[exePath] = dir
+			return dir
+		}
+		fmt.Printf("failed to download symbols: %s\n", err)
+	}
+	os.RemoveAll(longPath(dir))
+	return ""
+}
+
+func symbolPath(exePath string) string {
+	absExeDir, _ := filepath.Abs(filepath.Dir(exePath))
+	parts := []string{absExeDir}
+	if dir := getSymbolsDir(exePath); dir != "" {
+		absDir, _ := filepath.Abs(dir)
+		parts = append(parts, absDir)
+	}
+	if flgSymbolServer != "" {
+		cache, _ := filepath.Abs(filepath.Join("out", "regress", "symbols", "cache"))
+		parts = append(parts, "srv*"+cache+"*"+flgSymbolServer)
+	}
+	return strings.Join(parts, ";")
+}
+
+// symbolizeDump saves stacks of all threads in the dump as text and adds
+// it to artifacts of the test. Does nothing if cdb isn't installed.
+func symbolizeDump(t *Test, dumpPath string) {
+	if !strings.EqualFold(filepath.Ext(dumpPath), ".dmp") {
+		return
+	}
+	if _, err := exec.LookPath(flgCdbPath); err != nil {
+		return
+	}
+	cmd := exec.Command(flgCdbPath, "-z", dumpPath, "-y", symbolPath(t.CmdPath), "-lines", "-c", ".ecxr;kP;~*k;q")
+	fmt.Printf("Running: %s\n", cmdToStrLong(cmd))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Printf("failed to symbolize '%s': %s\n", dumpPath, err)
+		return
+	}
+	path := strings.TrimSuffix(dumpPath, filepath.Ext(dumpPath)) + ".txt"
+	err = ioutil.WriteFile(longPath(path), out, 0644)
+	if err != nil {
+		fmt.Printf("failed to save stacks: %s\n", err)
+		return
+	}
+	t.Artifacts = append(t.Artifacts, path)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Tags: render, slow puts a test in groups that can be run or skipped
+without editing the tests file:
+
+-tags render,text       : only run tests with any of those tags
+-skip-tags slow         : don't run tests with any of those tags
+
+Tags are case-insensitive.
+*/
+
+func parseTagList(s string) []string {
+	var res []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" {
+			res = append(res, tag)
+		}
+	}
+	return res
+}
+
+func parseTags(pos string, val string) []string {
+	tags := parseTagList(val)
+	panicIf(len(tags) == 0, "%s: Tags: must be a comma-separated list of tags\n", pos)
+	for _, tag := range tags {
+		panicIf(strings.ContainsAny(tag, " \t"), "%s: tag '%s' can't have spaces\n", pos, tag)
+	}
+	return tags
+}
+
+func hasAnyTag(t *Test, tags []string) bool {
+	for _, tag := range t.Tags {
+		for _, s := range tags {
+			if tag == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterTestsByTags applies -tags and -skip-tags
+func filterTestsByTags(tests []*Test) []*Test {
+	if flgTags == "" && flgSkipTags == "" {
+		return tests
+	}
+	include := parseTagList(flgTags)
+	exclude := parseTagList(flgSkipTags)
+	var res []*Test
+	for _, t := range tests {
+		if len(include) > 0 && !hasAnyTag(t, include) {
+			continue
+		}
+		if hasAnyTag(t, exclude) {
+			continue
+		}
+		res = append(res, t)
+	}
+	fmt.Printf("-tags '%s' -skip-tags '%s': %d of %d tests\n", flgTags, flgSkipTags, len(res), len(tests))
+	return res
+}
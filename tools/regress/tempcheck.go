@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Each test process gets its own TMP, TEMP and TMPDIR: ${TempDir}/tmp. After
+the test we look for files left there and for new files in the real temp
+dir (written by code that ignores TMP). SumatraPDF leaving temp files
+behind is a recurring complaint from users.
+
+Leftovers are printed as a warning. Tests with CleanTemp: true (or all
+tests with -check-temp-cleanup) fail if there are any.
+*/
+
+const tempLeftoversPrefix = "left temp files: "
+
+func parseCleanTemp(pos string, val string) bool {
+	v, err := strconv.ParseBool(val)
+	panicIf(err != nil, "%s: CleanTemp: must be true or false, got '%s'\n", pos, val)
+	return v
+}
+
+// testTmpDir is TMP of the test process
+func testTmpDir(t *Test) string {
+	return filepath.Join(t.TempDir, "tmp")
+}
+
+// testTmpEnv returns TMP etc. variables pointing to test's temp dir
+func testTmpEnv(t *Test) []string {
+	dir := testTmpDir(t)
+	return []string{"TMP=" + dir, "TEMP=" + dir, "TMPDIR=" + dir}
+}
+
+// listRealTemp returns names of files in our (not test's) temp dir
+func listRealTemp() map[string]bool {
+	res := map[string]bool{}
+	files, err := ioutil.ReadDir(longPath(os.TempDir()))
+	if err != nil {
+		return res
+	}
+	for _, fi := range files {
+		res[fi.Name()] = true
+	}
+	return res
+}
+
+func newRealTempFiles(before map[string]bool) []string {
+	var res []string
+	scratch := filepath.Base(getScratchDirMust())
+	for name := range listRealTemp() {
+		if !before[name] && name != scratch {
+			res = append(res, filepath.Join(os.TempDir(), name))
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// filesInTestTmpDir returns paths relative to test's temp dir
+func filesInTestTmpDir(t *Test) []string {
+	var res []string
+	dir := testTmpDir(t)
+	filepath.Walk(longPath(dir), func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(longPath(dir), path)
+		if err == nil {
+			res = append(res, filepath.Join("$TMP", rel))
+		}
+		return nil
+	})
+	sort.Strings(res)
+	return res
+}
+
+// collectTempLeftovers must be called after the test process exited
+func collectTempLeftovers(t *Test, realTempBefore map[string]bool) {
+	t.TempLeftovers = append(filesInTestTmpDir(t), newRealTempFiles(realTempBefore)...)
+	if len(t.TempLeftovers) > 0 {
+		fmt.Printf("test left temp files: %s\n", strings.Join(t.TempLeftovers, ", "))
+	}
+}
+
+func checkTempCleanup(t *Test) []string {
+	if len(t.TempLeftovers) == 0 || !(t.CleanTemp || flgCheckTempCleanup) {
+		return nil
+	}
+	return []string{tempLeftoversPrefix + strings.Join(t.TempLeftovers, ", ")}
+}
+
+func hasTempLeftoversMismatch(t *Test) bool {
+	for _, s := range t.OutputMismatches {
+		if strings.HasPrefix(s, tempLeftoversPrefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Tests can also be defined in a .json file, which is easier to generate
+from other tools than tests.txt:
+
+{
+  "tests": [
+    {
+      "Name": "render",
+      "Url": "https://example.com/f.pdf",
+      "Sha1": "735c700545cf48bac8665768739e10e3a950ba33",
+      "Cmd": "SumatraPDF.exe -render 1 $file",
+      "Out": "rendering page 1",
+      "Env": ["A=1", "B=2"]
+    }
+  ]
+}
+
+Each object has the same fields as a test (or fixture) in tests.txt and
+means the same. A field that can be given multiple times (Env:, Assert:
+etc.) can have an array of values. We convert objects to lines of the
+text format so that all fields are supported without extra code.
+
+"version": 1 at the top level is the version of the format (see
+version.go). Unknown top-level keys are ignored so that we can add e.g.
+metadata later.
+*/
+
+func isJSONTestsFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// lineNoAtOffset returns line of the first non-space, non-comma character
+// at or after off
+func lineNoAtOffset(d []byte, off int64) int {
+	i := int(off)
+	for i < len(d) && strings.IndexByte(" \t\r\n,", d[i]) >= 0 {
+		i++
+	}
+	return bytes.Count(d[:i], []byte{'\n'}) + 1
+}
+
+func jsonFieldValues(pos string, key string, raw json.RawMessage) []string {
+	var v interface{}
+	err := json.Unmarshal(raw, &v)
+	panicIf(err != nil, "%s: invalid value of '%s': %s\n", pos, key, err)
+	vals, isArray := v.([]interface{})
+	if !isArray {
+		vals = []interface{}{v}
+	}
+	var res []string
+	for _, v := range vals {
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case bool, float64:
+			s = fmt.Sprintf("%v", v)
+		default:
+			panicIf(true, "%s: value of '%s' must be a string, number, bool or array of them\n", pos, key)
+		}
+		panicIf(strings.ContainsAny(s, "\r\n"), "%s: value of '%s' can't have new lines\n", pos, key)
+		res = append(res, s)
+	}
+	return res
+}
+
+func jsonTokenMust(dec *json.Decoder, pos string, expected json.Delim) {
+	tok, err := dec.Token()
+	panicIf(err != nil, "%s: %s\n", pos, err)
+	panicIf(tok != expected, "%s: expected '%s', got '%v'\n", pos, expected, tok)
+}
+
+// jsonTestToLines converts one test object, keeping order of fields
+func jsonTestToLines(path string, d []byte, dec *json.Decoder) []TestLine {
+	lineNo := lineNoAtOffset(d, dec.InputOffset())
+	pos := fmt.Sprintf("%s:%d", path, lineNo)
+	jsonTokenMust(dec, pos, '{')
+	var res []TestLine
+	for dec.More() {
+		fieldLineNo := lineNoAtOffset(d, dec.InputOffset())
+		tok, err := dec.Token()
+		panicIf(err != nil, "%s: %s\n", pos, err)
+		key := tok.(string)
+		var raw json.RawMessage
+		err = dec.Decode(&raw)
+		panicIf(err != nil, "%s: %s\n", pos, err)
+		fieldPos := fmt.Sprintf("%s:%d", path, fieldLineNo)
+		panicIf(key == "" || strings.Contains(key, ":"), "%s: invalid field name '%s'\n", fieldPos, key)
+		for _, val := range jsonFieldValues(fieldPos, key, raw) {
+			res = append(res, TestLine{Text: key + ": " + val, LineNo: fieldLineNo})
+		}
+	}
+	jsonTokenMust(dec, pos, '}')
+	panicIf(len(res) == 0, "%s: empty test\n", pos)
+	// empty line separates tests
+	return append(res, TestLine{LineNo: lineNo})
+}
+
+// jsonToTestLines converts .json tests file to lines of tests.txt format
+func jsonToTestLines(path string, d []byte) []TestLine {
+	var res []TestLine
+	dec := json.NewDecoder(bytes.NewReader(d))
+	jsonTokenMust(dec, path, '{')
+	foundTests := false
+	for dec.More() {
+		keyLineNo := lineNoAtOffset(d, dec.InputOffset())
+		tok, err := dec.Token()
+		panicIf(err != nil, "%s: %s\n", path, err)
+		if tok == "version" {
+			var raw json.RawMessage
+			err = dec.Decode(&raw)
+			panicIf(err != nil, "%s: %s\n", path, err)
+			pos := fmt.Sprintf("%s:%d", path, keyLineNo)
+			vals := jsonFieldValues(pos, "version", raw)
+			panicIf(len(vals) != 1, "%s: \"version\" must be a number\n", pos)
+			// it applies to the whole file, wherever it is
+			res = append(versionToTestLines(vals[0], keyLineNo), res...)
+			continue
+		}
+		if tok != "tests" {
+			var ignored json.RawMessage
+			err = dec.Decode(&ignored)
+			panicIf(err != nil, "%s: %s\n", path, err)
+			continue
+		}
+		foundTests = true
+		jsonTokenMust(dec, path, '[')
+		for dec.More() {
+			res = append(res, jsonTestToLines(path, d, dec)...)
+		}
+		jsonTokenMust(dec, path, ']')
+	}
+	jsonTokenMust(dec, path, '}')
+	panicIf(!foundTests, "%s: no \"tests\" array\n", path)
+	return res
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Tests can also be defined in a .yaml (or .yml) file, where suites can be
+nested and share fields of their tests:
+
+common:
+  pdf: &pdf
+    Url: https://example.com/f.pdf
+    Sha1: 735c700545cf48bac8665768739e10e3a950ba33
+
+defaults:
+  Cmd: SumatraPDF.exe -render 1 $file
+tests:
+  - <<: *pdf
+    Out: "rendering page 1 for '$file'"
+  - defaults:
+      Cmd: SumatraPDF.exe -extract-text 1 $file
+    tests:
+      - <<: *pdf
+        Out: hello
+        Env: [A=1, B=2]
+
+A suite is a mapping with tests: (a list of tests and nested suites) and
+optional defaults: with fields used by all its tests, unless a test sets
+the same field. The top-level mapping is the root suite, its other keys
+are ignored so they can hold anchors. Fields are the same as in tests.txt,
+a list (block or [a, b]) means the field is given multiple times.
+
+We don't use a yaml library (this tool only uses standard library) so we
+only support the subset above: block mappings and sequences, plain and
+quoted scalars, flow sequences of scalars, anchors, aliases and << merge
+keys. Values can't span multiple lines.
+*/
+
+type yamlNodeKind int
+
+const (
+	yamlScalar yamlNodeKind = iota
+	yamlMapping
+	yamlSequence
+)
+
+type yamlNode struct {
+	kind   yamlNodeKind
+	lineNo int
+	value  string      // yamlScalar
+	keys   []string    // yamlMapping, in order
+	values []*yamlNode // yamlMapping values, yamlSequence items
+}
+
+func (n *yamlNode) get(key string) *yamlNode {
+	for i, k := range n.keys {
+		if k == key {
+			return n.values[i]
+		}
+	}
+	return nil
+}
+
+type yamlLine struct {
+	indent int
+	text   string
+	lineNo int
+}
+
+type yamlParser struct {
+	path    string
+	lines   []*yamlLine
+	pos     int
+	anchors map[string]*yamlNode
+}
+
+func isYAMLTestsFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// stripYAMLComment removes # comment that is not inside quotes
+func stripYAMLComment(s string) string {
+	var quote rune
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func toYAMLLines(d []byte) []*yamlLine {
+	var res []*yamlLine
+	s := strings.Replace(string(d), "\r\n", "\n", -1)
+	for i, l := range strings.Split(s, "\n") {
+		l = strings.TrimRight(stripYAMLComment(l), " \t")
+		text := strings.TrimLeft(l, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		res = append(res, &yamlLine{indent: len(l) - len(text), text: text, lineNo: i + 1})
+	}
+	return res
+}
+
+func (p *yamlParser) errPos(lineNo int) string {
+	return fmt.Sprintf("%s:%d", p.path, lineNo)
+}
+
+func (p *yamlParser) cur() *yamlLine {
+	if p.pos < len(p.lines) {
+		return p.lines[p.pos]
+	}
+	return nil
+}
+
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits "key: value" and returns ok false if s is not a
+// mapping entry
+func splitYAMLKey(s string) (string, string, bool) {
+	if strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'") || strings.HasPrefix(s, "[") {
+		return "", "", false
+	}
+	if strings.HasSuffix(s, ":") && !strings.Contains(s, ": ") {
+		return s[:len(s)-1], "", true
+	}
+	idx := strings.Index(s, ": ")
+	if idx <= 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(s[:idx]), strings.TrimSpace(s[idx+2:]), true
+}
+
+func (p *yamlParser) parseScalar(s string, lineNo int) string {
+	pos := p.errPos(lineNo)
+	if len(s) > 0 && (s[0] == '"' || s[0] == '\'') {
+		q := s[0]
+		panicIf(len(s) < 2 || s[len(s)-1] != q, "%s: unterminated string %s\n", pos, s)
+		s = s[1 : len(s)-1]
+		if q == '\'' {
+			return strings.Replace(s, "''", "'", -1)
+		}
+		r := strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\t`, "\t", `\n`, "\n")
+		return r.Replace(s)
+	}
+	panicIf(s == "|" || s == ">" || strings.HasPrefix(s, "{"), "%s: '%s' is not supported, see testsyaml.go\n", pos, s)
+	return s
+}
+
+// parseFlowSeq parses [a, b, "c"] of scalars
+func (p *yamlParser) parseFlowSeq(s string, lineNo int) *yamlNode {
+	panicIf(!strings.HasSuffix(s, "]"), "%s: unterminated [ or multi-line list\n", p.errPos(lineNo))
+	n := &yamlNode{kind: yamlSequence, lineNo: lineNo}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if s == "" {
+		return n
+	}
+	var items []string
+	var quote rune
+	start := 0
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	items = append(items, s[start:])
+	for _, item := range items {
+		v := p.parseScalar(strings.TrimSpace(item), lineNo)
+		n.values = append(n.values, &yamlNode{kind: yamlScalar, lineNo: lineNo, value: v})
+	}
+	return n
+}
+
+// parseValue parses value after "key:" or "-", which is either on the same
+// line or a block in following lines indented more than indent
+func (p *yamlParser) parseValue(s string, indent int, lineNo int) *yamlNode {
+	anchor := ""
+	if strings.HasPrefix(s, "&") {
+		parts := strings.SplitN(s, " ", 2)
+		anchor = parts[0][1:]
+		s = ""
+		if len(parts) == 2 {
+			s = strings.TrimSpace(parts[1])
+		}
+	}
+	var n *yamlNode
+	switch {
+	case strings.HasPrefix(s, "*"):
+		name := s[1:]
+		n = p.anchors[name]
+		panicIf(n == nil, "%s: unknown alias '*%s'\n", p.errPos(lineNo), name)
+	case strings.HasPrefix(s, "["):
+		n = p.parseFlowSeq(s, lineNo)
+	case s != "":
+		n = &yamlNode{kind: yamlScalar, lineNo: lineNo, value: p.parseScalar(s, lineNo)}
+	default:
+		l := p.cur()
+		// a sequence can be at the same indent as its key
+		if l != nil && (l.indent > indent || (l.indent == indent && isYAMLSeqItem(l.text))) {
+			n = p.parseBlock(l.indent)
+		} else {
+			n = &yamlNode{kind: yamlScalar, lineNo: lineNo}
+		}
+	}
+	if anchor != "" {
+		p.anchors[anchor] = n
+	}
+	return n
+}
+
+func (p *yamlParser) parseBlock(indent int) *yamlNode {
+	if isYAMLSeqItem(p.cur().text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseMapping(indent int) *yamlNode {
+	n := &yamlNode{kind: yamlMapping, lineNo: p.cur().lineNo}
+	for {
+		l := p.cur()
+		if l == nil || l.indent < indent {
+			break
+		}
+		pos := p.errPos(l.lineNo)
+		panicIf(l.indent > indent, "%s: unexpected indentation\n", pos)
+		if isYAMLSeqItem(l.text) {
+			break
+		}
+		key, rest, ok := splitYAMLKey(l.text)
+		panicIf(!ok, "%s: expected 'key: value', got '%s'\n", pos, l.text)
+		panicIf(n.get(key) != nil, "%s: duplicate key '%s'\n", pos, key)
+		p.pos++
+		n.keys = append(n.keys, key)
+		n.values = append(n.values, p.parseValue(rest, indent, l.lineNo))
+	}
+	return n
+}
+
+func (p *yamlParser) parseSequence(indent int) *yamlNode {
+	n := &yamlNode{kind: yamlSequence, lineNo: p.cur().lineNo}
+	for {
+		l := p.cur()
+		if l == nil || l.indent < indent {
+			break
+		}
+		panicIf(l.indent > indent, "%s: unexpected indentation\n", p.errPos(l.lineNo))
+		if !isYAMLSeqItem(l.text) {
+			break
+		}
+		s := strings.TrimSpace(strings.TrimPrefix(l.text, "-"))
+		if _, _, isMapping := splitYAMLKey(s); isMapping && !strings.HasPrefix(s, "&") {
+			// "- key: value" starts a mapping indented by the "- "
+			l.indent += len(l.text) - len(s)
+			l.text = s
+			n.values = append(n.values, p.parseMapping(l.indent))
+			continue
+		}
+		p.pos++
+		n.values = append(n.values, p.parseValue(s, indent, l.lineNo))
+	}
+	return n
+}
+
+func parseYAML(path string, d []byte) *yamlNode {
+	p := &yamlParser{
+		path:    path,
+		lines:   toYAMLLines(d),
+		anchors: map[string]*yamlNode{},
+	}
+	panicIf(len(p.lines) == 0, "%s: empty file\n", path)
+	n := p.parseBlock(p.cur().indent)
+	if l := p.cur(); l != nil {
+		panicIf(true, "%s: unexpected '%s'\n", p.errPos(l.lineNo), l.text)
+	}
+	return n
+}
+
+// yamlField is a test field with its values
+type yamlField struct {
+	name   string
+	values []string
+	lineNo int
+}
+
+// yamlMappingFields returns fields of a test or defaults: with << merged
+func yamlMappingFields(path string, n *yamlNode) []*yamlField {
+	panicIf(n.kind != yamlMapping, "%s:%d: expected a mapping of fields\n", path, n.lineNo)
+	var res []*yamlField
+	for i, key := range n.keys {
+		v := n.values[i]
+		if key == "<<" {
+			res = mergeYAMLFields(res, yamlMappingFields(path, v))
+			continue
+		}
+		pos := fmt.Sprintf("%s:%d", path, v.lineNo)
+		f := &yamlField{name: key, lineNo: v.lineNo}
+		switch v.kind {
+		case yamlScalar:
+			f.values = []string{v.value}
+		case yamlSequence:
+			for _, item := range v.values {
+				panicIf(item.kind != yamlScalar, "%s: value of '%s' must be a list of strings\n", pos, key)
+				f.values = append(f.values, item.value)
+			}
+		default:
+			panicIf(true, "%s: value of '%s' must be a string or a list of strings\n", pos, key)
+		}
+		for _, s := range f.values {
+			panicIf(strings.ContainsAny(s, "\r\n"), "%s: value of '%s' can't have new lines\n", pos, key)
+		}
+		res = mergeYAMLFields(res, []*yamlField{f})
+	}
+	return res
+}
+
+// mergeYAMLFields returns fields with overrides replacing fields of the
+// same name
+func mergeYAMLFields(fields []*yamlField, overrides []*yamlField) []*yamlField {
+	var res []*yamlField
+	for _, f := range fields {
+		overridden := false
+		for _, o := range overrides {
+			overridden = overridden || strings.EqualFold(f.name, o.name)
+		}
+		if !overridden {
+			res = append(res, f)
+		}
+	}
+	return append(res, overrides...)
+}
+
+func yamlSuiteToLines(path string, suite *yamlNode, inherited []*yamlField) []TestLine {
+	pos := fmt.Sprintf("%s:%d", path, suite.lineNo)
+	if d := suite.get("defaults"); d != nil {
+		inherited = mergeYAMLFields(inherited, yamlMappingFields(path, d))
+	}
+	tests := suite.get("tests")
+	panicIf(tests == nil || tests.kind != yamlSequence, "%s: suite must have tests: with a list of tests\n", pos)
+	var res []TestLine
+	for _, item := range tests.values {
+		panicIf(item.kind != yamlMapping, "%s:%d: expected a test or a suite\n", path, item.lineNo)
+		if item.get("tests") != nil {
+			res = append(res, yamlSuiteToLines(path, item, inherited)...)
+			continue
+		}
+		fields := mergeYAMLFields(inherited, yamlMappingFields(path, item))
+		for _, f := range fields {
+			lineNo := f.lineNo
+			if lineNo < item.lineNo {
+				// so that errors point at the test and not at defaults
+				lineNo = item.lineNo
+			}
+			for _, v := range f.values {
+				res = append(res, TestLine{Text: f.name + ": " + v, LineNo: lineNo})
+			}
+		}
+		// empty line separates tests
+		res = append(res, TestLine{LineNo: item.lineNo})
+	}
+	return res
+}
+
+// yamlToTestLines converts .yaml tests file to lines of tests.txt format
+func yamlToTestLines(path string, d []byte) []TestLine {
+	root := parseYAML(path, d)
+	panicIf(root.kind != yamlMapping, "%s: expected a mapping with tests:\n", path)
+	var res []TestLine
+	if v := root.get("version"); v != nil {
+		panicIf(v.kind != yamlScalar, "%s:%d: version: must be a number\n", path, v.lineNo)
+		res = versionToTestLines(v.value, v.lineNo)
+	}
+	return append(res, yamlSuiteToLines(path, root, nil)...)
+}
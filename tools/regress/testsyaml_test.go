@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// yamlNodeString shows n in flow style e.g. {a: x, b: [1, 2]}
+func yamlNodeString(n *yamlNode) string {
+	switch n.kind {
+	case yamlMapping:
+		var parts []string
+		for i, k := range n.keys {
+			parts = append(parts, k+": "+yamlNodeString(n.values[i]))
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case yamlSequence:
+		var parts []string
+		for _, v := range n.values {
+			parts = append(parts, yamlNodeString(v))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return n.value
+}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		yaml string
+		exp  string
+	}{
+		{"a: x\nb: y z\n", "{a: x, b: y z}"},
+		{"a:\n  b: x\n  c:\n    d: y\ne: z", "{a: {b: x, c: {d: y}}, e: z}"},
+		{"a:\n  - x\n  - y\n", "{a: [x, y]}"},
+		{"a:\n- x\n- y\n", "{a: [x, y]}"},
+		{"a: [x, \"y, z\", 'w']", "{a: [x, y, z, w]}"},
+		{"a: []", "{a: []}"},
+		{"- a: x\n  b: y\n- c: z\n", "[{a: x, b: y}, {c: z}]"},
+		{"a: x # comment\n# comment\nb: \"#y\"", "{a: x, b: #y}"},
+		{"a: 'it''s'\nb: \"q\\\"t\"", "{a: it's, b: q\"t}"},
+		{"---\na:\n", "{a: }"},
+		{"a: &x\n  b: y\nc: *x\n", "{a: {b: y}, c: {b: y}}"},
+		{"a: &x v\nc: *x\n", "{a: v, c: v}"},
+		{"a: x\r\nb: y\r\n", "{a: x, b: y}"},
+	}
+	for _, tc := range tests {
+		got := yamlNodeString(parseYAML("tests.yaml", []byte(tc.yaml)))
+		if got != tc.exp {
+			t.Errorf("%q: got '%s', expected '%s'", tc.yaml, got, tc.exp)
+		}
+	}
+}
+
+func TestYAMLToTestLines(t *testing.T) {
+	yaml := `common:
+  pdf: &pdf
+    Url: https://example.com/f.pdf
+    Sha1: 735c700545cf48bac8665768739e10e3a950ba33
+
+defaults:
+  Cmd: SumatraPDF.exe -render 1 $file
+tests:
+  - <<: *pdf
+    Out: "rendering page 1 for '$file'"
+  - defaults:
+      Cmd: SumatraPDF.exe -extract-text 1 $file
+    tests:
+      - <<: *pdf
+        Out: hello
+        Env: [A=1, B=2]
+`
+	exp := []string{
+		"Cmd: SumatraPDF.exe -render 1 $file",
+		"Url: https://example.com/f.pdf",
+		"Sha1: 735c700545cf48bac8665768739e10e3a950ba33",
+		"Out: rendering page 1 for '$file'",
+		"",
+		"Cmd: SumatraPDF.exe -extract-text 1 $file",
+		"Url: https://example.com/f.pdf",
+		"Sha1: 735c700545cf48bac8665768739e10e3a950ba33",
+		"Out: hello",
+		"Env: A=1",
+		"Env: B=2",
+		"",
+	}
+	var got []string
+	for _, l := range yamlToTestLines("tests.yaml", []byte(yaml)) {
+		got = append(got, l.Text)
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+With -otlp-endpoint (or OTEL_EXPORTER_OTLP_ENDPOINT) we send spans for
+the run, downloads, tests and comparisons to OpenTelemetry collector,
+using OTLP/HTTP with JSON encoding.
+
+To join runs on multiple agents into a single trace, pass W3C
+traceparent of the parent span in TRACEPARENT env variable.
+*/
+
+// Span is a traced operation
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Name     string
+	Start    time.Time
+	End      time.Time
+	Attrs    map[string]string
+}
+
+var (
+	spansMu      sync.Mutex
+	pendingSpans []*Span
+	runSpan      *Span
+)
+
+func isTracingEnabled() bool {
+	return flgOtlpEndpoint != ""
+}
+
+func randomHex(n int) string {
+	d := make([]byte, n)
+	rand.Read(d)
+	return hex.EncodeToString(d)
+}
+
+// parseTraceParent parses "00-${traceId}-${spanId}-${flags}"
+func parseTraceParent(s string) (string, string, bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// startSpan returns nil if tracing is disabled, all Span methods accept nil
+func startSpan(parent *Span, name string) *Span {
+	if !isTracingEnabled() {
+		return nil
+	}
+	s := &Span{
+		SpanID: randomHex(8),
+		Name:   name,
+		Start:  time.Now(),
+		Attrs:  map[string]string{},
+	}
+	if parent != nil {
+		s.TraceID = parent.TraceID
+		s.ParentID = parent.SpanID
+	} else if traceID, spanID, ok := parseTraceParent(os.Getenv("TRACEPARENT")); ok {
+		s.TraceID = traceID
+		s.ParentID = spanID
+	} else {
+		s.TraceID = randomHex(16)
+	}
+	return s
+}
+
+func (s *Span) SetAttr(key string, val interface{}) {
+	if s == nil {
+		return
+	}
+	s.Attrs[key] = fmt.Sprintf("%v", val)
+}
+
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	spansMu.Lock()
+	pendingSpans = append(pendingSpans, s)
+	n := len(pendingSpans)
+	spansMu.Unlock()
+	if n >= 256 {
+		flushSpans()
+	}
+}
+
+func spanToOtlp(s *Span) map[string]interface{} {
+	var attrs []interface{}
+	for k, v := range s.Attrs {
+		attr := map[string]interface{}{
+			"key":   k,
+			"value": map[string]string{"stringValue": v},
+		}
+		attrs = append(attrs, attr)
+	}
+	res := map[string]interface{}{
+		"traceId":           s.TraceID,
+		"spanId":            s.SpanID,
+		"name":              s.Name,
+		"kind":              1, // SPAN_KIND_INTERNAL
+		"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.End.UnixNano(), 10),
+		"attributes":        attrs,
+	}
+	if s.ParentID != "" {
+		res["parentSpanId"] = s.ParentID
+	}
+	return res
+}
+
+func flushSpans() {
+	spansMu.Lock()
+	spans := pendingSpans
+	pendingSpans = nil
+	spansMu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	var otlpSpans []interface{}
+	for _, s := range spans {
+		otlpSpans = append(otlpSpans, spanToOtlp(s))
+	}
+	hostName, _ := os.Hostname()
+	v := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{
+						map[string]interface{}{"key": "service.name", "value": map[string]string{"stringValue": "regress"}},
+						map[string]interface{}{"key": "host.name", "value": map[string]string{"stringValue": hostName}},
+					},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "regress"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+	d, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	uri := strings.TrimSuffix(flgOtlpEndpoint, "/") + "/v1/traces"
+	rsp, err := http.Post(uri, "application/json", bytes.NewReader(d))
+	if err != nil {
+		fmt.Printf("failed to send %d spans to '%s': %s\n", len(spans), uri, err)
+		return
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		fmt.Printf("failed to send %d spans to '%s': %s\n", len(spans), uri, rsp.Status)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+regress triage steps through failures of the last run (-results) and for
+each lets you accept the new output (updates Out: in the test file),
+mark it as a known failure (appends to -known-failures) or keep it failing.
+*/
+
+var (
+	stdinReader = bufio.NewReader(os.Stdin)
+)
+
+func readLine(prompt string) string {
+	fmt.Print(prompt)
+	s, _ := stdinReader.ReadString('\n')
+	return strings.TrimSpace(s)
+}
+
+// replaceLineInFile replaces line lineNo (1-based) with s
+func replaceLineInFile(path string, lineNo int, s string) error {
+	d, err := ioutil.ReadFile(longPath(path))
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(d), "\n")
+	if lineNo < 1 || lineNo > len(lines) {
+		return fmt.Errorf("%s: no line %d", path, lineNo)
+	}
+	// preserve \r of files with windows line endings
+	if strings.HasSuffix(lines[lineNo-1], "\r") {
+		s += "\r"
+	}
+	lines[lineNo-1] = s
+	return ioutil.WriteFile(longPath(path), []byte(strings.Join(lines, "\n")), 0644)
+}
+
+func appendToFile(path string, s string) error {
+	f, err := os.OpenFile(longPath(path), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(s)
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+// only failures caused by different output can be fixed by accepting output
+func canAcceptOutput(r *TestResult) bool {
+	if r.Error != "" || r.InfraError != "" || r.OracleMismatch != "" {
+		return false
+	}
+	return !strings.Contains(r.Output, "\n")
+}
+
+// outputToExpected replaces path of the test file with $file
+func outputToExpected(r *TestResult) string {
+	if r.FilePath == "" {
+		return r.Output
+	}
+	return strings.Replace(r.Output, r.FilePath, "$file", -1)
+}
+
+func showTriageInfo(t *Test, r *TestResult) {
+	fmt.Printf("Cmd: %s\n", t.CmdUnparsed)
+	if r.InfraError != "" {
+		fmt.Printf("infrastructure error: %s\n", r.InfraError)
+	}
+	if r.Error != "" {
+		fmt.Printf("process exited with error: %s\n", r.Error)
+	}
+	if r.OracleMismatch != "" {
+		fmt.Printf("oracle mismatch: %s\n", r.OracleMismatch)
+		dir, err := getOracleDir(t)
+		if err == nil {
+			files, _ := ioutil.ReadDir(longPath(dir))
+			for _, fi := range files {
+				fmt.Printf("  %s\n", filepath.Join(dir, fi.Name()))
+			}
+		}
+	}
+	fmt.Printf("diff of expected and got output:\n-----\n%s\n-----\n", diffStrings(t.ExpectedOutput, outputToExpected(r)))
+}
+
+func triageMarkKnownFailure(t *Test) {
+	bug := readLine("bug url: ")
+	if bug == "" || strings.Contains(bug, " ") {
+		fmt.Printf("invalid bug url, keeping it failing\n")
+		return
+	}
+	expires := time.Now().AddDate(0, 0, 90).Format(dateFormat)
+	s := readLine(fmt.Sprintf("expires [%s]: ", expires))
+	if s != "" {
+		if _, err := time.Parse(dateFormat, s); err != nil {
+			fmt.Printf("invalid date '%s', keeping it failing\n", s)
+			return
+		}
+		expires = s
+	}
+	l := fmt.Sprintf("%s %s %s\n", expires, bug, testDisplayName(t))
+	err := appendToFile(flgKnownFailures, l)
+	if err != nil {
+		fmt.Printf("failed to update '%s': %s\n", flgKnownFailures, err)
+		return
+	}
+	fmt.Printf("added to '%s'\n", flgKnownFailures)
+}
+
+func triageAcceptOutput(t *Test, r *TestResult) {
+	if t.registered {
+		fmt.Printf("test at %s is registered in Go code, edit it manually\n", testPos(t))
+		return
+	}
+	if t.OutLineNo == 0 {
+		fmt.Printf("test at %s doesn't have Out:, edit it manually\n", testPos(t))
+		return
+	}
+	if isJSONTestsFile(t.Path) || isYAMLTestsFile(t.Path) {
+		fmt.Printf("test at %s is not in .txt file, edit it manually\n", testPos(t))
+		return
+	}
+	if strings.Contains(t.ExpectedOutput, "\n") {
+		fmt.Printf("test at %s has multi-line Out:, edit it manually\n", testPos(t))
+		return
+	}
+	l := "Out: " + outputToExpected(r)
+	err := replaceLineInFile(t.Path, t.OutLineNo, l)
+	if err != nil {
+		fmt.Printf("failed to update '%s': %s\n", t.Path, err)
+		return
+	}
+	fmt.Printf("updated %s:%d\n", t.Path, t.OutLineNo)
+}
+
+func triage() {
+	res, err := loadRunResults(flgResults)
+	fatalIfErr(err)
+	tests := parseTestsMust(flgTests)
+	known := loadKnownFailures(tests)
+	// with the same -matrix as the run so that failed variants match
+	tests = expandMatrix(tests)
+	applyKnownFailures(tests, known)
+	byKey := map[string]*Test{}
+	for _, t := range tests {
+		byKey[testID(t)] = t
+	}
+	var failed []*TestResult
+	for _, r := range res.Tests {
+		t := byKey[resultID(r)]
+		if r.Failed && t != nil && t.KnownFailure == nil {
+			failed = append(failed, r)
+		}
+	}
+	fmt.Printf("%d failures to triage in '%s'\n", len(failed), flgResults)
+	for i, r := range failed {
+		t := byKey[resultID(r)]
+		fmt.Printf("\n[%d/%d] %s (%s)\n", i+1, len(failed), testDisplayName(t), testPos(t))
+		showTriageInfo(t, r)
+		prompt := "[k]nown failure, keep [f]ailing, [q]uit: "
+		if canAcceptOutput(r) {
+			prompt = "[a]ccept output, " + prompt
+		}
+		switch readLine(prompt) {
+		case "a":
+			if canAcceptOutput(r) {
+				triageAcceptOutput(t, r)
+			}
+		case "k":
+			triageMarkKnownFailure(t)
+		case "q":
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+A tests file can say which version of the format it uses, in its own block
+before the first test:
+
+Version: 1
+
+In .json it's "version": 1 and in .yaml version: 1 at the top level.
+
+When the format changes in a way that older regress would misunderstand
+(e.g. a new required field or a field that means something else) we bump
+testsFormatVersion and files that use the change declare the new version.
+Older regress then refuses to run them instead of silently running tests
+differently. Files without Version: are version 1. Each included file has
+its own version.
+*/
+
+// the newest version of tests file format this regress understands
+const testsFormatVersion = 1
+
+// parseVersionLine returns value of Version: line
+func parseVersionLine(text string) (string, bool) {
+	parts := strings.SplitN(text, ":", 2)
+	if len(parts) != 2 || !strings.EqualFold(strings.TrimSpace(parts[0]), "version") {
+		return "", false
+	}
+	return strings.TrimSpace(parts[1]), true
+}
+
+func verifyTestsFormatVersionMust(pos string, val string) {
+	ver, err := strconv.Atoi(val)
+	panicIf(err != nil || ver < 1, "%s: Version: must be a number >= 1, got '%s'\n", pos, val)
+	panicIf(ver > testsFormatVersion, "%s: Version: %d is not supported by this regress (supports up to %d), update tools/regress\n", pos, ver, testsFormatVersion)
+}
+
+// versionToTestLines is for .json and .yaml files, Version: is in its own
+// block like in .txt
+func versionToTestLines(val string, lineNo int) []TestLine {
+	return []TestLine{
+		{Text: "Version: " + val, LineNo: lineNo},
+		{LineNo: lineNo},
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+A crash of a test process shows Windows Error Reporting dialog which
+blocks unattended runs until someone clicks it. During the run we tell
+WER not to show the UI and to save a crash dump locally instead (see
+wer_windows.go). The registry is restored at the end of the run, also
+when regress fails or is interrupted with Ctrl+C.
+-crash-dialogs disables this.
+*/
+
+func getWerDumpsDir() (string, error) {
+	d, err := filepath.Abs(filepath.Join("out", "regress", "dumps", "wer"))
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(longPath(d), 0755)
+	return d, err
+}
+
+func testExeNames(tests []*Test) []string {
+	var res []string
+	seen := map[string]bool{}
+	for _, t := range tests {
+		name := filepath.Base(t.CmdName)
+		if !seen[name] {
+			seen[name] = true
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// collectCrashDump adds dump saved by WER for a crashed process to artifacts.
+// WER names them ${exe}.${pid}.dmp.
+func collectCrashDump(t *Test, pid int) {
+	dir, err := getWerDumpsDir()
+	if err != nil {
+		return
+	}
+	files, err := ioutil.ReadDir(longPath(dir))
+	if err != nil {
+		return
+	}
+	suffix := fmt.Sprintf(".%d.dmp", pid)
+	for _, fi := range files {
+		if strings.HasSuffix(fi.Name(), suffix) {
+			path := filepath.Join(dir, fi.Name())
+			fmt.Printf("crash dump: '%s'\n", path)
+			t.Artifacts = append(t.Artifacts, path)
+			symbolizeDump(t, path)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+func suppressCrashDialogs(exeNames []string) {
+}
+
+func restoreCrashDialogs() {
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+var (
+	modadvapi32         = syscall.NewLazyDLL("advapi32.dll")
+	procRegCreateKeyExW = modadvapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueExW  = modadvapi32.NewProc("RegSetValueExW")
+	procRegDeleteValueW = modadvapi32.NewProc("RegDeleteValueW")
+	procRegDeleteKeyW   = modadvapi32.NewProc("RegDeleteKeyW")
+	procSetErrorMode    = modkernel32.NewProc("SetErrorMode")
+)
+
+const (
+	semFailCriticalErrors = 0x0001
+	semNoOpenFileErrorBox = 0x8000
+	regCreatedNewKey      = 1
+	werKeyPath            = `Software\Microsoft\Windows\Windows Error Reporting`
+	werDumpTypeFull       = 2
+	werDumpCount          = 10
+)
+
+var (
+	// undo functions for registry changes, run in reverse order
+	crashDialogsUndo []func()
+	// restoreCrashDialogs can run on Ctrl+C while the run ends
+	crashDialogsMu          sync.Mutex
+	crashDialogsSignalsOnce sync.Once
+)
+
+func regStringData(s string) []byte {
+	u := utf16.Encode([]rune(s + "\x00"))
+	d := make([]byte, len(u)*2)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(d[i*2:], c)
+	}
+	return d
+}
+
+func regDwordData(n uint32) []byte {
+	d := make([]byte, 4)
+	binary.LittleEndian.PutUint32(d, n)
+	return d
+}
+
+func regOpenKey(root syscall.Handle, path string) (syscall.Handle, error) {
+	var h syscall.Handle
+	err := syscall.RegOpenKeyEx(root, syscall.StringToUTF16Ptr(path), 0, syscall.KEY_QUERY_VALUE|syscall.KEY_SET_VALUE, &h)
+	return h, err
+}
+
+// regCreateKey creates key and its parents, remembering to delete the
+// ones that didn't exist
+func regCreateKey(root syscall.Handle, path string) (syscall.Handle, error) {
+	parts := strings.Split(path, `\`)
+	for i := range parts {
+		subPath := strings.Join(parts[:i+1], `\`)
+		var h syscall.Handle
+		var disposition uint32
+		r, _, _ := procRegCreateKeyExW.Call(uintptr(root), uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(subPath))), 0, 0, 0,
+			syscall.KEY_QUERY_VALUE|syscall.KEY_SET_VALUE, 0, uintptr(unsafe.Pointer(&h)), uintptr(unsafe.Pointer(&disposition)))
+		if r != 0 {
+			return 0, syscall.Errno(r)
+		}
+		if disposition == regCreatedNewKey {
+			crashDialogsUndo = append(crashDialogsUndo, func() {
+				procRegDeleteKeyW.Call(uintptr(root), uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(subPath))))
+			})
+		}
+		if i < len(parts)-1 {
+			syscall.RegCloseKey(h)
+			continue
+		}
+		return h, nil
+	}
+	return 0, fmt.Errorf("empty registry path")
+}
+
+func regSetValue(h syscall.Handle, name string, typ uint32, data []byte) error {
+	r, _, _ := procRegSetValueExW.Call(uintptr(h), uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(name))), 0,
+		uintptr(typ), uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)))
+	if r != 0 {
+		return syscall.Errno(r)
+	}
+	return nil
+}
+
+// regSetValueWithUndo sets a value, remembering how to restore the old one
+func regSetValueWithUndo(root syscall.Handle, path string, name string, typ uint32, data []byte) error {
+	h, err := regCreateKey(root, path)
+	if err != nil {
+		return err
+	}
+	defer syscall.RegCloseKey(h)
+	namePtr := syscall.StringToUTF16Ptr(name)
+	var oldType, n uint32
+	err = syscall.RegQueryValueEx(h, namePtr, nil, &oldType, nil, &n)
+	var oldData []byte
+	if err == nil && n > 0 {
+		oldData = make([]byte, n)
+		err = syscall.RegQueryValueEx(h, namePtr, nil, &oldType, &oldData[0], &n)
+	}
+	existed := err == nil
+	if err != nil && err != syscall.ERROR_FILE_NOT_FOUND {
+		return err
+	}
+	err = regSetValue(h, name, typ, data)
+	if err != nil {
+		return err
+	}
+	crashDialogsUndo = append(crashDialogsUndo, func() {
+		h, err := regOpenKey(root, path)
+		if err != nil {
+			return
+		}
+		defer syscall.RegCloseKey(h)
+		if existed && len(oldData) > 0 {
+			regSetValue(h, name, oldType, oldData)
+			return
+		}
+		procRegDeleteValueW.Call(uintptr(h), uintptr(unsafe.Pointer(namePtr)))
+	})
+	return nil
+}
+
+// suppressCrashDialogs tells WER to not show crash dialog and save crash
+// dumps of exeNames. Dumps need admin rights because LocalDumps settings
+// are only read from HKLM.
+func suppressCrashDialogs(exeNames []string) {
+	if flgCrashDialogs {
+		return
+	}
+	restoreCrashDialogsOnSignal()
+	crashDialogsMu.Lock()
+	defer crashDialogsMu.Unlock()
+	// inherited by child processes
+	procSetErrorMode.Call(semFailCriticalErrors | semNoOpenFileErrorBox)
+	err := regSetValueWithUndo(syscall.HKEY_CURRENT_USER, werKeyPath, "DontShowUI", syscall.REG_DWORD, regDwordData(1))
+	if err != nil {
+		fmt.Printf("failed to disable crash dialogs: %s\n", err)
+	}
+	dir, err := getWerDumpsDir()
+	if err != nil {
+		fmt.Printf("failed to create dir for crash dumps: %s\n", err)
+		return
+	}
+	for _, name := range exeNames {
+		path := werKeyPath + `\LocalDumps\` + name
+		root := syscall.Handle(syscall.HKEY_LOCAL_MACHINE)
+		err = regSetValueWithUndo(root, path, "DumpFolder", syscall.REG_EXPAND_SZ, regStringData(dir))
+		if err == nil {
+			err = regSetValueWithUndo(root, path, "DumpType", syscall.REG_DWORD, regDwordData(werDumpTypeFull))
+		}
+		if err == nil {
+			err = regSetValueWithUndo(root, path, "DumpCount", syscall.REG_DWORD, regDwordData(werDumpCount))
+		}
+		if err != nil {
+			fmt.Printf("failed to enable crash dumps for '%s' (needs admin): %s\n", name, err)
+			return
+		}
+	}
+}
+
+// restoreCrashDialogsOnSignal makes Ctrl+C restore the registry, otherwise
+// crash dialogs would stay disabled for the whole machine. fatalf and
+// panicIf call restoreCrashDialogs themselves.
+func restoreCrashDialogsOnSignal() {
+	crashDialogsSignalsOnce.Do(func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-c
+			fmt.Printf("got %s, restoring crash dialogs\n", sig)
+			restoreCrashDialogs()
+			os.Exit(1)
+		}()
+	})
+}
+
+// restoreCrashDialogs undoes registry changes of suppressCrashDialogs
+func restoreCrashDialogs() {
+	crashDialogsMu.Lock()
+	defer crashDialogsMu.Unlock()
+	for i := len(crashDialogsUndo) - 1; i >= 0; i-- {
+		crashDialogsUndo[i]()
+	}
+	crashDialogsUndo = nil
+}